@@ -0,0 +1,355 @@
+/*
+* 用户邮箱验证和找回密码
+*
+* 验证邮箱和重置密码的 token 都是一次性的，通过邮件发给用户，链接指向前端页面，
+* 前端再把 token 提交到对应的接口。只有验证过的邮箱才能用于找回密码
+ */
+
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	log "github.com/sirupsen/logrus"
+	"github.com/ztplz/blog-server/middlewares"
+	"github.com/ztplz/blog-server/models"
+)
+
+const (
+	// 前端重置密码和验证邮箱的页面
+	passwordResetPath = "/user/password/reset"
+	emailVerifyPath   = "/user/email/verify"
+
+	// token 的有效时间
+	passwordResetTTL = 30 * time.Minute
+	emailVerifyTTL   = 24 * time.Hour
+
+	// 同一个 IP 一小时内最多申请 5 次找回密码
+	passwordForgotLimit  = 5
+	passwordForgotWindow = time.Hour
+)
+
+// ForgotPasswordForm 找回密码表单, 填写用户 ID 或者已验证的邮箱
+type ForgotPasswordForm struct {
+	UserID string `form:"user_id" json:"user_id"`
+	Email  string `form:"email" json:"email"`
+}
+
+// ResetPasswordForm 重置密码表单
+type ResetPasswordForm struct {
+	Token    string `form:"token" json:"token" binding:"required"`
+	Password string `form:"password" json:"password" binding:"required"`
+}
+
+// UserEmailForm 修改邮箱表单
+type UserEmailForm struct {
+	Email string `form:"email" json:"email" binding:"required"`
+}
+
+// VerifyEmailForm 验证邮箱表单
+type VerifyEmailForm struct {
+	Token string `form:"token" json:"token" binding:"required"`
+}
+
+// ForgotPasswordHandler 找回密码, 向已验证的邮箱发送重置密码的邮件
+// 不管账号是否存在都返回成功, 避免被用来探测账号
+func ForgotPasswordHandler(c *gin.Context) {
+	var forgotVals ForgotPasswordForm
+
+	ok := checkRateLimit(c, "password_forgot_rate_"+c.ClientIP(), passwordForgotLimit, passwordForgotWindow, "Forgot password failed")
+	if !ok {
+		return
+	}
+
+	err := c.ShouldBindWith(&forgotVals, binding.JSON)
+	userID := strings.TrimSpace(forgotVals.UserID)
+	email := strings.TrimSpace(forgotVals.Email)
+	if err != nil || (userID == "" && email == "") {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"statusCode": http.StatusBadRequest,
+			"message":    "请填写用户ID或者邮箱",
+		})
+		c.AbortWithStatus(http.StatusBadRequest)
+		log.WithFields(log.Fields{
+			"errorMsg":   err,
+			"statusCode": http.StatusBadRequest,
+		}).Info("Forgot password failed")
+
+		return
+	}
+
+	var user *models.User
+	if userID != "" {
+		user, err = models.GetUserByUserID(userID)
+	} else {
+		user, err = models.GetUserByEmail(email)
+	}
+
+	if err == nil && user.EmailVerified && user.Email != "" && !user.IsBanned() {
+		sendPasswordResetMail(SiteURL, user)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"statusCode": http.StatusOK,
+		"message":    "如果账号存在并且邮箱已验证, 重置密码的邮件已经发送",
+	})
+
+	log.WithFields(log.Fields{
+		"userID":     userID,
+		"email":      email,
+		"errorMsg":   err,
+		"statusCode": http.StatusOK,
+	}).Info("Forgot password success")
+}
+
+// ResetPasswordHandler 用邮件里的 token 重置密码, 重置后用户需要重新登录
+func ResetPasswordHandler(c *gin.Context) {
+	var resetVals ResetPasswordForm
+
+	err := c.ShouldBindWith(&resetVals, binding.JSON)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"statusCode": http.StatusBadRequest,
+			"message":    "token 和密码不能为空",
+		})
+		c.AbortWithStatus(http.StatusBadRequest)
+		log.WithFields(log.Fields{
+			"errorMsg":   err,
+			"statusCode": http.StatusBadRequest,
+		}).Info("Reset password failed")
+
+		return
+	}
+
+	password, err := checkUserString(resetVals.Password)
+	if err != nil || !checkUserPasswordLength(password) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"statusCode": http.StatusBadRequest,
+			"message":    "密码必须由字母、数字组成并且符合规定长度",
+		})
+		c.AbortWithStatus(http.StatusBadRequest)
+		log.WithFields(log.Fields{
+			"errorMsg":   err,
+			"statusCode": http.StatusBadRequest,
+		}).Info("Reset password failed")
+
+		return
+	}
+
+	token, ok := consumeUserToken(c, models.UserTokenPasswordReset, resetVals.Token, "Reset password failed")
+	if !ok {
+		return
+	}
+
+	err = models.UpdateUserPassword(token.UserID, password)
+	if err != nil {
+		abortUserError(c, err, "Reset password failed")
+
+		return
+	}
+
+	// 密码修改后之前的登录全部失效
+	revokeUserToken(token.UserID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"statusCode": http.StatusOK,
+		"message":    "密码已重置, 请重新登录",
+	})
+
+	log.WithFields(log.Fields{
+		"userID":     token.UserID,
+		"statusCode": http.StatusOK,
+	}).Info("Reset password success")
+}
+
+// UpdateUserEmailHandler 用户修改邮箱, 新邮箱需要通过邮件验证
+func UpdateUserEmailHandler(c *gin.Context) {
+	var emailVals UserEmailForm
+
+	userID := c.Param("userID")
+	middlewares.AuditBefore(c, *middlewares.CurrentUser(c))
+
+	err := c.ShouldBindWith(&emailVals, binding.JSON)
+	if err != nil {
+		abortUserEmail(c, err, "邮箱不能为空")
+
+		return
+	}
+
+	email, ok := checkUserEmail(c, userID, emailVals.Email)
+	if !ok {
+		return
+	}
+
+	err = models.UpdateUserEmail(userID, email)
+	if err != nil {
+		abortUserError(c, err, "Update user email failed")
+
+		return
+	}
+	auditUserAfter(c, userID)
+
+	sendEmailVerifyMail(SiteURL, userID, email)
+
+	c.JSON(http.StatusOK, gin.H{
+		"statusCode": http.StatusOK,
+		"message":    "验证邮件已经发送",
+		"email":      email,
+	})
+
+	log.WithFields(log.Fields{
+		"userID":     userID,
+		"statusCode": http.StatusOK,
+	}).Info("Update user email success")
+}
+
+// VerifyEmailHandler 用邮件里的 token 验证邮箱
+func VerifyEmailHandler(c *gin.Context) {
+	var verifyVals VerifyEmailForm
+
+	err := c.ShouldBindWith(&verifyVals, binding.JSON)
+	if err != nil {
+		abortUserEmail(c, err, "token 不能为空")
+
+		return
+	}
+
+	token, ok := consumeUserToken(c, models.UserTokenEmailVerify, verifyVals.Token, "Verify email failed")
+	if !ok {
+		return
+	}
+
+	// 邮箱可能在发送验证邮件之后被别的用户验证了
+	used, err := models.CheckEmailUsed(token.UserID, token.Value)
+	if err == nil && used {
+		abortUserEmail(c, nil, "该邮箱已经被使用")
+
+		return
+	}
+	if err == nil {
+		err = models.VerifyUserEmail(token.UserID, token.Value)
+	}
+	if err != nil {
+		abortUserError(c, err, "Verify email failed")
+
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"statusCode": http.StatusOK,
+		"message":    "邮箱验证成功",
+	})
+
+	log.WithFields(log.Fields{
+		"userID":     token.UserID,
+		"statusCode": http.StatusOK,
+	}).Info("Verify email success")
+}
+
+// 检查邮箱格式, 以及是否已经被别的用户验证
+func checkUserEmail(c *gin.Context, userID string, email string) (string, bool) {
+	address, err := mail.ParseAddress(strings.TrimSpace(email))
+	if err != nil || address.Name != "" {
+		abortUserEmail(c, err, "邮箱格式不正确")
+
+		return "", false
+	}
+
+	used, err := models.CheckEmailUsed(userID, address.Address)
+	if err != nil {
+		abortUserError(c, err, "Check user email failed")
+
+		return "", false
+	}
+	if used {
+		abortUserEmail(c, nil, "该邮箱已经被使用")
+
+		return "", false
+	}
+
+	return address.Address, true
+}
+
+// 使用一次性 token, token 无效时返回 400
+func consumeUserToken(c *gin.Context, kind string, value string, logMsg string) (*models.UserToken, bool) {
+	token, err := models.ConsumeUserToken(kind, value)
+	if err == models.ErrUserTokenInvalid {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"statusCode": http.StatusBadRequest,
+			"message":    "链接无效或者已经过期",
+		})
+		c.AbortWithStatus(http.StatusBadRequest)
+		log.WithFields(log.Fields{
+			"errorMsg":   err,
+			"statusCode": http.StatusBadRequest,
+		}).Info(logMsg)
+
+		return nil, false
+	}
+	if err != nil {
+		abortUserError(c, err, logMsg)
+
+		return nil, false
+	}
+
+	return token, true
+}
+
+func abortUserEmail(c *gin.Context, err error, message string) {
+	c.JSON(http.StatusBadRequest, gin.H{
+		"statusCode": http.StatusBadRequest,
+		"message":    message,
+	})
+	c.AbortWithStatus(http.StatusBadRequest)
+	log.WithFields(log.Fields{
+		"errorMsg":   err,
+		"message":    message,
+		"statusCode": http.StatusBadRequest,
+	}).Info("User email incorrect")
+}
+
+// 发送验证邮箱的邮件
+func sendEmailVerifyMail(base string, userID string, email string) {
+	token, err := models.CreateUserToken(models.UserTokenEmailVerify, userID, email, emailVerifyTTL)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+			"userID":   userID,
+		}).Info("Create email verify token failed")
+
+		return
+	}
+
+	body := fmt.Sprintf("%s 你好,\n\n请打开下面的链接验证你的邮箱, 链接 %s 内有效:\n\n%s\n\n如果不是你本人的操作, 请忽略这封邮件。\n",
+		userID,
+		emailVerifyTTL,
+		base+emailVerifyPath+"?token="+token)
+
+	sendMail(email, "验证你的邮箱", body)
+}
+
+// 发送重置密码的邮件
+func sendPasswordResetMail(base string, user *models.User) {
+	token, err := models.CreateUserToken(models.UserTokenPasswordReset, user.UserID, "", passwordResetTTL)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+			"userID":   user.UserID,
+		}).Info("Create password reset token failed")
+
+		return
+	}
+
+	body := fmt.Sprintf("%s 你好,\n\n请打开下面的链接重置密码, 链接 %s 内有效, 只能使用一次:\n\n%s\n\n如果不是你本人的操作, 请忽略这封邮件。\n",
+		user.UserName,
+		passwordResetTTL,
+		base+passwordResetPath+"?token="+token)
+
+	sendMail(user.Email, "重置密码", body)
+}
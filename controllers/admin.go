@@ -1,7 +1,7 @@
 /*
 * admin controller
 *
-* token 1天后过期。每次使用延长6个小时，最长使用时效为一周
+* token 1天后过期，退出登录或者修改密码后立即失效
 *
 * author: ztplz
 * email: mysticzt@gmail.com
@@ -12,32 +12,23 @@
 package controllers
 
 import (
+	"database/sql"
 	"errors"
 	"net/http"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
-	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
 	"github.com/gin-gonic/gin/binding"
 	log "github.com/sirupsen/logrus"
 	"github.com/ztplz/blog-server/middlewares"
 	"github.com/ztplz/blog-server/models"
+	"github.com/ztplz/blog-server/token"
 	"golang.org/x/crypto/bcrypt"
 )
 
-// SigningAlgorithm token加密算法
-var SigningAlgorithm = "HS256"
-
-// secret key
-var secretKey = []byte("adminblog")
-
-// Timeout token持续时间, 设置为一周
-var Timeout = time.Hour * 24 * 7
-
-// var Timeout = time.Second * 3600
-
 type Admin struct {
 	ID          uint   `db:"id" json:"id"`
 	AdminID     string `db:"admin_id" json:"admin_id"`
@@ -52,6 +43,8 @@ type Admin struct {
 type AdminLoginForm struct {
 	AdminID  string `form:"admin_id" json:"admin_id" binding:"required"`
 	Password string `form:"password" json:"password" binding:"required"`
+	TOTPCode string `form:"totp_code" json:"totp_code"`
+	Device   string `form:"device" json:"device"`
 }
 
 // AdminUpdatePasswordForm 更改密码表单
@@ -67,7 +60,7 @@ type AdminUpdateInfoForm struct {
 	Image     string `form:"image" json:"image" binding:"required"`
 }
 
-// AdminLoginHandler 管理员登录，没有限制同一个ip的错误登录次数，容易被爆破，以后加登录序列
+// AdminLoginHandler 管理员登录，同一个账号或者 ip 错误次数过多时锁定一段时间
 func AdminLoginHandler(c *gin.Context) {
 	var loginVals AdminLoginForm
 
@@ -156,11 +149,16 @@ func AdminLoginHandler(c *gin.Context) {
 		return
 	}
 
+	// 账号或者 ip 被锁定时不验证密码
+	if !checkLoginLock(c, models.LoginGuardAdmin, adminID, "Admin login failed") {
+		return
+	}
+
 	// 从数据库查询密码
-	admin, err := models.AdminByID()
+	admin, err := models.AdminByAdminID(adminID)
 
 	// 数据查询失败
-	if err != nil {
+	if err != nil && err != sql.ErrNoRows {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"statusCode": http.StatusInternalServerError,
 			"message":    http.StatusText(http.StatusInternalServerError),
@@ -175,7 +173,8 @@ func AdminLoginHandler(c *gin.Context) {
 	}
 
 	// 管理员ID不存在
-	if admin.AdminID != adminID {
+	if err == sql.ErrNoRows {
+		recordLoginFailure(c, models.LoginGuardAdmin, adminID)
 		c.JSON(http.StatusBadRequest, gin.H{
 			"statusCode": http.StatusBadRequest,
 			"message":    "AdminID not exist",
@@ -196,6 +195,7 @@ func AdminLoginHandler(c *gin.Context) {
 	// 验证密码
 	err = bcrypt.CompareHashAndPassword([]byte(admin.Password), []byte(password))
 	if err != nil {
+		recordLoginFailure(c, models.LoginGuardAdmin, adminID)
 		c.JSON(http.StatusBadRequest, gin.H{
 			"statusCode": http.StatusBadRequest,
 			"message":    "Incorrect admin password",
@@ -213,36 +213,14 @@ func AdminLoginHandler(c *gin.Context) {
 		return
 	}
 
-	// 生成token
-	token := jwt.New(jwt.GetSigningMethod(SigningAlgorithm))
-	claims := token.Claims.(jwt.MapClaims)
-
-	// 设置token过期时间
-	expire := time.Now().Add(Timeout)
-	claims["id"] = loginVals.AdminID
-	claims["exp"] = expire.Unix()
-	claims["orig_iat"] = time.Now().Unix()
-
-	// 生成token
-	tokenString, err := token.SignedString(secretKey)
-
-	// 生成token失败
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"statusCode": http.StatusInternalServerError,
-			"message":    "Generate token failed",
-		})
-		c.AbortWithStatus(http.StatusInternalServerError)
-		log.WithFields(log.Fields{
-			"errorMsg":   "Generate token failed",
-			"statusCode": http.StatusInternalServerError,
-		}).Info("Admin login failed")
-
+	// 启用了两步验证时检查验证码或者恢复码
+	if !checkAdminSecondFactor(c, admin, loginVals.TOTPCode, "Admin login failed") {
 		return
 	}
+	clearLoginFailures(models.LoginGuardAdmin, adminID)
 
-	// 把管理员 token 放入 redis里, token不同步进数据库
-	err = models.RedisClient.Set("admin_token", tokenString, time.Hour*24).Err()
+	// 生成token
+	pair, err := token.IssueSession(token.RoleAdmin, strconv.FormatUint(uint64(admin.ID), 10), clientInfo(c, loginVals.Device))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"statusCode": http.StatusInternalServerError,
@@ -250,7 +228,7 @@ func AdminLoginHandler(c *gin.Context) {
 		})
 		c.AbortWithStatus(http.StatusInternalServerError)
 		log.WithFields(log.Fields{
-			"errorMsg":   "Store token to redis failed",
+			"errorMsg":   err,
 			"statusCode": http.StatusInternalServerError,
 		}).Info("Admin login failed")
 
@@ -259,10 +237,12 @@ func AdminLoginHandler(c *gin.Context) {
 
 	// 生成token成功
 	c.JSON(http.StatusOK, gin.H{
-		"statusCode": http.StatusOK,
-		"message":    "login success",
-		"token":      tokenString,
-		"max_expire": expire.Format(time.RFC3339),
+		"statusCode":     http.StatusOK,
+		"message":        "login success",
+		"token":          pair.AccessToken,
+		"max_expire":     pair.Claims.ExpireAt().Format(time.RFC3339),
+		"refresh_token":  pair.RefreshToken,
+		"refresh_expire": pair.RefreshExpireAt.Format(time.RFC3339),
 	})
 
 	// 记录成功登录时间和 ip
@@ -276,7 +256,7 @@ func AdminLoginHandler(c *gin.Context) {
 	}).Info("Admin login success")
 
 	// 存储登录成功的时间和 ip
-	err = models.UpdateTimeIP(currentTime, ip)
+	err = models.UpdateTimeIP(admin.ID, currentTime, ip)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"id":       loginVals.AdminID,
@@ -288,15 +268,13 @@ func AdminLoginHandler(c *gin.Context) {
 
 // GetAdminInfo 获取管理员信息
 func GetAdminInfo(c *gin.Context) {
-	// token 认证
-	admin, err := middlewares.AdminAuthMiddleware(c)
-	if err != nil {
-		return
-	}
+	admin := middlewares.CurrentAdmin(c)
 
 	// 验证成功，返回管理员信息
 	c.JSON(http.StatusOK, gin.H{
 		"statusCode":    http.StatusOK,
+		"id":            admin.ID,
+		"admin_id":      admin.AdminID,
 		"admin_name":    admin.AdminName,
 		"image":         admin.Image,
 		"last_login_in": admin.LastLoginAt,
@@ -315,15 +293,12 @@ func GetAdminInfo(c *gin.Context) {
 
 // AdminLogout 管理员退出
 func AdminLogout(c *gin.Context) {
-	// token 认证
-	_, err := middlewares.AdminAuthMiddleware(c)
-	if err != nil {
-		return
-	}
+	admin := middlewares.CurrentAdmin(c)
+	models.ClearAdminStepUp(admin.ID)
 
-	// 把 redis 里的 admin_token 设置为空字符串
-	err = models.RedisClient.Set("admin_token", "", 0).Err()
-	if err != nil {
+	// 只删除当前会话, 其他设备上的登录不受影响
+	err := token.RevokeSession(token.RoleAdmin, strconv.FormatUint(uint64(admin.ID), 10), middlewares.ExtractClaims(c).SessionID)
+	if err != nil && err != token.ErrSessionMissing {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"statusCode": http.StatusInternalServerError,
 			"message":    "Admin log out failed",
@@ -353,14 +328,16 @@ func AdminLogout(c *gin.Context) {
 func AdminUpdatePasswordhandler(c *gin.Context) {
 	var adminUpdatePasswordVals AdminUpdatePasswordForm
 
-	// token 认证
-	admin, err := middlewares.AdminAuthMiddleware(c)
-	if err != nil {
+	admin := middlewares.CurrentAdmin(c)
+	middlewares.AuditTarget(c, "admin", strconv.FormatUint(uint64(admin.ID), 10))
+
+	// 启用了两步验证时需要再次验证
+	if !checkAdminStepUp(c, admin, "Admin change password failed") {
 		return
 	}
 
 	// 检查是否绑定了 password field
-	err = c.ShouldBindWith(&adminUpdatePasswordVals, binding.JSON)
+	err := c.ShouldBindWith(&adminUpdatePasswordVals, binding.JSON)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"statusCode": http.StatusBadRequest,
@@ -425,7 +402,7 @@ func AdminUpdatePasswordhandler(c *gin.Context) {
 	}
 
 	// 更改成新密码
-	err = models.UpdateAdminPassword(newPassword)
+	err = models.UpdateAdminPassword(admin.ID, newPassword)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"statusCode": http.StatusInternalServerError,
@@ -440,7 +417,8 @@ func AdminUpdatePasswordhandler(c *gin.Context) {
 		return
 	}
 
-	err = models.RedisClient.Set("admin_token", "", 0).Err()
+	models.ClearAdminStepUp(admin.ID)
+	err = revokeAdminToken(admin)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"statusCode": http.StatusInternalServerError,
@@ -469,14 +447,17 @@ func AdminUpdatePasswordhandler(c *gin.Context) {
 func AdminUpdateInfoHandler(c *gin.Context) {
 	var adminUpdateInfoVals AdminUpdateInfoForm
 
-	// token 认证
-	_, err := middlewares.AdminAuthMiddleware(c)
-	if err != nil {
+	admin := middlewares.CurrentAdmin(c)
+	middlewares.AuditTarget(c, "admin", strconv.FormatUint(uint64(admin.ID), 10))
+	middlewares.AuditBefore(c, *admin)
+
+	// 修改登录用的管理员 ID 需要再次验证
+	if !checkAdminStepUp(c, admin, "Admin change information failed") {
 		return
 	}
 
 	// 检查是否绑定了 password field
-	err = c.ShouldBindWith(&adminUpdateInfoVals, binding.JSON)
+	err := c.ShouldBindWith(&adminUpdateInfoVals, binding.JSON)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"statusCode": http.StatusBadRequest,
@@ -491,8 +472,15 @@ func AdminUpdateInfoHandler(c *gin.Context) {
 		return
 	}
 
+	// 管理员 ID 不能和其他管理员重复
+	adminID, ok := checkAdminIDAvailable(c, adminUpdateInfoVals.AdminID, admin.ID, "Admin change information failed")
+	if !ok {
+		return
+	}
+	adminUpdateInfoVals.AdminID = adminID
+
 	// 把更新的管理员数据写入数据库
-	err = models.UpdateAdminInfo(adminUpdateInfoVals.AdminID, adminUpdateInfoVals.AdminName, adminUpdateInfoVals.Image)
+	err = models.UpdateAdminInfo(admin.ID, adminUpdateInfoVals.AdminID, adminUpdateInfoVals.AdminName, adminUpdateInfoVals.Image)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"statusCode": http.StatusInternalServerError,
@@ -506,6 +494,7 @@ func AdminUpdateInfoHandler(c *gin.Context) {
 
 		return
 	}
+	auditAdminAfter(c, admin.ID)
 
 	c.JSON(http.StatusOK, gin.H{
 		"statusCode": http.StatusOK,
@@ -589,3 +578,8 @@ func checkAdminPasswordLength(password string) bool {
 
 	return true
 }
+
+// 删除管理员的所有会话, 之前签发的 token 全部失效
+func revokeAdminToken(admin *models.Admin) error {
+	return token.Revoke(token.RoleAdmin, strconv.FormatUint(uint64(admin.ID), 10))
+}
@@ -0,0 +1,419 @@
+package controllers
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	log "github.com/sirupsen/logrus"
+	"github.com/ztplz/blog-server/middlewares"
+	"github.com/ztplz/blog-server/models"
+)
+
+// AdminAccountForm 新增管理员表单
+type AdminAccountForm struct {
+	AdminID   string `form:"admin_id" json:"admin_id" binding:"required"`
+	Password  string `form:"password" json:"password" binding:"required"`
+	AdminName string `form:"admin_name" json:"admin_name" binding:"required"`
+}
+
+// AdminAccountUpdateForm 修改管理员表单, 只修改提交的字段, 修改密码后该管理员需要重新登录
+type AdminAccountUpdateForm struct {
+	AdminID   *string `form:"admin_id" json:"admin_id"`
+	AdminName *string `form:"admin_name" json:"admin_name"`
+	Image     *string `form:"image" json:"image"`
+	Password  *string `form:"password" json:"password"`
+}
+
+// GetAdminsHandler 获取所有管理员
+func GetAdminsHandler(c *gin.Context) {
+	admins, err := models.GetAllAdmin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"statusCode": http.StatusInternalServerError,
+			"message":    http.StatusText(http.StatusInternalServerError),
+		})
+		c.AbortWithStatus(http.StatusInternalServerError)
+		log.WithFields(log.Fields{
+			"errorMsg":   err,
+			"statusCode": http.StatusInternalServerError,
+		}).Info("Get admins failed")
+
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"statusCode": http.StatusOK,
+		"admins":     admins,
+	})
+}
+
+// AddAdminHandler 新增管理员
+func AddAdminHandler(c *gin.Context) {
+	var adminVals AdminAccountForm
+
+	if !checkAdminStepUp(c, middlewares.CurrentAdmin(c), "Add admin failed") {
+		return
+	}
+
+	err := c.ShouldBindWith(&adminVals, binding.JSON)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"statusCode": http.StatusBadRequest,
+			"message":    "Miss adminID, password or admin name",
+		})
+		c.AbortWithStatus(http.StatusBadRequest)
+		log.WithFields(log.Fields{
+			"errorMsg":   err,
+			"statusCode": http.StatusBadRequest,
+		}).Info("Add admin failed")
+
+		return
+	}
+
+	adminID, ok := checkAdminIDAvailable(c, adminVals.AdminID, 0, "Add admin failed")
+	if !ok {
+		return
+	}
+
+	password, ok := checkAdminNewPassword(c, adminVals.Password, "Add admin failed")
+	if !ok {
+		return
+	}
+
+	adminName, ok := checkAdminName(c, adminVals.AdminName, "Add admin failed")
+	if !ok {
+		return
+	}
+
+	id, err := models.AddAdmin(adminID, password, adminName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"statusCode": http.StatusInternalServerError,
+			"message":    http.StatusText(http.StatusInternalServerError),
+		})
+		c.AbortWithStatus(http.StatusInternalServerError)
+		log.WithFields(log.Fields{
+			"errorMsg":   err,
+			"statusCode": http.StatusInternalServerError,
+		}).Info("Add admin failed")
+
+		return
+	}
+	middlewares.AuditTarget(c, "admin", strconv.FormatInt(id, 10))
+	auditAdminAfter(c, uint(id))
+
+	c.JSON(http.StatusOK, gin.H{
+		"statusCode": http.StatusOK,
+		"message":    "Add admin success",
+		"id":         id,
+	})
+
+	log.WithFields(log.Fields{
+		"id":         id,
+		"adminID":    adminID,
+		"statusCode": http.StatusOK,
+	}).Info("Add admin success")
+}
+
+// UpdateAdminHandler 修改某个管理员的信息或者重置密码
+func UpdateAdminHandler(c *gin.Context) {
+	var adminVals AdminAccountUpdateForm
+
+	if !checkAdminStepUp(c, middlewares.CurrentAdmin(c), "Update admin failed") {
+		return
+	}
+
+	admin, ok := getAdminAccount(c, "Update admin failed")
+	if !ok {
+		return
+	}
+	middlewares.AuditBefore(c, *admin)
+
+	err := c.ShouldBindWith(&adminVals, binding.JSON)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"statusCode": http.StatusBadRequest,
+			"message":    "Admin form incorrect",
+		})
+		c.AbortWithStatus(http.StatusBadRequest)
+		log.WithFields(log.Fields{
+			"errorMsg":   err,
+			"statusCode": http.StatusBadRequest,
+		}).Info("Update admin failed")
+
+		return
+	}
+
+	// 合并提交的字段
+	if adminVals.AdminID != nil {
+		admin.AdminID, ok = checkAdminIDAvailable(c, *adminVals.AdminID, admin.ID, "Update admin failed")
+		if !ok {
+			return
+		}
+	}
+	if adminVals.AdminName != nil {
+		admin.AdminName, ok = checkAdminName(c, *adminVals.AdminName, "Update admin failed")
+		if !ok {
+			return
+		}
+	}
+	if adminVals.Image != nil {
+		admin.Image = strings.TrimSpace(*adminVals.Image)
+	}
+
+	var password string
+	if adminVals.Password != nil {
+		password, ok = checkAdminNewPassword(c, *adminVals.Password, "Update admin failed")
+		if !ok {
+			return
+		}
+	}
+
+	err = models.UpdateAdminInfo(admin.ID, admin.AdminID, admin.AdminName, admin.Image)
+	if err == nil && password != "" {
+		err = models.UpdateAdminPassword(admin.ID, password)
+		if err == nil {
+			models.ClearAdminStepUp(admin.ID)
+			err = revokeAdminToken(admin)
+		}
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"statusCode": http.StatusInternalServerError,
+			"message":    http.StatusText(http.StatusInternalServerError),
+		})
+		c.AbortWithStatus(http.StatusInternalServerError)
+		log.WithFields(log.Fields{
+			"errorMsg":   err,
+			"id":         admin.ID,
+			"statusCode": http.StatusInternalServerError,
+		}).Info("Update admin failed")
+
+		return
+	}
+	auditAdminAfter(c, admin.ID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"statusCode": http.StatusOK,
+		"message":    "Update admin success",
+		"admin":      admin,
+	})
+
+	log.WithFields(log.Fields{
+		"id":            admin.ID,
+		"adminID":       admin.AdminID,
+		"resetPassword": password != "",
+		"statusCode":    http.StatusOK,
+	}).Info("Update admin success")
+}
+
+// DeleteAdminHandler 删除某个管理员, 不能删除自己和最后一个管理员
+func DeleteAdminHandler(c *gin.Context) {
+	current := middlewares.CurrentAdmin(c)
+
+	if !checkAdminStepUp(c, current, "Delete admin failed") {
+		return
+	}
+
+	admin, ok := getAdminAccount(c, "Delete admin failed")
+	if !ok {
+		return
+	}
+	middlewares.AuditBefore(c, admin)
+
+	if admin.ID == current.ID {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"statusCode": http.StatusBadRequest,
+			"message":    "Can't delete yourself",
+		})
+		c.AbortWithStatus(http.StatusBadRequest)
+		log.WithFields(log.Fields{
+			"id":         admin.ID,
+			"statusCode": http.StatusBadRequest,
+		}).Info("Delete admin failed")
+
+		return
+	}
+
+	err := models.DeleteAdmin(admin.ID)
+	if err == models.ErrLastAdmin {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"statusCode": http.StatusBadRequest,
+			"message":    err.Error(),
+		})
+		c.AbortWithStatus(http.StatusBadRequest)
+		log.WithFields(log.Fields{
+			"id":         admin.ID,
+			"statusCode": http.StatusBadRequest,
+		}).Info("Delete admin failed")
+
+		return
+	}
+	if err != nil {
+		abortAdminQueryError(c, err, "Delete admin failed")
+
+		return
+	}
+
+	// 删除后之前签发的 token 立即失效, 删除已经完成, 删除会话失败时只记录日志,
+	// 留下的 token 验证时也找不到管理员
+	models.ClearAdminStepUp(admin.ID)
+	err = revokeAdminToken(admin)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+			"id":       admin.ID,
+		}).Info("Revoke deleted admin token failed")
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"statusCode": http.StatusOK,
+		"message":    "Delete admin success",
+	})
+
+	log.WithFields(log.Fields{
+		"id":         admin.ID,
+		"adminID":    admin.AdminID,
+		"statusCode": http.StatusOK,
+	}).Info("Delete admin success")
+}
+
+// 修改后重新查询管理员, 作为审计日志里修改后的内容, 查询失败时不记录
+func auditAdminAfter(c *gin.Context, id uint) {
+	admin, err := models.AdminByID(id)
+	if err == nil {
+		middlewares.AuditAfter(c, admin)
+	}
+}
+
+// 根据路由里的 id 获取管理员, 不存在时返回 404
+func getAdminAccount(c *gin.Context, logMsg string) (*models.Admin, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"statusCode": http.StatusBadRequest,
+			"message":    "Incorrect admin id",
+		})
+		c.AbortWithStatus(http.StatusBadRequest)
+		log.WithFields(log.Fields{
+			"errorMsg":   err,
+			"id":         c.Param("id"),
+			"statusCode": http.StatusBadRequest,
+		}).Info(logMsg)
+
+		return nil, false
+	}
+
+	admin, err := models.AdminByID(uint(id))
+	if err != nil {
+		abortAdminQueryError(c, err, logMsg)
+
+		return nil, false
+	}
+
+	return admin, true
+}
+
+// 管理员查询出错时返回响应, 管理员不存在返回 404, 其他错误返回 500
+func abortAdminQueryError(c *gin.Context, err error, logMsg string) {
+	statusCode := http.StatusInternalServerError
+	message := http.StatusText(http.StatusInternalServerError)
+	if err == sql.ErrNoRows {
+		statusCode = http.StatusNotFound
+		message = "Admin not found"
+	}
+
+	c.JSON(statusCode, gin.H{
+		"statusCode": statusCode,
+		"message":    message,
+	})
+	c.AbortWithStatus(statusCode)
+	log.WithFields(log.Fields{
+		"errorMsg":   err,
+		"id":         c.Param("id"),
+		"statusCode": statusCode,
+	}).Info(logMsg)
+}
+
+// 检查管理员 ID 的格式和长度, 并且没有被其他管理员使用, selfID 为正在修改的管理员
+func checkAdminIDAvailable(c *gin.Context, adminID string, selfID uint, logMsg string) (string, bool) {
+	adminID, err := checkString(adminID)
+	if err != nil || !checkAdminIDLength(adminID) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"statusCode": http.StatusBadRequest,
+			"message":    "Incorrect adminID format or length",
+		})
+		c.AbortWithStatus(http.StatusBadRequest)
+		log.WithFields(log.Fields{
+			"errorMsg":   err,
+			"statusCode": http.StatusBadRequest,
+		}).Info(logMsg)
+
+		return "", false
+	}
+
+	other, err := models.AdminByAdminID(adminID)
+	if err != nil && err != sql.ErrNoRows {
+		abortAdminQueryError(c, err, logMsg)
+
+		return "", false
+	}
+	if err == nil && other.ID != selfID {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"statusCode": http.StatusBadRequest,
+			"message":    "AdminID already exist",
+		})
+		c.AbortWithStatus(http.StatusBadRequest)
+		log.WithFields(log.Fields{
+			"adminID":    adminID,
+			"statusCode": http.StatusBadRequest,
+		}).Info(logMsg)
+
+		return "", false
+	}
+
+	return adminID, true
+}
+
+// 检查新密码的格式和长度
+func checkAdminNewPassword(c *gin.Context, password string, logMsg string) (string, bool) {
+	password, err := checkString(password)
+	if err != nil || !checkAdminPasswordLength(password) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"statusCode": http.StatusBadRequest,
+			"message":    "Incorrect password format or length",
+		})
+		c.AbortWithStatus(http.StatusBadRequest)
+		log.WithFields(log.Fields{
+			"errorMsg":   err,
+			"statusCode": http.StatusBadRequest,
+		}).Info(logMsg)
+
+		return "", false
+	}
+
+	return password, true
+}
+
+// 检查管理员名字不为空
+func checkAdminName(c *gin.Context, adminName string, logMsg string) (string, bool) {
+	adminName = strings.TrimSpace(adminName)
+	if adminName == "" || len(adminName) > 255 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"statusCode": http.StatusBadRequest,
+			"message":    "Incorrect admin name",
+		})
+		c.AbortWithStatus(http.StatusBadRequest)
+		log.WithFields(log.Fields{
+			"statusCode": http.StatusBadRequest,
+		}).Info(logMsg)
+
+		return "", false
+	}
+
+	return adminName, true
+}
@@ -1,96 +1,168 @@
 package controllers
 
 import (
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"net/http"
+	"net/url"
+	"sort"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gin-gonic/gin/binding"
+	"github.com/go-redis/redis"
 	log "github.com/sirupsen/logrus"
 	"github.com/ztplz/blog-server/middlewares"
 	"github.com/ztplz/blog-server/models"
 )
 
+// 博文返回格式
+const (
+	articleFormatHTML     = "html"
+	articleFormatMarkdown = "markdown"
+	articleFormatBoth     = "both"
+)
+
 // ArticleForm 文章表单
 type ArticleForm struct {
-	ArticleTitle       string   `form:"article_title" json:"article_title" binding:"required"`
-	ArticlePreviewText string   `form:"article_previewtext" json:"article_previewtext" binding:"required"`
-	ArticleContent     string   `form:"article_content" json:"article_content" binding:"required"`
-	Category           uint     `form:"category" json:"category" binding:"required"`
-	Tags               []string `form:"tags" json:"tags" binding:"required"`
+	ArticleTitle       string `form:"article_title" json:"article_title" binding:"required"`
+	ArticlePreviewText string `form:"article_previewtext" json:"article_previewtext" binding:"required"`
+	ArticleContent     string `form:"article_content" json:"article_content" binding:"required"`
+	Category           uint   `form:"category" json:"category" binding:"required"`
+	Tags               []uint `form:"tags" json:"tags" binding:"required"`
+	Status             string `form:"status" json:"status"`
+	PublishAt          string `form:"publish_at" json:"publish_at"`
+}
+
+// ArticleUpdateForm 修改文章表单, 没有提交的字段保持不变
+type ArticleUpdateForm struct {
+	ArticleTitle       *string `form:"article_title" json:"article_title"`
+	ArticlePreviewText *string `form:"article_previewtext" json:"article_previewtext"`
+	ArticleContent     *string `form:"article_content" json:"article_content"`
+	Top                *bool   `form:"top" json:"top"`
+	Category           *uint   `form:"category" json:"category"`
+	Tags               *[]uint `form:"tags" json:"tags"`
+	Status             *string `form:"status" json:"status"`
+	PublishAt          *string `form:"publish_at" json:"publish_at"`
 }
 
 // ArticleRes 定义博文响应的结构体
 type ArticleRes struct {
-	ID                 uint            `json:"id"`
-	CreateAt           string          `json:"create_at"`
-	UpdateAt           string          `json:"update_at"`
-	VisitCount         uint            `json:"visit_count"`
-	ReplyCount         uint            `json:"reply_count"`
-	ArticleTitle       string          `json:"article_title"`
-	ArticlePreviewText string          `json:"article_previewtext"`
-	ArticleContent     string          `json:"article_content"`
-	Top                bool            `json:"top"`
-	Category           models.Category `json:"category"`
-	TagList            []models.Tag    `json:"tag_list"`
+	ID                 uint              `json:"id"`
+	CreateAt           string            `json:"create_at"`
+	UpdateAt           string            `json:"update_at"`
+	VisitCount         uint              `json:"visit_count"`
+	ReplyCount         uint              `json:"reply_count"`
+	ArticleTitle       string            `json:"article_title"`
+	ArticlePreviewText string            `json:"article_previewtext"`
+	ArticleContent     string            `json:"article_content,omitempty"`
+	ArticleHTML        string            `json:"article_html,omitempty"`
+	TOC                []*models.TOCItem `json:"toc"`
+	Top                bool              `json:"top"`
+	Category           models.Category   `json:"category"`
+	TagList            []models.Tag      `json:"tag_list"`
+	Slug               string            `json:"slug"`
+	Status             string            `json:"status"`
+	PublishAt          string            `json:"publish_at"`
 }
 
 // GetAllArticlesHandler 获取文章列表
 func GetAllArticlesHandler(c *gin.Context) {
-	// 获取查询参数
-	limitString := c.DefaultQuery("limit", "10")
-	pageString := c.DefaultQuery("page", "1")
+	filter, err := parseArticleFilter(c)
+	if err != nil {
+		return
+	}
 
-	// 转换 limitString, page 为 int64 类型
-	limit, lerr := strconv.ParseInt(limitString, 10, 32)
-	page, perr := strconv.ParseInt(pageString, 10, 32)
-	if lerr != nil || perr != nil {
+	listArticles(c, filter)
+}
+
+// GetAdminArticlesHandler 后台博文列表, 包括草稿、定时和私密博文, 直接从数据库查询,
+// status 不为空时只列出该状态的博文, 只有 own_articles 权限时只列出自己的博文
+func GetAdminArticlesHandler(c *gin.Context) {
+	filter, err := parseArticleFilter(c)
+	if err != nil {
+		return
+	}
+
+	filter.Admin = true
+	filter.Status = c.Query("status")
+	switch filter.Status {
+	case "", models.ArticleStatusDraft, models.ArticleStatusPublished, models.ArticleStatusScheduled, models.ArticleStatusPrivate:
+	default:
 		c.JSON(http.StatusBadRequest, gin.H{
 			"statusCode": http.StatusBadRequest,
-			"message":    "Parameter not incorrect",
+			"message":    "Incorrect article status",
 		})
 		c.AbortWithStatus(http.StatusBadRequest)
 		log.WithFields(log.Fields{
-			"errorMsgLimit": lerr,
-			"errorMsgPage":  perr,
-			"limit":         limitString,
-			"pageString":    pageString,
-			"statusCode":    http.StatusBadRequest,
-		}).Info("Get articles failed")
+			"status":     filter.Status,
+			"statusCode": http.StatusBadRequest,
+		}).Info("Get admin articles failed")
 
 		return
 	}
 
-	// 判断是否大于 0
-	if limit <= 0 || page <= 0 {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"statusCode": http.StatusBadRequest,
-			"message":    "Parameter must above 0",
+	if !middlewares.HasPermission(c, models.PermManageArticles) {
+		filter.AuthorID = middlewares.CurrentStaff(c).UserID
+	}
+
+	limit, page, ok := parseArticlePage(c)
+	if !ok {
+		return
+	}
+
+	format, err := parseArticleFormat(c)
+	if err != nil {
+		return
+	}
+
+	articlesRes, total, err := getArticlesFromDatabase(filter, limit, page)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"statusCode": http.StatusInternalServerError,
+			"message":    http.StatusText(http.StatusInternalServerError),
 		})
-		c.AbortWithStatus(http.StatusBadRequest)
+		c.AbortWithStatus(http.StatusInternalServerError)
 		log.WithFields(log.Fields{
-			"errorMsg":   "Parameter must above 0",
-			"limit":      limit,
-			"pageString": page,
-			"statusCode": http.StatusBadRequest,
-		}).Info("Get articles failed")
+			"errorMsg":   err,
+			"statusCode": http.StatusInternalServerError,
+		}).Info("Get admin articles failed")
+
+		return
+	}
+
+	applyArticlesFormat(*articlesRes, format)
+	c.JSON(http.StatusOK, gin.H{
+		"statusCode": http.StatusOK,
+		"total":      total,
+		"articles":   *articlesRes,
+	})
+}
 
+// 按过滤条件返回博文列表, 先从 redis 查询, 失败时从 mysql 查询
+func listArticles(c *gin.Context, filter *models.ArticleFilter) {
+	limit, page, ok := parseArticlePage(c)
+	if !ok {
+		return
+	}
+
+	// 返回 html、markdown 或者两者都返回
+	format, err := parseArticleFormat(c)
+	if err != nil {
 		return
 	}
 
 	// 首先从 redis 里查询博文
-	articlesRes, err := getArticlesFromRedis(limit, page)
+	articlesRes, total, err := getArticlesFromRedis(filter, limit, page)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"errorMsg": err,
 		}).Info("Get articles from redis failed")
 
 		// redis查询失败，从数据库查询
-		articlesRes, err := getArticlesFromDatabase(limit, page)
+		articlesRes, total, err := getArticlesFromDatabase(filter, limit, page)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"statusCode": http.StatusInternalServerError,
@@ -108,8 +180,10 @@ func GetAllArticlesHandler(c *gin.Context) {
 		}
 
 		// 从数据库查询成功
+		applyArticlesFormat(*articlesRes, format)
 		c.JSON(http.StatusOK, gin.H{
 			"statusCode": http.StatusOK,
+			"total":      total,
 			"articles":   *articlesRes,
 		})
 		c.AbortWithStatus(http.StatusOK)
@@ -121,52 +195,15 @@ func GetAllArticlesHandler(c *gin.Context) {
 		}).Info("Get articles success")
 
 		// 把所有博文同步到 redis里
-		articles, err := models.GetAllArticle()
-		if err != nil {
-			log.WithFields(log.Fields{
-				"errorMsg": err,
-			}).Info("Sync all articles to redis failed")
-
-			return
-		}
-
-		// 清除 redis 里的博文
-		err = models.RedisClient.Del("articles").Err()
-		if err != nil {
-			log.WithFields(log.Fields{
-				"errorMsg": err,
-			}).Info("Delete articles from redis failed")
-
-			return
-		}
-
-		for _, article := range *articles {
-			marticle, err := json.Marshal(article)
-			if err != nil {
-				log.WithFields(log.Fields{
-					"errorMsg": err,
-				}).Info("Sync articles to redis failed")
-
-				return
-			}
-
-			err = models.RedisClient.LPush("articles", marticle).Err()
-			if err != nil {
-				log.WithFields(log.Fields{
-					"errorMsg": err,
-				}).Info("Sync articles to redis failed")
-
-				return
-			}
-		}
-
-		log.Info("Sync articles to redis success")
+		syncArticlesToRedis()
 
 		return
 	}
 
+	applyArticlesFormat(*articlesRes, format)
 	c.JSON(http.StatusOK, gin.H{
 		"statusCode": http.StatusOK,
+		"total":      total,
 		"articles":   *articlesRes,
 	})
 	c.AbortWithStatus(http.StatusOK)
@@ -212,41 +249,75 @@ func GetArticleByID(c *gin.Context) {
 		return
 	}
 
-	// 从数据库根据 id 查询博文
+	format, err := parseArticleFormat(c)
+	if err != nil {
+		return
+	}
+
+	// 从数据库根据 id 查询博文, 回收站里的博文查询不到
 	article, err := models.GetArticleByID(uid)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"statusCode": http.StatusInternalServerError,
-			"message":    "请求处理失败",
-		})
-		c.AbortWithStatus(http.StatusInternalServerError)
-		log.WithFields(log.Fields{
-			"statusCode": http.StatusInternalServerError,
-			"message":    err,
-		}).Info("Get article failed")
+		abortArticleQueryError(c, err, "Get article failed")
 
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"statusCode": http.StatusOK,
-		"message":    "success",
-		"article":    *article,
-	})
+	respondArticle(c, article, format)
 }
 
-// AddArticleHandler 增加文章
-func AddArticleHandler(c *gin.Context) {
-	var articleVals ArticleForm
+// GetArticleBySlugHandler 根据 slug 获取博文, 旧的 slug 301 重定向到现在的 slug
+func GetArticleBySlugHandler(c *gin.Context) {
+	slug := c.Param("slug")
 
-	// 验证是否有权限增加文章
-	_, err := middlewares.AdminAuthMiddleware(c)
+	format, err := parseArticleFormat(c)
 	if err != nil {
 		return
 	}
 
+	article, err := models.GetArticleBySlug(slug)
+	if err == nil {
+		respondArticle(c, article, format)
+
+		return
+	}
+
+	if err != sql.ErrNoRows {
+		abortArticleQueryError(c, err, "Get article by slug failed")
+
+		return
+	}
+
+	// 当前没有博文使用这个 slug, 查询是否是博文以前的 slug
+	id, err := models.GetArticleIDBySlugHistory(slug)
+	if err == nil {
+		article, err = models.GetArticleByID(uint64(id))
+	}
+	if err != nil {
+		abortArticleQueryError(c, err, "Get article by slug failed")
+
+		return
+	}
+
+	location := "/api/v1/articles/slug/" + url.PathEscape(article.Slug)
+	if c.Request.URL.RawQuery != "" {
+		location += "?" + c.Request.URL.RawQuery
+	}
+
+	c.Redirect(http.StatusMovedPermanently, location)
+
+	log.WithFields(log.Fields{
+		"slug":       slug,
+		"newSlug":    article.Slug,
+		"statusCode": http.StatusMovedPermanently,
+	}).Info("Redirect old article slug")
+}
+
+// AddArticleHandler 增加文章
+func AddArticleHandler(c *gin.Context) {
+	var articleVals ArticleForm
+
 	// 从表单中提取文章标题
-	err = c.ShouldBindWith(&articleVals, binding.JSON)
+	err := c.ShouldBindWith(&articleVals, binding.JSON)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"statusCode": http.StatusBadRequest,
@@ -291,23 +362,59 @@ func AddArticleHandler(c *gin.Context) {
 		return
 	}
 
-	// 判断标签是否规定数量
-	if len(articleVals.Tags) > 3 {
+	// 判断标签是否规定数量并且都存在
+	err = checkArticleTags(c, articleVals.Tags, "Add article failed")
+	if err != nil {
+		return
+	}
+
+	// 检查发布状态, 不填默认直接发布
+	status, publishAt, err := checkArticleStatus(articleVals.Status, articleVals.PublishAt)
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"statusCode": http.StatusBadRequest,
-			"message":    "Too many tags",
+			"message":    err.Error(),
 		})
 		c.AbortWithStatus(http.StatusBadRequest)
 		log.WithFields(log.Fields{
-			"errorMsg":   "Too many tags",
+			"errorMsg":   err,
 			"statusCode": http.StatusBadRequest,
 		}).Info("Add article failed")
 
 		return
 	}
 
-	// 把传到后台的标签数组转化成字符串
-	tags := strings.Join(articleVals.Tags, "_")
+	// 根据标题生成 slug
+	slug, err := models.UniqueSlug(articleVals.ArticleTitle, 0)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"statusCode": http.StatusInternalServerError,
+			"message":    http.StatusText(http.StatusInternalServerError),
+		})
+		c.AbortWithStatus(http.StatusInternalServerError)
+		log.WithFields(log.Fields{
+			"errorMsg":   err,
+			"statusCode": http.StatusInternalServerError,
+		}).Info("Add article failed")
+
+		return
+	}
+
+	// 渲染 markdown
+	html, toc, err := models.RenderMarkdown(articleVals.ArticleContent)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"statusCode": http.StatusInternalServerError,
+			"message":    http.StatusText(http.StatusInternalServerError),
+		})
+		c.AbortWithStatus(http.StatusInternalServerError)
+		log.WithFields(log.Fields{
+			"errorMsg":   err,
+			"statusCode": http.StatusInternalServerError,
+		}).Info("Add article failed")
+
+		return
+	}
 
 	// 实例化一个 Article 结构体
 	article := &models.Article{
@@ -316,11 +423,21 @@ func AddArticleHandler(c *gin.Context) {
 		VisitCount:         0,
 		ReplyCount:         0,
 		ArticleTitle:       articleVals.ArticleTitle,
+		Slug:               slug,
 		ArticlePreviewText: articleVals.ArticlePreviewText,
 		ArticleContent:     articleVals.ArticleContent,
+		ArticleHTML:        html,
+		ArticleTOC:         toc,
 		Top:                false,
 		Category:           articleVals.Category,
-		TagList:            tags,
+		Tags:               articleVals.Tags,
+		Status:             status,
+		PublishAt:          publishAt,
+	}
+
+	// 用户发表的博文记录作者
+	if user := middlewares.CurrentStaff(c); user != nil {
+		article.AuthorID = user.UserID
 	}
 
 	// 存储博文数据进数据库
@@ -339,9 +456,14 @@ func AddArticleHandler(c *gin.Context) {
 		return
 	}
 
+	article.ID = uint(lastID)
+	middlewares.AuditTarget(c, "article", strconv.FormatInt(lastID, 10))
+	middlewares.AuditAfter(c, article)
+
 	c.JSON(200, gin.H{
 		"success": "true",
 		"id":      lastID,
+		"slug":    article.Slug,
 		"message": "Add article success",
 	})
 
@@ -352,131 +474,841 @@ func AddArticleHandler(c *gin.Context) {
 		"create_at":  time.Now().Format("2006-01-02 15:04:05"),
 	}).Info("Add article success")
 
-	// 把新增博文同步更新到 redis 里, 每次 mysql 更新成功都把所有 articles 同步到 redis
-	articles := new([]models.Article)
-	for count := 0; count < 3; count++ {
-		articles, err = models.GetAllArticle()
-		if err == nil && len(*articles) != 0 {
+	// 把新增博文同步更新到 redis 和搜索索引里, 每次 mysql 更新成功都把所有 articles 同步到 redis
+	refreshArticles()
+}
 
-			break
-		}
+// UpdateArticleHandler 修改博文, 只修改表单里提交的字段
+func UpdateArticleHandler(c *gin.Context) {
+	var articleVals ArticleUpdateForm
 
-		log.WithFields(log.Fields{
-			"errorMsg": err,
-		}).Info("Get all article failed")
+	id, err := parseArticleID(c)
+	if err != nil {
+		return
 	}
 
-	for _, value := range *articles {
-		ma, _ := json.Marshal(value)
-
-		// 向链表头push，因为都是倒序查找
-		for count := 0; count < 10; count++ {
-			err = models.RedisClient.LPush("articles", ma).Err()
-			if err == nil {
-				log.WithFields(log.Fields{}).Info("Sync article to redis success")
+	err = c.ShouldBindWith(&articleVals, binding.JSON)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"statusCode": http.StatusBadRequest,
+			"message":    "Article form incorrect",
+		})
+		c.AbortWithStatus(http.StatusBadRequest)
+		log.WithFields(log.Fields{
+			"errorMsg":   err,
+			"statusCode": http.StatusBadRequest,
+		}).Info("Update article failed")
 
-				break
-			}
-		}
+		return
 	}
 
-}
-
-// 根据 category id返回相应的分类
-func categoryForRes(id uint, categories *[]models.Category) models.Category {
-	var category models.Category
+	// 取出原来的博文, 草稿和定时博文也可以修改
+	article, err := models.GetAnyArticleByID(id)
+	if err != nil {
+		abortArticleQueryError(c, err, "Update article failed")
 
-	for _, value := range *categories {
-		if value.ID == id {
-			category.ID = value.ID
-			category.Category = value.Category
+		return
+	}
+	if !checkArticleOwner(c, article, "Update article failed") {
+		return
+	}
+	middlewares.AuditBefore(c, *article)
 
-			break
+	// 合并提交的字段
+	oldSlug := article.Slug
+	if articleVals.ArticleTitle != nil {
+		article.ArticleTitle = *articleVals.ArticleTitle
+	}
+	if articleVals.ArticlePreviewText != nil {
+		article.ArticlePreviewText = *articleVals.ArticlePreviewText
+	}
+	if articleVals.ArticleContent != nil {
+		article.ArticleContent = *articleVals.ArticleContent
+	}
+	if articleVals.Top != nil {
+		article.Top = *articleVals.Top
+	}
+	if articleVals.Category != nil {
+		article.Category = *articleVals.Category
+	}
+	if articleVals.Tags != nil {
+		err = checkArticleTags(c, *articleVals.Tags, "Update article failed")
+		if err != nil {
+			return
 		}
+
+		article.Tags = *articleVals.Tags
 	}
 
-	return category
-}
+	// 修改了发布状态或发布时间时重新检查
+	if articleVals.Status != nil || articleVals.PublishAt != nil {
+		status := article.Status
+		if articleVals.Status != nil {
+			status = *articleVals.Status
+		}
 
-// 根据数据的 tag_list 返回相应的标签组
-func tagForRes(tagStr string, tags *[]models.Tag) ([]models.Tag, error) {
-	var tagSlice []models.Tag
-	tagStrSlice := strings.Split(tagStr, "_")
-	for _, tagID := range tagStrSlice {
-		// t, err := strconv.ParseUint(tagID, 10, 64)
-		// if err != nil {
-		// 	return nil, err
-		// }
+		var publishAt string
+		if articleVals.PublishAt != nil {
+			publishAt = *articleVals.PublishAt
+		} else if status == models.ArticleStatusScheduled {
+			// 只修改状态为定时发布时, 用原来的发布时间检查
+			publishAt = formatDatetime(article.PublishAt)
+		}
 
-		for _, tag := range *tags {
-			ti, err := strconv.ParseUint(tagID, 10, 0)
-			if err != nil {
-				return nil, err
-			}
+		article.Status, article.PublishAt, err = checkArticleStatus(status, publishAt)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"statusCode": http.StatusBadRequest,
+				"message":    err.Error(),
+			})
+			c.AbortWithStatus(http.StatusBadRequest)
+			log.WithFields(log.Fields{
+				"errorMsg":   err,
+				"statusCode": http.StatusBadRequest,
+			}).Info("Update article failed")
 
-			if tag.ID == uint(ti) {
-				tagSlice = append(tagSlice, tag)
-			}
+			return
 		}
-
+	} else {
+		// 数据库读出的时间是 RFC3339 格式, 写回时转换成 mysql 的格式
+		article.PublishAt = formatDatetime(article.PublishAt)
 	}
 
-	return tagSlice, nil
-}
-
-// 从 redis 中获取博文数据
-func getArticlesFromRedis(limit int64, page int64) (*[]ArticleRes, error) {
-	articlesRes := new([]ArticleRes)
+	// 判断标题和预览内容是否规定长度
+	if article.ArticleTitle == "" || len(article.ArticleTitle) > models.ArticleTitleLengthMax || len(article.ArticlePreviewText) > models.ArticlePreviewTextLengthMax {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"statusCode": http.StatusBadRequest,
+			"message":    "Article title or preview text length incorrect",
+		})
+		c.AbortWithStatus(http.StatusBadRequest)
+		log.WithFields(log.Fields{
+			"errorMsg":   "Article title or preview text length incorrect",
+			"statusCode": http.StatusBadRequest,
+		}).Info("Update article failed")
 
-	// 判断查询是否越界
-	al, err := models.RedisClient.LLen("articles").Result()
-	if err != nil {
-		return nil, err
+		return
 	}
 
-	// 定义查询区间
-	var qstart int64
-	var qend int64
+	err = saveArticle(article, oldSlug, articleVals.ArticleTitle != nil, articleVals.ArticleContent != nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"statusCode": http.StatusInternalServerError,
+			"message":    http.StatusText(http.StatusInternalServerError),
+		})
+		c.AbortWithStatus(http.StatusInternalServerError)
+		log.WithFields(log.Fields{
+			"errorMsg":   err,
+			"id":         id,
+			"statusCode": http.StatusInternalServerError,
+		}).Info("Update article failed")
 
-	if limit*page > al {
-		qstart = al - al%limit - 1
-		qend = al - 1
+		return
 	}
+	middlewares.AuditAfter(c, article)
 
-	qstart = limit * (page - 1)
-	qend = limit*page - 1
+	c.JSON(http.StatusOK, gin.H{
+		"statusCode": http.StatusOK,
+		"message":    "Update article success",
+		"article":    *article,
+	})
+
+	log.WithFields(log.Fields{
+		"id":         id,
+		"update_at":  article.UpdateAt,
+		"statusCode": http.StatusOK,
+	}).Info("Update article success")
+
+	refreshArticles()
+}
+
+// DeleteArticleHandler 删除博文, 博文只是移入回收站, 可以恢复
+func DeleteArticleHandler(c *gin.Context) {
+	id, err := parseArticleID(c)
+	if err != nil {
+		return
+	}
+
+	if !checkArticleOwnerByID(c, id, "Delete article failed") {
+		return
+	}
+	if article, err := models.GetAnyArticleByID(id); err == nil {
+		middlewares.AuditBefore(c, article)
+	}
+
+	err = models.TrashArticle(id)
+	if err != nil {
+		abortArticleQueryError(c, err, "Delete article failed")
+
+		return
+	}
+	if article, err := models.GetTrashArticleByID(id); err == nil {
+		middlewares.AuditAfter(c, article)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"statusCode": http.StatusOK,
+		"message":    "Article moved to trash",
+	})
+
+	log.WithFields(log.Fields{
+		"id":         id,
+		"statusCode": http.StatusOK,
+	}).Info("Delete article success")
+
+	refreshArticles()
+}
+
+// GetTrashArticlesHandler 获取回收站里的博文
+func GetTrashArticlesHandler(c *gin.Context) {
+	articles, err := models.GetTrashArticle()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"statusCode": http.StatusInternalServerError,
+			"message":    http.StatusText(http.StatusInternalServerError),
+		})
+		c.AbortWithStatus(http.StatusInternalServerError)
+		log.WithFields(log.Fields{
+			"errorMsg":   err,
+			"statusCode": http.StatusInternalServerError,
+		}).Info("Get trash articles failed")
+
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"statusCode": http.StatusOK,
+		"articles":   *articles,
+	})
+}
+
+// RestoreArticleHandler 从回收站恢复博文
+func RestoreArticleHandler(c *gin.Context) {
+	id, err := parseArticleID(c)
+	if err != nil {
+		return
+	}
+
+	if article, err := models.GetTrashArticleByID(id); err == nil {
+		middlewares.AuditBefore(c, article)
+	}
+
+	err = models.RestoreArticle(id)
+	if err != nil {
+		abortArticleQueryError(c, err, "Restore article failed")
+
+		return
+	}
+	if article, err := models.GetAnyArticleByID(id); err == nil {
+		middlewares.AuditAfter(c, article)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"statusCode": http.StatusOK,
+		"message":    "Restore article success",
+	})
+
+	log.WithFields(log.Fields{
+		"id":         id,
+		"statusCode": http.StatusOK,
+	}).Info("Restore article success")
+
+	refreshArticles()
+}
+
+// PurgeArticleHandler 从回收站彻底删除博文
+func PurgeArticleHandler(c *gin.Context) {
+	id, err := parseArticleID(c)
+	if err != nil {
+		return
+	}
+
+	if article, err := models.GetTrashArticleByID(id); err == nil {
+		middlewares.AuditBefore(c, article)
+	}
+
+	err = models.PurgeArticle(id)
+	if err != nil {
+		abortArticleQueryError(c, err, "Purge article failed")
+
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"statusCode": http.StatusOK,
+		"message":    "Purge article success",
+	})
+
+	log.WithFields(log.Fields{
+		"id":         id,
+		"statusCode": http.StatusOK,
+	}).Info("Purge article success")
+}
+
+// PurgeAllArticlesHandler 清空回收站
+func PurgeAllArticlesHandler(c *gin.Context) {
+	// 只记录删除的博文 id 和标题, 全文可能很大
+	if articles, err := models.GetTrashArticle(); err == nil {
+		titles := make(map[uint]string, len(*articles))
+		for _, article := range *articles {
+			titles[article.ID] = article.ArticleTitle
+		}
+		middlewares.AuditBefore(c, titles)
+	}
+
+	count, err := models.PurgeAllArticle()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"statusCode": http.StatusInternalServerError,
+			"message":    http.StatusText(http.StatusInternalServerError),
+		})
+		c.AbortWithStatus(http.StatusInternalServerError)
+		log.WithFields(log.Fields{
+			"errorMsg":   err,
+			"statusCode": http.StatusInternalServerError,
+		}).Info("Purge all articles failed")
+
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"statusCode": http.StatusOK,
+		"message":    "Purge all articles success",
+		"count":      count,
+	})
+
+	log.WithFields(log.Fields{
+		"count":      count,
+		"statusCode": http.StatusOK,
+	}).Info("Purge all articles success")
+}
+
+// 保存修改后的博文, 修改了标题时重新生成 slug, 修改了内容时重新渲染 markdown
+func saveArticle(article *models.Article, oldSlug string, titleChanged bool, contentChanged bool) error {
+	var err error
+
+	if titleChanged {
+		article.Slug, err = models.UniqueSlug(article.ArticleTitle, article.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	if contentChanged {
+		article.ArticleHTML, article.ArticleTOC, err = models.RenderMarkdown(article.ArticleContent)
+		if err != nil {
+			return err
+		}
+	}
+
+	article.UpdateAt = time.Now().Format("2006-01-02 15:04:05")
+
+	// 修改前的版本会保存到历史版本里, 旧的 slug 保存下来, 旧链接重定向到新的 slug
+	return models.UpdateArticle(article, oldSlug)
+}
+
+// PublishScheduledArticles 定时任务, 发布所有到时间的定时博文并刷新 redis 缓存
+func PublishScheduledArticles() {
+	count, err := models.PublishScheduledArticle()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+		}).Info("Publish scheduled articles failed")
+
+		return
+	}
+
+	if count == 0 {
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"count": count,
+	}).Info("Publish scheduled articles success")
+
+	refreshArticles()
+}
+
+// 检查博文发布状态和发布时间, 返回要存进数据库的状态和时间
+// 状态为空时默认发布, 发布时间为空时默认当前时间, 定时发布必须填写将来的发布时间
+func checkArticleStatus(status string, publishAt string) (string, string, error) {
+	now := time.Now()
+
+	if status == "" {
+		status = models.ArticleStatusPublished
+	}
+
+	switch status {
+	case models.ArticleStatusDraft, models.ArticleStatusPublished, models.ArticleStatusScheduled, models.ArticleStatusPrivate:
+	default:
+		return "", "", errors.New("Incorrect article status")
+	}
+
+	if publishAt == "" {
+		if status == models.ArticleStatusScheduled {
+			return "", "", errors.New("Scheduled article miss publish_at")
+		}
+
+		return status, now.Format("2006-01-02 15:04:05"), nil
+	}
+
+	t, err := time.ParseInLocation("2006-01-02 15:04:05", publishAt, time.Local)
+	if err != nil {
+		return "", "", errors.New("Incorrect publish_at format")
+	}
+
+	if t.After(now) {
+		// 发布时间在将来的博文都作为定时博文
+		if status == models.ArticleStatusPublished {
+			status = models.ArticleStatusScheduled
+		}
+	} else if status == models.ArticleStatusScheduled {
+		return "", "", errors.New("publish_at must be in the future")
+	}
+
+	return status, t.Format("2006-01-02 15:04:05"), nil
+}
+
+// 把数据库读出的 RFC3339 时间转换成 mysql datetime 格式, 无法解析时原样返回
+func formatDatetime(datetime string) string {
+	t, err := time.Parse(time.RFC3339, datetime)
+	if err != nil {
+		return datetime
+	}
+
+	return t.In(time.Local).Format("2006-01-02 15:04:05")
+}
+
+// 从查询参数中解析博文返回格式 html|markdown|both, 默认 both, 不正确时直接返回 400
+func parseArticleFormat(c *gin.Context) (string, error) {
+	format := c.DefaultQuery("format", articleFormatBoth)
+
+	switch format {
+	case articleFormatHTML, articleFormatMarkdown, articleFormatBoth:
+		return format, nil
+	}
+
+	err := errors.New("Incorrect format")
+	c.JSON(http.StatusBadRequest, gin.H{
+		"statusCode": http.StatusBadRequest,
+		"message":    "format must be html, markdown or both",
+	})
+	c.AbortWithStatus(http.StatusBadRequest)
+	log.WithFields(log.Fields{
+		"errorMsg":   err,
+		"format":     format,
+		"statusCode": http.StatusBadRequest,
+	}).Info("Parse article format failed")
+
+	return "", err
+}
+
+// 根据返回格式去掉不需要的博文内容
+func applyArticlesFormat(articlesRes []ArticleRes, format string) {
+	for i := range articlesRes {
+		switch format {
+		case articleFormatHTML:
+			articlesRes[i].ArticleContent = ""
+		case articleFormatMarkdown:
+			articlesRes[i].ArticleHTML = ""
+		}
+	}
+}
+
+// 返回单篇博文, 分类和标签从数据库读取
+func respondArticle(c *gin.Context, article *models.Article, format string) {
+	categories, err := models.GetAllCategory()
+	if err != nil {
+		abortArticleQueryError(c, err, "Get article categories failed")
+
+		return
+	}
+
+	tags, err := models.GetAllTag()
+	if err != nil {
+		abortArticleQueryError(c, err, "Get article tags failed")
+
+		return
+	}
+
+	articlesRes := []ArticleRes{newArticleRes(article, categoryForRes(article.Category, &categories), tagForRes(article.Tags, tags))}
+	applyArticlesFormat(articlesRes, format)
+
+	c.JSON(http.StatusOK, gin.H{
+		"statusCode": http.StatusOK,
+		"message":    "success",
+		"article":    articlesRes[0],
+	})
+}
+
+// 检查博文的标签, 最多 3 个并且都要存在, 不符合时直接返回 400
+func checkArticleTags(c *gin.Context, tags []uint, logMsg string) error {
+	statusCode := http.StatusBadRequest
+	err := errors.New("Too many tags")
+	if len(tags) <= 3 {
+		err = models.CheckTagsExist(tags)
+		if err != nil && err != models.ErrTagNotFound {
+			statusCode = http.StatusInternalServerError
+		}
+	}
+
+	if err == nil {
+		return nil
+	}
+
+	message := err.Error()
+	if statusCode == http.StatusInternalServerError {
+		message = http.StatusText(http.StatusInternalServerError)
+	}
+
+	c.JSON(statusCode, gin.H{
+		"statusCode": statusCode,
+		"message":    message,
+	})
+	c.AbortWithStatus(statusCode)
+	log.WithFields(log.Fields{
+		"errorMsg":   err,
+		"tags":       tags,
+		"statusCode": statusCode,
+	}).Info(logMsg)
+
+	return err
+}
+
+// 解析分页参数 limit(每次返回数) page(页数), 参数不正确时直接返回 400
+func parseArticlePage(c *gin.Context) (int64, int64, bool) {
+	// 获取查询参数
+	limitString := c.DefaultQuery("limit", "10")
+	pageString := c.DefaultQuery("page", "1")
+
+	// 转换 limitString, page 为 int64 类型
+	limit, lerr := strconv.ParseInt(limitString, 10, 32)
+	page, perr := strconv.ParseInt(pageString, 10, 32)
+	if lerr != nil || perr != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"statusCode": http.StatusBadRequest,
+			"message":    "Parameter not incorrect",
+		})
+		c.AbortWithStatus(http.StatusBadRequest)
+		log.WithFields(log.Fields{
+			"errorMsgLimit": lerr,
+			"errorMsgPage":  perr,
+			"limit":         limitString,
+			"pageString":    pageString,
+			"statusCode":    http.StatusBadRequest,
+		}).Info("Get articles failed")
+
+		return 0, 0, false
+	}
+
+	// 判断是否大于 0
+	if limit <= 0 || page <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"statusCode": http.StatusBadRequest,
+			"message":    "Parameter must above 0",
+		})
+		c.AbortWithStatus(http.StatusBadRequest)
+		log.WithFields(log.Fields{
+			"errorMsg":   "Parameter must above 0",
+			"limit":      limit,
+			"pageString": page,
+			"statusCode": http.StatusBadRequest,
+		}).Info("Get articles failed")
+
+		return 0, 0, false
+	}
+
+	return limit, page, true
+}
 
-	log.Info(al)
-	log.Info(qstart)
-	log.Info(qend)
+// 解析博文列表的过滤参数, category(分类id) tag(标签id) from/to(日期) top(true 只返回置顶博文) sort(created|updated|visits)
+// 参数不正确时直接返回 400
+func parseArticleFilter(c *gin.Context) (*models.ArticleFilter, error) {
+	filter := &models.ArticleFilter{
+		From: c.Query("from"),
+		To:   c.Query("to"),
+		Sort: c.DefaultQuery("sort", models.ArticleSortCreated),
+	}
+
+	category, err := strconv.ParseUint(c.DefaultQuery("category", "0"), 10, 0)
+	if err == nil {
+		filter.Category = uint(category)
+
+		var tag uint64
+		tag, err = strconv.ParseUint(c.DefaultQuery("tag", "0"), 10, 0)
+		filter.Tag = uint(tag)
+	}
+	if err == nil {
+		filter.Top, err = strconv.ParseBool(c.DefaultQuery("top", "false"))
+	}
+	if err == nil && filter.From != "" {
+		_, err = time.Parse("2006-01-02", filter.From)
+	}
+	if err == nil && filter.To != "" {
+		_, err = time.Parse("2006-01-02", filter.To)
+	}
+	if err == nil && filter.Sort != models.ArticleSortCreated && filter.Sort != models.ArticleSortUpdated && filter.Sort != models.ArticleSortVisits {
+		err = errors.New("Sort must be created, updated or visits")
+	}
 
-	// 从redis里获取需要的博文
-	marticles, err := models.RedisClient.LRange("articles", qstart, qend).Result()
-	// marticles, err := models.RedisClient.LRange("articles", page, 1).Result()
 	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"statusCode": http.StatusBadRequest,
+			"message":    "Parameter not incorrect",
+		})
+		c.AbortWithStatus(http.StatusBadRequest)
+		log.WithFields(log.Fields{
+			"errorMsg":   err,
+			"query":      c.Request.URL.RawQuery,
+			"statusCode": http.StatusBadRequest,
+		}).Info("Parse article filter failed")
+
 		return nil, err
 	}
 
+	return filter, nil
+}
+
+// 从路由参数中解析博文 id, 解析失败时直接返回 400
+func parseArticleID(c *gin.Context) (uint64, error) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"statusCode": http.StatusBadRequest,
+			"message":    "Incorrect article id",
+		})
+		c.AbortWithStatus(http.StatusBadRequest)
+		log.WithFields(log.Fields{
+			"errorMsg":   err,
+			"id":         c.Param("id"),
+			"statusCode": http.StatusBadRequest,
+		}).Info("Parse article id failed")
+
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// 只有 own_articles 权限的用户只能操作自己写的博文, 不是作者时返回 403
+func checkArticleOwner(c *gin.Context, article *models.Article, logMsg string) bool {
+	if middlewares.HasPermission(c, models.PermManageArticles) {
+		return true
+	}
+
+	user := middlewares.CurrentStaff(c)
+	if user != nil && article.AuthorID == user.UserID {
+		return true
+	}
+
+	c.JSON(http.StatusForbidden, gin.H{
+		"statusCode": http.StatusForbidden,
+		"message":    "You can only manage your own articles",
+	})
+	c.AbortWithStatus(http.StatusForbidden)
+	log.WithFields(log.Fields{
+		"id":         article.ID,
+		"authorID":   article.AuthorID,
+		"statusCode": http.StatusForbidden,
+	}).Info(logMsg)
+
+	return false
+}
+
+// 按 id 取出博文后检查作者, 有 manage_articles 权限时不查询
+func checkArticleOwnerByID(c *gin.Context, id uint64, logMsg string) bool {
+	if middlewares.HasPermission(c, models.PermManageArticles) {
+		return true
+	}
+
+	article, err := models.GetAnyArticleByID(id)
+	if err != nil {
+		abortArticleQueryError(c, err, logMsg)
+
+		return false
+	}
+
+	return checkArticleOwner(c, article, logMsg)
+}
+
+// 博文查询出错时返回响应, 博文不存在返回 404, 其他错误返回 500
+func abortArticleQueryError(c *gin.Context, err error, logMsg string) {
+	statusCode := http.StatusInternalServerError
+	message := http.StatusText(http.StatusInternalServerError)
+	if err == sql.ErrNoRows {
+		statusCode = http.StatusNotFound
+		message = "Article not found"
+	}
+
+	c.JSON(statusCode, gin.H{
+		"statusCode": statusCode,
+		"message":    message,
+	})
+	c.AbortWithStatus(statusCode)
+	log.WithFields(log.Fields{
+		"errorMsg":   err,
+		"id":         c.Param("id"),
+		"statusCode": statusCode,
+	}).Info(logMsg)
+}
+
+// 博文改变后刷新 redis 缓存、搜索索引和 sitemap
+func refreshArticles() {
+	syncArticlesToRedis()
+	rebuildSearchIndex()
+	invalidateSitemap()
+}
+
+// 重建搜索索引, 失败时只记录日志, 下次博文改变时会再次重建
+func rebuildSearchIndex() {
+	err := models.RebuildSearchIndex()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+		}).Info("Rebuild search index failed")
+	}
+}
+
+// 把 mysql 里的全部博文同步到 redis 的 articles 链表, 最新的博文在链表头
+func syncArticlesToRedis() {
+	articles, err := models.GetAllArticle()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+		}).Info("Sync all articles to redis failed")
+
+		return
+	}
+
+	// 清空和重建放在同一个事务里, 避免读到一半的链表
+	_, err = models.RedisClient.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.Del("articles")
+
+		// 向链表头push，因为都是倒序查找
+		for _, article := range *articles {
+			marticle, err := json.Marshal(article)
+			if err != nil {
+				return err
+			}
+
+			pipe.LPush("articles", marticle)
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+		}).Info("Sync articles to redis failed")
+
+		return
+	}
+
+	log.Info("Sync articles to redis success")
+}
+
+// 根据博文和对应的分类、标签生成响应内容
+func newArticleRes(article *models.Article, category models.Category, tags []models.Tag) ArticleRes {
+	return ArticleRes{
+		ID:                 article.ID,
+		CreateAt:           article.CreateAt,
+		UpdateAt:           article.UpdateAt,
+		VisitCount:         article.VisitCount,
+		ReplyCount:         article.ReplyCount,
+		ArticleTitle:       article.ArticleTitle,
+		ArticlePreviewText: article.ArticlePreviewText,
+		ArticleContent:     article.ArticleContent,
+		ArticleHTML:        article.ArticleHTML,
+		TOC:                models.ParseTOC(article.ArticleTOC),
+		Top:                article.Top,
+		Category:           category,
+		TagList:            tags,
+		Slug:               article.Slug,
+		Status:             article.Status,
+		PublishAt:          article.PublishAt,
+	}
+}
+
+// 根据 category id返回相应的分类
+func categoryForRes(id uint, categories *[]models.Category) models.Category {
+	var category models.Category
+
+	for _, value := range *categories {
+		if value.ID == id {
+			category.ID = value.ID
+			category.Category = value.Category
+
+			break
+		}
+	}
+
+	return category
+}
+
+// 根据博文的标签 id 返回相应的标签组
+func tagForRes(tagIDs []uint, tags *[]models.Tag) []models.Tag {
+	tagSlice := []models.Tag{}
+
+	for _, tagID := range tagIDs {
+		for _, tag := range *tags {
+			if tag.ID == tagID {
+				tagSlice = append(tagSlice, tag)
+
+				break
+			}
+		}
+	}
+
+	return tagSlice
+}
+
+// 从 redis 中获取满足过滤条件的博文数据, 同时返回满足条件的博文总数
+func getArticlesFromRedis(filter *models.ArticleFilter, limit int64, page int64) (*[]ArticleRes, int64, error) {
+	articlesRes := &[]ArticleRes{}
+
+	// 取出 redis 里的全部博文, 过滤和排序后再分页
+	marticles, err := models.RedisClient.LRange("articles", 0, -1).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+
 	if len(marticles) == 0 {
-		return nil, errors.New("Articles in redis is empty")
+		return nil, 0, errors.New("Articles in redis is empty")
 	}
 
-	// articles 反序列化
-	articles := new([]models.Article)
-	article := new(models.Article)
+	// articles 反序列化并过滤
+	articles := []models.Article{}
 	for _, marticle := range marticles {
-		err := json.Unmarshal([]byte(marticle), article)
+		var article models.Article
+		err := json.Unmarshal([]byte(marticle), &article)
 		if err != nil {
-			return nil, err
+			return nil, 0, err
+		}
+
+		if filter.Match(&article) {
+			articles = append(articles, article)
 		}
+	}
+
+	sort.SliceStable(articles, func(i, j int) bool {
+		return filter.Less(&articles[i], &articles[j])
+	})
 
-		*articles = append(*articles, *article)
+	// 定义查询区间
+	total := int64(len(articles))
+	qstart := limit * (page - 1)
+	qend := limit * page
+	if qstart > total {
+		qstart = total
+	}
+	if qend > total {
+		qend = total
 	}
+	articles = articles[qstart:qend]
 
 	// 从 redis 里取出所有分类名
 	mcategories, err := models.RedisClient.HGetAll("categories").Result()
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
 	// category 反序列化
@@ -487,7 +1319,7 @@ func getArticlesFromRedis(limit int64, page int64) (*[]ArticleRes, error) {
 	for _, mcategory := range mcategories {
 		err := json.Unmarshal([]byte(mcategory), &category)
 		if err != nil {
-			return nil, err
+			return nil, 0, err
 		}
 
 		categories = append(categories, category)
@@ -496,7 +1328,7 @@ func getArticlesFromRedis(limit int64, page int64) (*[]ArticleRes, error) {
 	// 从 redis 里取出所有标签
 	mtags, err := models.RedisClient.HGetAll("tags").Result()
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
 	// tags 反序列化
@@ -505,69 +1337,54 @@ func getArticlesFromRedis(limit int64, page int64) (*[]ArticleRes, error) {
 	for _, mtag := range mtags {
 		err = json.Unmarshal([]byte(mtag), tag)
 		if err != nil {
-			return nil, err
+			return nil, 0, err
 		}
 
 		*tags = append(*tags, *tag)
 	}
 
 	// 遍历 articles
-	for _, article := range *articles {
+	for _, article := range articles {
 
 		categoryRes := categoryForRes(article.Category, &categories)
 		if len(categories) == 0 {
-			return nil, errors.New("Don't found category from redis")
+			return nil, 0, errors.New("Don't found category from redis")
 		}
 
 		// 获取每个博文的标签
-		tagsForRes, err := tagForRes(article.TagList, tags)
-		if err != nil {
-			return nil, err
-		}
+		tagsForRes := tagForRes(article.Tags, tags)
 
 		if len(tagsForRes) == 0 {
-			return nil, errors.New("Don't found tag from redis")
+			return nil, 0, errors.New("Don't found tag from redis")
 		}
 
 		// 生成 response 内容
-		*articlesRes = append(*articlesRes, ArticleRes{
-			ID:                 article.ID,
-			CreateAt:           article.CreateAt,
-			UpdateAt:           article.UpdateAt,
-			VisitCount:         article.VisitCount,
-			ReplyCount:         article.ReplyCount,
-			ArticleTitle:       article.ArticleTitle,
-			ArticlePreviewText: article.ArticlePreviewText,
-			ArticleContent:     article.ArticleContent,
-			Top:                article.Top,
-			Category:           categoryRes,
-			TagList:            tagsForRes,
-		})
+		*articlesRes = append(*articlesRes, newArticleRes(&article, categoryRes, tagsForRes))
 	}
 
-	return articlesRes, nil
+	return articlesRes, total, nil
 }
 
-// 从 mysql 中获取博文数据
-func getArticlesFromDatabase(limit int64, page int64) (*[]ArticleRes, error) {
-	var articlesRes []ArticleRes
+// 从 mysql 中获取满足过滤条件的博文数据, 同时返回满足条件的博文总数
+func getArticlesFromDatabase(filter *models.ArticleFilter, limit int64, page int64) (*[]ArticleRes, int64, error) {
+	articlesRes := []ArticleRes{}
 
 	// 从数据库获取特定数量的博文
-	articles, err := models.GetArticleByPage(limit, page)
+	articles, total, err := models.GetArticleByPage(filter, limit, page)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
 	// 获取全部分类名
 	categories, err := models.GetAllCategory()
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
 	// 获取全部标签
 	tags, err := models.GetAllTag()
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
 	// 遍历查询出的博文
@@ -575,36 +1392,21 @@ func getArticlesFromDatabase(limit int64, page int64) (*[]ArticleRes, error) {
 		// 找出需要的分类名
 		category := categoryForRes(article.Category, &categories)
 		if len(categories) == 0 {
-			return nil, errors.New("Don't found category from mysql")
+			return nil, 0, errors.New("Don't found category from mysql")
 		}
 
 		// 找出需要的标签
-		tagsForRes, err := tagForRes(article.TagList, tags)
-		if err != nil {
-			return nil, err
-		}
+		tagsForRes := tagForRes(article.Tags, tags)
 
 		if len(tagsForRes) == 0 {
-			return nil, errors.New("Don't found tag from mysql")
+			return nil, 0, errors.New("Don't found tag from mysql")
 		}
 
-		articlesRes = append(articlesRes, ArticleRes{
-			ID:                 article.ID,
-			CreateAt:           article.CreateAt,
-			UpdateAt:           article.UpdateAt,
-			VisitCount:         article.VisitCount,
-			ReplyCount:         article.ReplyCount,
-			ArticleTitle:       article.ArticleTitle,
-			ArticlePreviewText: article.ArticlePreviewText,
-			ArticleContent:     article.ArticleContent,
-			Top:                article.Top,
-			Category:           category,
-			TagList:            tagsForRes,
-		})
+		articlesRes = append(articlesRes, newArticleRes(&article, category, tagsForRes))
 
 	}
 
-	return &articlesRes, nil
+	return &articlesRes, total, nil
 }
 
 //
@@ -0,0 +1,139 @@
+package controllers
+
+import (
+	"encoding/csv"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"github.com/ztplz/blog-server/models"
+)
+
+// GetAuditLogsHandler 管理员查看审计日志, 最新的在前, format=csv 时导出 CSV, 最多导出 AuditExportMax 条
+func GetAuditLogsHandler(c *gin.Context) {
+	filter := &models.AuditFilter{
+		ActorType:  c.Query("actor_type"),
+		ActorID:    c.Query("actor_id"),
+		Action:     c.Query("action"),
+		TargetType: c.Query("target_type"),
+		TargetID:   c.Query("target_id"),
+		RequestID:  c.Query("request_id"),
+		From:       c.Query("from"),
+		To:         c.Query("to"),
+	}
+	format := c.DefaultQuery("format", "json")
+
+	limit, err := strconv.ParseInt(c.DefaultQuery("limit", "20"), 10, 32)
+	page, perr := strconv.ParseInt(c.DefaultQuery("page", "1"), 10, 32)
+	if err == nil {
+		err = perr
+	}
+	if err == nil && (limit <= 0 || page <= 0) {
+		err = errors.New("Limit and page must be positive")
+	}
+	if err == nil && filter.ActorType != "" && filter.ActorType != models.AuditActorAdmin && filter.ActorType != models.AuditActorUser && filter.ActorType != models.AuditActorAnonymous {
+		err = errors.New("Actor type must be admin, user or anonymous")
+	}
+	if err == nil && filter.From != "" {
+		_, err = time.Parse("2006-01-02", filter.From)
+	}
+	if err == nil && filter.To != "" {
+		_, err = time.Parse("2006-01-02", filter.To)
+	}
+	if err == nil && format != "json" && format != "csv" {
+		err = errors.New("Format must be json or csv")
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"statusCode": http.StatusBadRequest,
+			"message":    "Parameter not incorrect",
+		})
+		c.AbortWithStatus(http.StatusBadRequest)
+		log.WithFields(log.Fields{
+			"errorMsg":   err,
+			"query":      c.Request.URL.RawQuery,
+			"statusCode": http.StatusBadRequest,
+		}).Info("Get audit logs failed")
+
+		return
+	}
+
+	// 导出时忽略分页参数
+	if format == "csv" {
+		limit, page = models.AuditExportMax, 1
+	}
+
+	entries, total, err := models.GetAuditLogs(filter, limit, page)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"statusCode": http.StatusInternalServerError,
+			"message":    http.StatusText(http.StatusInternalServerError),
+		})
+		c.AbortWithStatus(http.StatusInternalServerError)
+		log.WithFields(log.Fields{
+			"errorMsg":   err,
+			"statusCode": http.StatusInternalServerError,
+		}).Info("Get audit logs failed")
+
+		return
+	}
+
+	if format == "csv" {
+		writeAuditCSV(c, entries)
+
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"statusCode": http.StatusOK,
+		"total":      total,
+		"logs":       entries,
+	})
+}
+
+func writeAuditCSV(c *gin.Context, entries []*models.AuditLog) {
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", "attachment; filename=audit-"+time.Now().Format("20060102150405")+".csv")
+	c.Status(http.StatusOK)
+
+	w := csv.NewWriter(c.Writer)
+	w.Write([]string{"id", "create_at", "actor_type", "actor_id", "action", "target_type", "target_id", "before", "after", "status_code", "ip", "user_agent", "request_id"})
+	for _, entry := range entries {
+		w.Write([]string{
+			strconv.FormatUint(uint64(entry.ID), 10),
+			entry.CreateAt,
+			entry.ActorType,
+			csvCell(entry.ActorID),
+			csvCell(entry.Action),
+			entry.TargetType,
+			csvCell(entry.TargetID),
+			csvCell(entry.Before),
+			csvCell(entry.After),
+			strconv.Itoa(entry.StatusCode),
+			entry.IP,
+			csvCell(entry.UserAgent),
+			entry.RequestID,
+		})
+	}
+	w.Flush()
+
+	err := w.Error()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+		}).Info("Write audit csv failed")
+	}
+}
+
+// 用表格软件打开时, 以 = + - @ 开头的单元格会被当成公式执行, 前面加上单引号
+func csvCell(value string) string {
+	if value != "" && strings.ContainsAny(value[:1], "=+-@\t\r") {
+		return "'" + value
+	}
+
+	return value
+}
@@ -0,0 +1,63 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCSVCell(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"", ""},
+		{"admin", "admin"},
+		{"PUT /api/v1/tags/:id", "PUT /api/v1/tags/:id"},
+		{"1+1", "1+1"},
+		{"a=b", "a=b"},
+		{"=SUM(A1:A2)", "'=SUM(A1:A2)"},
+		{"+1", "'+1"},
+		{"-1", "'-1"},
+		{"@cmd", "'@cmd"},
+		{"\tvalue", "'\tvalue"},
+		{"\rvalue", "'\rvalue"},
+		{"中文", "中文"},
+	}
+
+	for _, test := range tests {
+		got := csvCell(test.value)
+		if got != test.want {
+			t.Errorf("csvCell(%q) = %q, want %q", test.value, got, test.want)
+		}
+	}
+}
+
+func TestGetAuditLogsHandlerValidation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// 参数不正确时不查询数据库, 直接返回 400
+	tests := []string{
+		"limit=0",
+		"limit=abc",
+		"page=-1",
+		"actor_type=robot",
+		"from=2018-13-01",
+		"to=yesterday",
+		"format=xml",
+	}
+
+	for _, query := range tests {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/api/v1/admin/audit?"+query, nil)
+
+		GetAuditLogsHandler(c)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("GetAuditLogsHandler(%q) status = %d, want %d", query, w.Code, http.StatusBadRequest)
+		}
+	}
+}
@@ -18,6 +18,7 @@ import (
 	"encoding/json"
 	"errors"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/gin-gonic/gin"
@@ -36,13 +37,8 @@ type CategoryForm struct {
 func AddCategoryHandler(c *gin.Context) {
 	var categoryVals CategoryForm
 
-	_, err := middlewares.AdminAuthMiddleware(c)
-	if err != nil {
-		return
-	}
-
 	// 检查是否存在 category 字段
-	err = c.ShouldBindWith(&categoryVals, binding.JSON)
+	err := c.ShouldBindWith(&categoryVals, binding.JSON)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"statusCode": http.StatusBadRequest,
@@ -121,6 +117,8 @@ func AddCategoryHandler(c *gin.Context) {
 
 		return
 	}
+	middlewares.AuditTarget(c, "category", strconv.FormatInt(lastID, 10))
+	middlewares.AuditAfter(c, models.Category{ID: uint(lastID), Category: category})
 
 	c.JSON(http.StatusOK, gin.H{
 		"statusCode": http.StatusOK,
@@ -135,6 +133,9 @@ func AddCategoryHandler(c *gin.Context) {
 		"statusCode": http.StatusOK,
 	}).Info("Add category success")
 
+	// sitemap 里包含分类页面
+	invalidateSitemap()
+
 	// 把数据更新到 redis
 	mcategory, err := json.Marshal(models.Category{ID: uint(lastID), Category: category})
 	if err != nil {
@@ -144,7 +145,7 @@ func AddCategoryHandler(c *gin.Context) {
 		}).Info("Marshal category failed")
 	}
 
-	err = models.RedisClient.HSet("categories", string(lastID), mcategory).Err()
+	err = models.RedisClient.HSet("categories", strconv.FormatInt(lastID, 10), mcategory).Err()
 	if err != nil {
 		log.WithFields(log.Fields{
 			"id":       lastID,
@@ -209,7 +210,7 @@ func GetAllCategoryHandler(c *gin.Context) {
 				return
 			}
 
-			err = models.RedisClient.HSet("categories", string(category.ID), ct).Err()
+			err = models.RedisClient.HSet("categories", strconv.FormatUint(uint64(category.ID), 10), ct).Err()
 			if err != nil {
 				log.WithFields(log.Fields{
 					"errorMsg": err,
@@ -271,14 +272,8 @@ func GetAllCategoryHandler(c *gin.Context) {
 
 // UpdateCategoryHandler 修改某个分类名
 func UpdateCategoryHandler(c *gin.Context) {
-	_, err := middlewares.AdminAuthMiddleware(c)
-
-	if err != nil {
-		return
-	}
-
 	// 要修改的分类名
-	category := c.Param("category")
+	category := c.Param("name")
 	// 替换原来的分类名
 	key := c.Query("category")
 
@@ -298,6 +293,17 @@ func UpdateCategoryHandler(c *gin.Context) {
 		return
 	}
 
+	// 审计日志记录修改前后的分类
+	before := models.Category{Category: category}
+	if categories, err := models.GetAllCategory(); err == nil {
+		for _, ct := range categories {
+			if ct.Category == category {
+				before = ct
+			}
+		}
+	}
+	middlewares.AuditBefore(c, before)
+
 	// 数据库更新分类名
 	err = models.UpdateCategory(category, newCategory)
 	if err != nil {
@@ -312,11 +318,16 @@ func UpdateCategoryHandler(c *gin.Context) {
 
 		return
 	}
+	middlewares.AuditAfter(c, models.Category{ID: before.ID, Category: newCategory})
 
 	c.JSON(200, gin.H{
 		"message": "update success",
 	})
 
+	// 搜索索引里包含分类名
+	rebuildSearchIndex()
+	invalidateSitemap()
+
 	//同步到redis里
 	// err = models.RedisClient.HSet("categories")
 	// if err != nil {
@@ -327,9 +338,31 @@ func UpdateCategoryHandler(c *gin.Context) {
 
 }
 
-// 查询各个分类名文章详情
+// GetArticleByCategory 查询某个分类的博文列表, 支持和博文列表相同的参数
 func GetArticleByCategory(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 0)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"statusCode": http.StatusBadRequest,
+			"message":    "Incorrect category id",
+		})
+		c.AbortWithStatus(http.StatusBadRequest)
+		log.WithFields(log.Fields{
+			"errorMsg":   err,
+			"id":         c.Param("id"),
+			"statusCode": http.StatusBadRequest,
+		}).Info("Get articles by category failed")
+
+		return
+	}
+
+	filter, err := parseArticleFilter(c)
+	if err != nil {
+		return
+	}
+	filter.Category = uint(id)
 
+	listArticles(c, filter)
 }
 
 // 就检查是否重复提交已存在分类名
@@ -0,0 +1,444 @@
+package controllers
+
+import (
+	"database/sql"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	log "github.com/sirupsen/logrus"
+	"github.com/ztplz/blog-server/filter"
+	"github.com/ztplz/blog-server/middlewares"
+	"github.com/ztplz/blog-server/models"
+)
+
+// CommentForm 评论表单, 注册用户填写 user_id 并带上 token, 游客填写名字和邮箱
+type CommentForm struct {
+	UserID      string `form:"user_id" json:"user_id"`
+	AuthorName  string `form:"author_name" json:"author_name"`
+	AuthorEmail string `form:"author_email" json:"author_email"`
+	Content     string `form:"content" json:"content" binding:"required"`
+	ParentID    uint   `form:"parent_id" json:"parent_id"`
+}
+
+// CommentStatusForm 修改评论审核状态表单
+type CommentStatusForm struct {
+	Status string `form:"status" json:"status" binding:"required"`
+}
+
+// CommentBulkStatusForm 批量修改评论审核状态表单
+type CommentBulkStatusForm struct {
+	IDs    []uint `form:"ids" json:"ids" binding:"required"`
+	Status string `form:"status" json:"status" binding:"required"`
+}
+
+// ModerationComment 审核队列里的评论, 管理员可以看到邮箱、IP 和内容过滤的原因
+type ModerationComment struct {
+	*models.Comment
+	AuthorEmail  string `json:"author_email"`
+	IP           string `json:"ip"`
+	FilterScore  int    `json:"filter_score"`
+	FilterReason string `json:"filter_reason"`
+}
+
+// AddCommentHandler 评论博文或者回复评论
+func AddCommentHandler(c *gin.Context) {
+	var commentVals CommentForm
+
+	id, err := parseArticleID(c)
+	if err != nil {
+		return
+	}
+
+	err = c.ShouldBindWith(&commentVals, binding.JSON)
+	if err != nil {
+		abortCommentForm(c, err, "Miss comment content")
+
+		return
+	}
+
+	content := strings.TrimSpace(commentVals.Content)
+	if content == "" || utf8.RuneCountInString(content) > models.CommentContentLengthMax {
+		abortCommentForm(c, nil, "Comment content length incorrect")
+
+		return
+	}
+
+	comment := &models.Comment{
+		ArticleID: uint(id),
+		ParentID:  commentVals.ParentID,
+		Content:   content,
+		IP:        c.ClientIP(),
+		CreateAt:  time.Now().Format("2006-01-02 15:04:05"),
+	}
+
+	author, ok := checkAuthor(c, commentVals.UserID, commentVals.AuthorName, commentVals.AuthorEmail, "Add comment failed")
+	if !ok {
+		return
+	}
+	comment.UserID = author.UserID
+	comment.AuthorName = author.Name
+	comment.AuthorEmail = author.Email
+
+	// 回复的评论要属于同一篇博文
+	if comment.ParentID != 0 {
+		err = models.CheckCommentParent(comment.ArticleID, comment.ParentID)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				abortCommentForm(c, err, "Parent comment not found")
+			} else {
+				abortCommentError(c, err, "Add comment failed")
+			}
+
+			return
+		}
+	}
+
+	// 内容过滤, 有问题的评论需要审核
+	verdict, ok := filterContent(c, filter.CommentFilter, filter.KindComment, content, "Add comment failed")
+	if !ok {
+		return
+	}
+	comment.Status = contentStatus(author, verdict)
+	comment.FilterScore = verdict.Score
+	comment.FilterReason = verdict.Reason()
+
+	comment.ContentHTML, err = models.RenderCommentMarkdown(content)
+	if err != nil {
+		abortCommentError(c, err, "Add comment failed")
+
+		return
+	}
+
+	lastID, err := models.AddComment(comment)
+	if err != nil {
+		abortCommentError(c, err, "Add comment failed")
+
+		return
+	}
+	comment.ID = uint(lastID)
+	comment.Replies = []*models.Comment{}
+	middlewares.AuditTarget(c, "comment", strconv.FormatInt(lastID, 10))
+	middlewares.AuditAfter(c, comment)
+
+	message := "Add comment success"
+	if comment.Status == models.CommentStatusPending {
+		message = "Comment is awaiting moderation"
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"statusCode": http.StatusOK,
+		"message":    message,
+		"comment":    comment,
+	})
+
+	log.WithFields(log.Fields{
+		"id":         lastID,
+		"articleID":  id,
+		"status":     comment.Status,
+		"statusCode": http.StatusOK,
+	}).Info("Add comment success")
+
+	notifyNewComment(SiteURL, comment)
+
+	// 评论数改变, 同步到 redis
+	if comment.Status == models.CommentStatusApproved {
+		syncArticlesToRedis()
+		notifyCommentReply(SiteURL, comment)
+	}
+}
+
+// GetCommentsHandler 获取博文的评论, 按顶层评论分页, limit(每次返回数) page(页数)
+func GetCommentsHandler(c *gin.Context) {
+	id, err := parseArticleID(c)
+	if err != nil {
+		return
+	}
+
+	limit, lerr := strconv.ParseInt(c.DefaultQuery("limit", "20"), 10, 32)
+	page, perr := strconv.ParseInt(c.DefaultQuery("page", "1"), 10, 32)
+	if lerr != nil || perr != nil || limit <= 0 || page <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"statusCode": http.StatusBadRequest,
+			"message":    "Parameter not incorrect",
+		})
+		c.AbortWithStatus(http.StatusBadRequest)
+		log.WithFields(log.Fields{
+			"errorMsgLimit": lerr,
+			"errorMsgPage":  perr,
+			"statusCode":    http.StatusBadRequest,
+		}).Info("Get comments failed")
+
+		return
+	}
+
+	// 只能查看已发布博文的评论
+	_, err = models.GetArticleByID(id)
+	if err != nil {
+		abortCommentError(c, err, "Get comments failed")
+
+		return
+	}
+
+	comments, err := models.GetCommentsByArticle(id)
+	if err != nil {
+		abortCommentError(c, err, "Get comments failed")
+
+		return
+	}
+
+	total := int64(len(comments))
+	start := limit * (page - 1)
+	end := start + limit
+	if start > total {
+		start = total
+	}
+	if end > total {
+		end = total
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"statusCode": http.StatusOK,
+		"total":      total,
+		"comments":   comments[start:end],
+	})
+}
+
+// GetCommentQueueHandler 管理员获取某个审核状态的评论, status 默认为 pending, limit(每次返回数) page(页数)
+func GetCommentQueueHandler(c *gin.Context) {
+	status, limit, page, ok := parseModerationQuery(c, "Get comment queue failed")
+	if !ok {
+		return
+	}
+
+	comments, total, err := models.GetCommentsByStatus(status, limit, page)
+	if err != nil {
+		abortCommentError(c, err, "Get comment queue failed")
+
+		return
+	}
+
+	queue := make([]ModerationComment, 0, len(comments))
+	for _, comment := range comments {
+		queue = append(queue, ModerationComment{
+			Comment:      comment,
+			AuthorEmail:  comment.AuthorEmail,
+			IP:           comment.IP,
+			FilterScore:  comment.FilterScore,
+			FilterReason: comment.FilterReason,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"statusCode": http.StatusOK,
+		"total":      total,
+		"comments":   queue,
+	})
+}
+
+// UpdateCommentStatusHandler 管理员修改某条评论的审核状态
+func UpdateCommentStatusHandler(c *gin.Context) {
+	var statusVals CommentStatusForm
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		abortCommentForm(c, err, "Incorrect comment id")
+
+		return
+	}
+
+	err = c.ShouldBindWith(&statusVals, binding.JSON)
+	if err != nil || !models.ValidCommentStatus(statusVals.Status) {
+		abortCommentForm(c, err, "Incorrect comment status")
+
+		return
+	}
+
+	moderateComments(c, []uint{uint(id)}, statusVals.Status)
+}
+
+// BulkUpdateCommentStatusHandler 管理员批量修改评论的审核状态
+func BulkUpdateCommentStatusHandler(c *gin.Context) {
+	var statusVals CommentBulkStatusForm
+
+	err := c.ShouldBindWith(&statusVals, binding.JSON)
+	if err != nil || len(statusVals.IDs) == 0 || !models.ValidCommentStatus(statusVals.Status) {
+		abortCommentForm(c, err, "Incorrect comment ids or status")
+
+		return
+	}
+
+	moderateComments(c, statusVals.IDs, statusVals.Status)
+}
+
+// 解析审核队列的参数, status 默认为 pending, limit 默认为 20
+func parseModerationQuery(c *gin.Context, logMsg string) (string, int64, int64, bool) {
+	status := c.DefaultQuery("status", models.CommentStatusPending)
+	limit, lerr := strconv.ParseInt(c.DefaultQuery("limit", "20"), 10, 32)
+	page, perr := strconv.ParseInt(c.DefaultQuery("page", "1"), 10, 32)
+	if !models.ValidCommentStatus(status) || lerr != nil || perr != nil || limit <= 0 || page <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"statusCode": http.StatusBadRequest,
+			"message":    "Parameter not incorrect",
+		})
+		c.AbortWithStatus(http.StatusBadRequest)
+		log.WithFields(log.Fields{
+			"status":        status,
+			"errorMsgLimit": lerr,
+			"errorMsgPage":  perr,
+			"statusCode":    http.StatusBadRequest,
+		}).Info(logMsg)
+
+		return "", 0, 0, false
+	}
+
+	return status, limit, page, true
+}
+
+// 修改评论的审核状态并返回响应, 评论数改变后同步到 redis, 新通过审核的回复通知被回复的人
+func moderateComments(c *gin.Context, ids []uint, status string) {
+	if statuses, err := models.GetCommentStatuses(ids); err == nil {
+		middlewares.AuditBefore(c, statuses)
+	}
+
+	changed, err := models.SetCommentStatus(ids, status)
+	if err != nil {
+		abortCommentError(c, err, "Update comment status failed")
+
+		return
+	}
+	if statuses, err := models.GetCommentStatuses(ids); err == nil {
+		middlewares.AuditAfter(c, statuses)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"statusCode": http.StatusOK,
+		"message":    "Update comment status success",
+		"count":      len(changed),
+	})
+
+	log.WithFields(log.Fields{
+		"ids":        ids,
+		"status":     status,
+		"count":      len(changed),
+		"statusCode": http.StatusOK,
+	}).Info("Update comment status success")
+
+	if len(changed) == 0 {
+		return
+	}
+
+	syncArticlesToRedis()
+
+	if status == models.CommentStatusApproved {
+		for _, id := range changed {
+			comment, err := models.GetComment(id)
+			if err != nil {
+				continue
+			}
+
+			notifyCommentReply(SiteURL, comment)
+		}
+	}
+}
+
+// 评论和留言的作者
+type contentAuthor struct {
+	UserID string
+	Name   string
+	Email  string
+
+	// 已经有评论通过审核的注册用户
+	Trusted bool
+}
+
+// 检查评论或留言的作者, 注册用户需要验证 token 并且不在黑名单里, 名字使用用户名
+// 游客需要填写名字和邮箱
+func checkAuthor(c *gin.Context, userID string, name string, email string, logMsg string) (*contentAuthor, bool) {
+	if userID != "" {
+		// 验证 token, 黑名单用户不能评论和留言
+		user, err := middlewares.AuthenticateUser(c, userID)
+		if err != nil {
+			return nil, false
+		}
+
+		count, err := models.CountUserApprovedComments(user.UserID)
+		if err != nil {
+			abortCommentError(c, err, logMsg)
+
+			return nil, false
+		}
+
+		return &contentAuthor{UserID: user.UserID, Name: user.UserName, Trusted: count > 0}, true
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" || utf8.RuneCountInString(name) > models.CommentAuthorLengthMax {
+		abortCommentForm(c, nil, "Author name length incorrect")
+
+		return nil, false
+	}
+
+	address, err := mail.ParseAddress(strings.TrimSpace(email))
+	if err != nil || address.Name != "" {
+		abortCommentForm(c, err, "Incorrect author email")
+
+		return nil, false
+	}
+
+	_, ok := filterContent(c, filter.NameFilter, filter.KindUserName, name, logMsg)
+	if !ok {
+		return nil, false
+	}
+
+	return &contentAuthor{Name: name, Email: address.Address}, true
+}
+
+// 内容过滤通过并且作者可信时直接通过审核, 否则需要审核
+func contentStatus(author *contentAuthor, verdict *filter.Verdict) string {
+	if author.Trusted && verdict.Action == filter.ActionPass {
+		return models.CommentStatusApproved
+	}
+
+	return models.CommentStatusPending
+}
+
+// 评论表单不正确时返回 400
+func abortCommentForm(c *gin.Context, err error, message string) {
+	c.JSON(http.StatusBadRequest, gin.H{
+		"statusCode": http.StatusBadRequest,
+		"message":    message,
+	})
+	c.AbortWithStatus(http.StatusBadRequest)
+	log.WithFields(log.Fields{
+		"errorMsg":   err,
+		"message":    message,
+		"statusCode": http.StatusBadRequest,
+	}).Info("Comment form incorrect")
+}
+
+// 评论查询出错时返回响应, 博文或用户不存在返回 404, 其他错误返回 500
+func abortCommentError(c *gin.Context, err error, logMsg string) {
+	statusCode := http.StatusInternalServerError
+	message := http.StatusText(http.StatusInternalServerError)
+	if err == sql.ErrNoRows {
+		statusCode = http.StatusNotFound
+		message = http.StatusText(http.StatusNotFound)
+	}
+
+	c.JSON(statusCode, gin.H{
+		"statusCode": statusCode,
+		"message":    message,
+	})
+	c.AbortWithStatus(statusCode)
+	log.WithFields(log.Fields{
+		"errorMsg":   err,
+		"id":         c.Param("id"),
+		"statusCode": statusCode,
+	}).Info(logMsg)
+}
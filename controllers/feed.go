@@ -0,0 +1,295 @@
+/*
+* 博文订阅
+*
+* 提供 RSS 2.0、Atom 和 JSON Feed 三种格式，可以订阅全部博文，也可以只订阅某个分类或标签。
+* 响应带有 ETag 和 Last-Modified，内容没有变化时返回 304
+ */
+
+package controllers
+
+import (
+	"crypto/sha1"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/feeds"
+	log "github.com/sirupsen/logrus"
+	"github.com/ztplz/blog-server/models"
+)
+
+const (
+	feedTitle       = "ztplz's blog"
+	feedDescription = "ztplz 的博客"
+	feedAuthor      = "ztplz"
+
+	// 订阅里的博文数
+	feedLimit = 20
+
+	// 前端博文页面的路径, 后面接博文的 slug
+	articlePagePath = "/articles/"
+
+	feedRSS  = "rss"
+	feedAtom = "atom"
+	feedJSON = "json"
+)
+
+// SiteURL 站点的地址, 订阅、sitemap 和邮件里的链接都以它开头, 不能使用请求头里的 Host
+var SiteURL string
+
+// InitialSiteURL 从环境变量 BLOG_SITE_URL 读取站点的地址, 没有设置时使用本地地址
+func InitialSiteURL() {
+	SiteURL = strings.TrimSuffix(os.Getenv("BLOG_SITE_URL"), "/")
+	if SiteURL == "" {
+		SiteURL = "http://localhost:8080"
+		log.Info("BLOG_SITE_URL is empty, use " + SiteURL)
+	}
+
+	u, err := url.Parse(SiteURL)
+	if err == nil && (u.Scheme != "http" && u.Scheme != "https" || u.Host == "") {
+		err = errors.New("Site url must be an absolute http or https url")
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+			"siteURL":  SiteURL,
+		}).Fatal("Initial site url failed")
+	}
+}
+
+// RSSFeedHandler RSS 2.0 订阅
+func RSSFeedHandler(c *gin.Context) {
+	serveFeed(c, feedRSS)
+}
+
+// AtomFeedHandler Atom 订阅
+func AtomFeedHandler(c *gin.Context) {
+	serveFeed(c, feedAtom)
+}
+
+// JSONFeedHandler JSON Feed 订阅
+func JSONFeedHandler(c *gin.Context) {
+	serveFeed(c, feedJSON)
+}
+
+// 生成订阅内容, 路由参数 category 或 tag 存在时只包含该分类或标签的博文
+// 查询参数 content=full 时包含博文全文, 默认只包含预览
+func serveFeed(c *gin.Context, format string) {
+	full := c.DefaultQuery("content", "preview") == "full"
+
+	feed, err := buildFeed(c, full)
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		if err == sql.ErrNoRows {
+			statusCode = http.StatusNotFound
+		}
+
+		c.JSON(statusCode, gin.H{
+			"statusCode": statusCode,
+			"message":    http.StatusText(statusCode),
+		})
+		c.AbortWithStatus(statusCode)
+		log.WithFields(log.Fields{
+			"errorMsg":   err,
+			"format":     format,
+			"statusCode": statusCode,
+		}).Info("Build feed failed")
+
+		return
+	}
+
+	var body string
+	var contentType string
+	switch format {
+	case feedAtom:
+		body, err = feed.ToAtom()
+		contentType = "application/atom+xml; charset=utf-8"
+	case feedJSON:
+		body, err = feed.ToJSON()
+		contentType = "application/feed+json; charset=utf-8"
+	default:
+		body, err = feed.ToRss()
+		contentType = "application/rss+xml; charset=utf-8"
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"statusCode": http.StatusInternalServerError,
+			"message":    http.StatusText(http.StatusInternalServerError),
+		})
+		c.AbortWithStatus(http.StatusInternalServerError)
+		log.WithFields(log.Fields{
+			"errorMsg":   err,
+			"format":     format,
+			"statusCode": http.StatusInternalServerError,
+		}).Info("Encode feed failed")
+
+		return
+	}
+
+	writeConditional(c, []byte(body), contentType, feed.Updated)
+}
+
+// 根据路由参数生成订阅, 分类或标签不存在时返回 sql.ErrNoRows
+func buildFeed(c *gin.Context, full bool) (*feeds.Feed, error) {
+	base := SiteURL
+	filter := &models.ArticleFilter{Sort: models.ArticleSortCreated}
+	title := feedTitle
+	link := base
+
+	if id := c.Param("category"); id != "" {
+		category, err := findCategory(id)
+		if err != nil {
+			return nil, err
+		}
+
+		filter.Category = category.ID
+		title += " - " + category.Category
+		link = base + "/categories/" + id
+	}
+
+	if id := c.Param("tag"); id != "" {
+		tag, err := findTag(id)
+		if err != nil {
+			return nil, err
+		}
+
+		filter.Tag = tag.ID
+		title += " - " + tag.TagTitle
+		link = base + "/tags/" + id
+	}
+
+	articles, _, err := models.GetArticleByPage(filter, feedLimit, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	feed := &feeds.Feed{
+		Title:       title,
+		Link:        &feeds.Link{Href: link},
+		Description: feedDescription,
+		Author:      &feeds.Author{Name: feedAuthor},
+		Id:          base + c.Request.URL.Path,
+	}
+
+	for _, article := range *articles {
+		url := base + articlePagePath + article.Slug
+		item := &feeds.Item{
+			Title:       article.ArticleTitle,
+			Link:        &feeds.Link{Href: url},
+			Author:      &feeds.Author{Name: feedAuthor},
+			Description: article.ArticlePreviewText,
+			Id:          url,
+			Created:     parseDatetime(article.CreateAt),
+			Updated:     parseDatetime(article.UpdateAt),
+		}
+		if full {
+			item.Content = article.ArticleHTML
+		}
+
+		// 订阅的更新时间为最后修改的博文的时间
+		if item.Updated.After(feed.Updated) {
+			feed.Updated = item.Updated
+		}
+		if item.Created.After(feed.Updated) {
+			feed.Updated = item.Created
+		}
+
+		feed.Add(item)
+	}
+
+	return feed, nil
+}
+
+// 根据 id 查询分类, 不存在时返回 sql.ErrNoRows
+func findCategory(id string) (*models.Category, error) {
+	cid, err := strconv.ParseUint(id, 10, 0)
+	if err != nil {
+		return nil, sql.ErrNoRows
+	}
+
+	categories, err := models.GetAllCategory()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, category := range categories {
+		if category.ID == uint(cid) {
+			return &category, nil
+		}
+	}
+
+	return nil, sql.ErrNoRows
+}
+
+// 根据 id 查询标签, 不存在时返回 sql.ErrNoRows
+func findTag(id string) (*models.Tag, error) {
+	tid, err := strconv.ParseUint(id, 10, 0)
+	if err != nil {
+		return nil, sql.ErrNoRows
+	}
+
+	tags, err := models.GetAllTag()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, tag := range *tags {
+		if tag.ID == uint(tid) {
+			return &tag, nil
+		}
+	}
+
+	return nil, sql.ErrNoRows
+}
+
+// 返回带 ETag 和 Last-Modified 的响应, 客户端的缓存仍然有效时返回 304
+func writeConditional(c *gin.Context, body []byte, contentType string, lastModified time.Time) {
+	sum := sha1.Sum(body)
+	etag := `"` + hex.EncodeToString(sum[:]) + `"`
+
+	c.Header("ETag", etag)
+	if !lastModified.IsZero() {
+		c.Header("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	// 有 If-None-Match 时忽略 If-Modified-Since
+	if match := c.GetHeader("If-None-Match"); match != "" {
+		for _, tag := range strings.Split(match, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == etag || tag == "*" {
+				c.Status(http.StatusNotModified)
+
+				return
+			}
+		}
+	} else if since, err := http.ParseTime(c.GetHeader("If-Modified-Since")); err == nil && !lastModified.IsZero() {
+		// http 时间只精确到秒
+		if !lastModified.Truncate(time.Second).After(since) {
+			c.Status(http.StatusNotModified)
+
+			return
+		}
+	}
+
+	c.Data(http.StatusOK, contentType, body)
+}
+
+// 把数据库里的时间转换成 time.Time, 解析失败时返回零值
+func parseDatetime(datetime string) time.Time {
+	t, err := time.Parse(time.RFC3339, datetime)
+	if err != nil {
+		t, err = time.ParseInLocation("2006-01-02 15:04:05", datetime, time.Local)
+	}
+	if err != nil {
+		return time.Time{}
+	}
+
+	return t
+}
@@ -0,0 +1,106 @@
+package controllers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	log "github.com/sirupsen/logrus"
+	"github.com/ztplz/blog-server/filter"
+	"github.com/ztplz/blog-server/middlewares"
+	"github.com/ztplz/blog-server/models"
+)
+
+// SensitiveWordsForm 敏感词表单
+type SensitiveWordsForm struct {
+	Words []string `form:"words" json:"words"`
+}
+
+// GetSensitiveWordsHandler 管理员获取敏感词
+func GetSensitiveWordsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"statusCode": http.StatusOK,
+		"words":      filter.SensitiveWords(),
+	})
+}
+
+// UpdateSensitiveWordsHandler 管理员替换全部敏感词
+func UpdateSensitiveWordsHandler(c *gin.Context) {
+	var wordsVals SensitiveWordsForm
+
+	middlewares.AuditTarget(c, "option", models.OptionSensitiveWords)
+	middlewares.AuditBefore(c, filter.SensitiveWords())
+
+	err := c.ShouldBindWith(&wordsVals, binding.JSON)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"statusCode": http.StatusBadRequest,
+			"message":    "Sensitive words form incorrect",
+		})
+		c.AbortWithStatus(http.StatusBadRequest)
+		log.WithFields(log.Fields{
+			"errorMsg":   err,
+			"statusCode": http.StatusBadRequest,
+		}).Info("Update sensitive words failed")
+
+		return
+	}
+
+	words := filter.ParseWords(strings.Join(wordsVals.Words, "\n"))
+
+	err = models.SetOption(models.OptionSensitiveWords, strings.Join(words, "\n"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"statusCode": http.StatusInternalServerError,
+			"message":    http.StatusText(http.StatusInternalServerError),
+		})
+		c.AbortWithStatus(http.StatusInternalServerError)
+		log.WithFields(log.Fields{
+			"errorMsg":   err,
+			"statusCode": http.StatusInternalServerError,
+		}).Info("Update sensitive words failed")
+
+		return
+	}
+
+	filter.SetSensitiveWords(words)
+
+	c.JSON(http.StatusOK, gin.H{
+		"statusCode": http.StatusOK,
+		"message":    "Update sensitive words success",
+		"words":      words,
+	})
+
+	log.WithFields(log.Fields{
+		"count":      len(words),
+		"statusCode": http.StatusOK,
+	}).Info("Update sensitive words success")
+}
+
+// 用过滤器检查内容, 被拒绝时返回 400 和原因
+func filterContent(c *gin.Context, pipeline *filter.Pipeline, kind string, text string, logMsg string) (*filter.Verdict, bool) {
+	verdict := pipeline.Run(&filter.Content{
+		Kind: kind,
+		Text: text,
+		IP:   c.ClientIP(),
+	})
+
+	if verdict.Action == filter.ActionReject {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"statusCode": http.StatusBadRequest,
+			"message":    "Content rejected by filter",
+			"reasons":    verdict.Results,
+		})
+		c.AbortWithStatus(http.StatusBadRequest)
+		log.WithFields(log.Fields{
+			"kind":       kind,
+			"reason":     verdict.Reason(),
+			"statusCode": http.StatusBadRequest,
+		}).Info(logMsg)
+
+		return verdict, false
+	}
+
+	return verdict, true
+}
@@ -0,0 +1,392 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	log "github.com/sirupsen/logrus"
+	"github.com/ztplz/blog-server/filter"
+	"github.com/ztplz/blog-server/middlewares"
+	"github.com/ztplz/blog-server/models"
+)
+
+const (
+	// 同一个 IP 在 guestbookRateWindow 时间内最多留言 guestbookRateLimit 次
+	guestbookRateLimit  = 3
+	guestbookRateWindow = 10 * time.Minute
+)
+
+// GuestbookForm 留言表单, 注册用户填写 user_id 并带上 token, 游客填写名字和邮箱
+type GuestbookForm struct {
+	UserID      string `form:"user_id" json:"user_id"`
+	AuthorName  string `form:"author_name" json:"author_name"`
+	AuthorEmail string `form:"author_email" json:"author_email"`
+	Content     string `form:"content" json:"content" binding:"required"`
+}
+
+// GuestbookReplyForm 管理员回复留言表单
+type GuestbookReplyForm struct {
+	Content string `form:"content" json:"content" binding:"required"`
+}
+
+// GuestbookPinForm 置顶留言表单
+type GuestbookPinForm struct {
+	Pinned *bool `form:"pinned" json:"pinned" binding:"required"`
+}
+
+// ModerationGuestbookMessage 审核队列里的留言, 管理员可以看到邮箱、IP 和内容过滤的原因
+type ModerationGuestbookMessage struct {
+	*models.GuestbookMessage
+	AuthorEmail  string `json:"author_email"`
+	IP           string `json:"ip"`
+	FilterScore  int    `json:"filter_score"`
+	FilterReason string `json:"filter_reason"`
+}
+
+// GetGuestbookHandler 获取通过审核的留言, 置顶的留言在前, limit(每次返回数) page(页数)
+func GetGuestbookHandler(c *gin.Context) {
+	limit, lerr := strconv.ParseInt(c.DefaultQuery("limit", "20"), 10, 32)
+	page, perr := strconv.ParseInt(c.DefaultQuery("page", "1"), 10, 32)
+	if lerr != nil || perr != nil || limit <= 0 || page <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"statusCode": http.StatusBadRequest,
+			"message":    "Parameter not incorrect",
+		})
+		c.AbortWithStatus(http.StatusBadRequest)
+		log.WithFields(log.Fields{
+			"errorMsgLimit": lerr,
+			"errorMsgPage":  perr,
+			"statusCode":    http.StatusBadRequest,
+		}).Info("Get guestbook failed")
+
+		return
+	}
+
+	messages, total, err := models.GetGuestbookByPage(limit, page)
+	if err != nil {
+		abortCommentError(c, err, "Get guestbook failed")
+
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"statusCode": http.StatusOK,
+		"total":      total,
+		"messages":   messages,
+	})
+}
+
+// AddGuestbookHandler 留言, 同一个 IP 有频率限制
+func AddGuestbookHandler(c *gin.Context) {
+	var guestbookVals GuestbookForm
+
+	ok := checkRateLimit(c, "guestbook_rate_"+c.ClientIP(), guestbookRateLimit, guestbookRateWindow, "Add guestbook failed")
+	if !ok {
+		return
+	}
+
+	err := c.ShouldBindWith(&guestbookVals, binding.JSON)
+	if err != nil {
+		abortCommentForm(c, err, "Miss guestbook content")
+
+		return
+	}
+
+	content, ok := checkGuestbookContent(c, guestbookVals.Content)
+	if !ok {
+		return
+	}
+
+	author, ok := checkAuthor(c, guestbookVals.UserID, guestbookVals.AuthorName, guestbookVals.AuthorEmail, "Add guestbook failed")
+	if !ok {
+		return
+	}
+
+	// 内容过滤, 有问题的留言需要审核
+	verdict, ok := filterContent(c, filter.CommentFilter, filter.KindGuestbook, content, "Add guestbook failed")
+	if !ok {
+		return
+	}
+
+	message := &models.GuestbookMessage{
+		UserID:       author.UserID,
+		AuthorName:   author.Name,
+		AuthorEmail:  author.Email,
+		Content:      content,
+		IP:           c.ClientIP(),
+		Status:       contentStatus(author, verdict),
+		FilterScore:  verdict.Score,
+		FilterReason: verdict.Reason(),
+		CreateAt:     time.Now().Format("2006-01-02 15:04:05"),
+	}
+
+	ok = addGuestbookMessage(c, message)
+	if ok {
+		notifyNewGuestbook(SiteURL, message)
+	}
+}
+
+// ReplyGuestbookHandler 管理员回复留言, 回复标记为博主的回复
+func ReplyGuestbookHandler(c *gin.Context) {
+	var replyVals GuestbookReplyForm
+
+	admin := middlewares.CurrentAdmin(c)
+
+	parent, ok := getGuestbookMessage(c, "Reply guestbook failed")
+	if !ok {
+		return
+	}
+
+	err := c.ShouldBindWith(&replyVals, binding.JSON)
+	if err != nil {
+		abortCommentForm(c, err, "Miss reply content")
+
+		return
+	}
+
+	content, ok := checkGuestbookContent(c, replyVals.Content)
+	if !ok {
+		return
+	}
+
+	message := &models.GuestbookMessage{
+		ParentID:   parent.ID,
+		AuthorName: admin.AdminName,
+		Content:    content,
+		IP:         c.ClientIP(),
+		IsOwner:    true,
+		Status:     models.CommentStatusApproved,
+		CreateAt:   time.Now().Format("2006-01-02 15:04:05"),
+	}
+
+	ok = addGuestbookMessage(c, message)
+	if ok {
+		notifyGuestbookReply(SiteURL, message, parent)
+	}
+}
+
+// PinGuestbookHandler 置顶或取消置顶留言
+func PinGuestbookHandler(c *gin.Context) {
+	var pinVals GuestbookPinForm
+
+	message, ok := getGuestbookMessage(c, "Pin guestbook failed")
+	if !ok {
+		return
+	}
+
+	err := c.ShouldBindWith(&pinVals, binding.JSON)
+	if err != nil {
+		abortCommentForm(c, err, "Miss pinned")
+
+		return
+	}
+
+	middlewares.AuditBefore(c, *message)
+
+	err = models.PinGuestbookMessage(message.ID, *pinVals.Pinned)
+	if err != nil {
+		abortCommentError(c, err, "Pin guestbook failed")
+
+		return
+	}
+	message.IsPinned = *pinVals.Pinned
+	middlewares.AuditAfter(c, message)
+
+	c.JSON(http.StatusOK, gin.H{
+		"statusCode": http.StatusOK,
+		"message":    "Pin guestbook success",
+		"pinned":     *pinVals.Pinned,
+	})
+
+	log.WithFields(log.Fields{
+		"id":         message.ID,
+		"pinned":     *pinVals.Pinned,
+		"statusCode": http.StatusOK,
+	}).Info("Pin guestbook success")
+}
+
+// GetGuestbookQueueHandler 管理员获取某个审核状态的留言, status 默认为 pending, limit(每次返回数) page(页数)
+func GetGuestbookQueueHandler(c *gin.Context) {
+	status, limit, page, ok := parseModerationQuery(c, "Get guestbook queue failed")
+	if !ok {
+		return
+	}
+
+	messages, total, err := models.GetGuestbookByStatus(status, limit, page)
+	if err != nil {
+		abortCommentError(c, err, "Get guestbook queue failed")
+
+		return
+	}
+
+	queue := make([]ModerationGuestbookMessage, 0, len(messages))
+	for _, message := range messages {
+		queue = append(queue, ModerationGuestbookMessage{
+			GuestbookMessage: message,
+			AuthorEmail:      message.AuthorEmail,
+			IP:               message.IP,
+			FilterScore:      message.FilterScore,
+			FilterReason:     message.FilterReason,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"statusCode": http.StatusOK,
+		"total":      total,
+		"messages":   queue,
+	})
+}
+
+// UpdateGuestbookStatusHandler 管理员修改某条留言的审核状态
+func UpdateGuestbookStatusHandler(c *gin.Context) {
+	var statusVals CommentStatusForm
+
+	middlewares.AuditTarget(c, "guestbook", c.Param("id"))
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		abortCommentForm(c, err, "Incorrect guestbook id")
+
+		return
+	}
+
+	err = c.ShouldBindWith(&statusVals, binding.JSON)
+	if err != nil || !models.ValidCommentStatus(statusVals.Status) {
+		abortCommentForm(c, err, "Incorrect guestbook status")
+
+		return
+	}
+
+	moderateGuestbook(c, []uint{uint(id)}, statusVals.Status)
+}
+
+// BulkUpdateGuestbookStatusHandler 管理员批量修改留言的审核状态
+func BulkUpdateGuestbookStatusHandler(c *gin.Context) {
+	var statusVals CommentBulkStatusForm
+
+	middlewares.AuditTarget(c, "guestbook", "")
+
+	err := c.ShouldBindWith(&statusVals, binding.JSON)
+	if err != nil || len(statusVals.IDs) == 0 || !models.ValidCommentStatus(statusVals.Status) {
+		abortCommentForm(c, err, "Incorrect guestbook ids or status")
+
+		return
+	}
+
+	moderateGuestbook(c, statusVals.IDs, statusVals.Status)
+}
+
+// 检查留言内容长度, 返回除去两边空白的内容
+func checkGuestbookContent(c *gin.Context, content string) (string, bool) {
+	content = strings.TrimSpace(content)
+	if content == "" || utf8.RuneCountInString(content) > models.CommentContentLengthMax {
+		abortCommentForm(c, nil, "Guestbook content length incorrect")
+
+		return "", false
+	}
+
+	return content, true
+}
+
+// 根据路由里的 id 获取顶层留言, 不存在时返回 404, 是回复时返回 400
+func getGuestbookMessage(c *gin.Context, logMsg string) (*models.GuestbookMessage, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		abortCommentForm(c, err, "Incorrect guestbook id")
+
+		return nil, false
+	}
+
+	message, err := models.GetGuestbookMessage(uint(id))
+	if err != nil {
+		abortCommentError(c, err, logMsg)
+
+		return nil, false
+	}
+
+	if message.ParentID != 0 {
+		abortCommentForm(c, nil, "Guestbook message is a reply")
+
+		return nil, false
+	}
+
+	return message, true
+}
+
+// 渲染并保存留言或回复, 响应里返回保存后的留言, 成功时返回 true
+func addGuestbookMessage(c *gin.Context, message *models.GuestbookMessage) bool {
+	var err error
+
+	message.ContentHTML, err = models.RenderCommentMarkdown(message.Content)
+	if err != nil {
+		abortCommentError(c, err, "Add guestbook failed")
+
+		return false
+	}
+
+	lastID, err := models.AddGuestbookMessage(message)
+	if err != nil {
+		abortCommentError(c, err, "Add guestbook failed")
+
+		return false
+	}
+	message.ID = uint(lastID)
+	message.Replies = []*models.GuestbookMessage{}
+	middlewares.AuditTarget(c, "guestbook", strconv.FormatInt(lastID, 10))
+	middlewares.AuditAfter(c, message)
+
+	msg := "Add guestbook success"
+	if message.Status == models.CommentStatusPending {
+		msg = "Guestbook message is awaiting moderation"
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"statusCode": http.StatusOK,
+		"message":    msg,
+		"guestbook":  message,
+	})
+
+	log.WithFields(log.Fields{
+		"id":         lastID,
+		"parentID":   message.ParentID,
+		"status":     message.Status,
+		"statusCode": http.StatusOK,
+	}).Info("Add guestbook success")
+
+	return true
+}
+
+// 修改留言的审核状态并返回响应
+func moderateGuestbook(c *gin.Context, ids []uint, status string) {
+	if statuses, err := models.GetGuestbookStatuses(ids); err == nil {
+		middlewares.AuditBefore(c, statuses)
+	}
+
+	count, err := models.SetGuestbookStatus(ids, status)
+	if err != nil {
+		abortCommentError(c, err, "Update guestbook status failed")
+
+		return
+	}
+	if statuses, err := models.GetGuestbookStatuses(ids); err == nil {
+		middlewares.AuditAfter(c, statuses)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"statusCode": http.StatusOK,
+		"message":    "Update guestbook status success",
+		"count":      count,
+	})
+
+	log.WithFields(log.Fields{
+		"ids":        ids,
+		"status":     status,
+		"count":      count,
+		"statusCode": http.StatusOK,
+	}).Info("Update guestbook status success")
+}
@@ -0,0 +1,180 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"github.com/ztplz/blog-server/middlewares"
+	"github.com/ztplz/blog-server/models"
+)
+
+var (
+	// 同一个账号失败 5 次后锁定 1 分钟, 之后每次翻倍, 最长 1 小时
+	accountLoginPolicy = &models.LoginPolicy{
+		Threshold: 5,
+		Window:    24 * time.Hour,
+		Base:      time.Minute,
+		Max:       time.Hour,
+	}
+
+	// 同一个 IP 对所有账号失败 20 次后锁定
+	ipLoginPolicy = &models.LoginPolicy{
+		Threshold: 20,
+		Window:    time.Hour,
+		Base:      time.Minute,
+		Max:       time.Hour,
+	}
+)
+
+// GetLoginLocksHandler 管理员查看当前被锁定的账号和 IP
+func GetLoginLocksHandler(c *gin.Context) {
+	locks, err := models.GetLoginLocks()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"statusCode": http.StatusInternalServerError,
+			"message":    http.StatusText(http.StatusInternalServerError),
+		})
+		c.AbortWithStatus(http.StatusInternalServerError)
+		log.WithFields(log.Fields{
+			"errorMsg":   err,
+			"statusCode": http.StatusInternalServerError,
+		}).Info("Get login locks failed")
+
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"statusCode": http.StatusOK,
+		"locks":      locks,
+	})
+}
+
+// ClearLoginLockHandler 管理员解除锁定, kind 为 admin、user 或 ip
+func ClearLoginLockHandler(c *gin.Context) {
+	kind := c.Param("kind")
+	key := c.Param("key")
+	middlewares.AuditTarget(c, "login_lock", kind+"/"+key)
+	if kind != models.LoginGuardAdmin && kind != models.LoginGuardUser && kind != models.LoginGuardIP {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"statusCode": http.StatusBadRequest,
+			"message":    "Incorrect lock kind",
+		})
+		c.AbortWithStatus(http.StatusBadRequest)
+		log.WithFields(log.Fields{
+			"kind":       kind,
+			"statusCode": http.StatusBadRequest,
+		}).Info("Clear login lock failed")
+
+		return
+	}
+
+	err := models.ClearLoginFailures(kind, key)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"statusCode": http.StatusInternalServerError,
+			"message":    http.StatusText(http.StatusInternalServerError),
+		})
+		c.AbortWithStatus(http.StatusInternalServerError)
+		log.WithFields(log.Fields{
+			"errorMsg":   err,
+			"statusCode": http.StatusInternalServerError,
+		}).Info("Clear login lock failed")
+
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"statusCode": http.StatusOK,
+		"message":    "Clear login lock success",
+	})
+
+	log.WithFields(log.Fields{
+		"kind":       kind,
+		"key":        key,
+		"statusCode": http.StatusOK,
+	}).Info("Clear login lock success")
+}
+
+// 登录前检查账号和 IP 是否被锁定, 锁定时返回 429 并带上 Retry-After, redis 出错时不限制
+func checkLoginLock(c *gin.Context, kind string, account string, logMsg string) bool {
+	for _, lock := range [][2]string{{kind, account}, {models.LoginGuardIP, c.ClientIP()}} {
+		retry, err := models.GetLoginLock(lock[0], lock[1])
+		if err != nil {
+			log.WithFields(log.Fields{
+				"errorMsg": err,
+				"kind":     lock[0],
+				"key":      lock[1],
+			}).Info("Get login lock failed")
+
+			continue
+		}
+		if retry <= 0 {
+			continue
+		}
+
+		c.Header("Retry-After", strconv.Itoa(int(retry.Seconds()+0.5)))
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"statusCode":  http.StatusTooManyRequests,
+			"message":     "Too many failed login attempts, please try again later",
+			"retry_after": int(retry.Seconds() + 0.5),
+		})
+		c.AbortWithStatus(http.StatusTooManyRequests)
+		log.WithFields(log.Fields{
+			"kind":       lock[0],
+			"key":        lock[1],
+			"ip":         c.ClientIP(),
+			"statusCode": http.StatusTooManyRequests,
+		}).Info(logMsg)
+
+		return false
+	}
+
+	return true
+}
+
+// 记录账号和 IP 的一次登录失败
+func recordLoginFailure(c *gin.Context, kind string, account string) {
+	for _, guard := range []struct {
+		kind   string
+		key    string
+		policy *models.LoginPolicy
+	}{
+		{kind, account, accountLoginPolicy},
+		{models.LoginGuardIP, c.ClientIP(), ipLoginPolicy},
+	} {
+		failures, lock, err := models.RecordLoginFailure(guard.kind, guard.key, guard.policy)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"errorMsg": err,
+				"kind":     guard.kind,
+				"key":      guard.key,
+			}).Info("Record login failure failed")
+
+			continue
+		}
+
+		if lock > 0 {
+			log.WithFields(log.Fields{
+				"kind":     guard.kind,
+				"key":      guard.key,
+				"failures": failures,
+				"lockout":  lock.String(),
+			}).Info("Login locked")
+		}
+	}
+}
+
+// 登录成功后清除账号的失败次数, IP 的失败次数保留
+func clearLoginFailures(kind string, account string) {
+	err := models.ClearLoginFailures(kind, account)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+			"kind":     kind,
+			"key":      account,
+		}).Info("Clear login failures failed")
+	}
+}
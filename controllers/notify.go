@@ -0,0 +1,124 @@
+package controllers
+
+import (
+	"fmt"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/ztplz/blog-server/mailer"
+	"github.com/ztplz/blog-server/models"
+)
+
+// 审核状态在通知邮件里的名字
+var statusNames = map[string]string{
+	models.CommentStatusPending:  "待审核",
+	models.CommentStatusApproved: "已通过",
+	models.CommentStatusSpam:     "垃圾评论",
+	models.CommentStatusDeleted:  "已删除",
+}
+
+// 通知管理员有新评论
+func notifyNewComment(base string, comment *models.Comment) {
+	article, err := models.GetArticleByID(uint64(comment.ArticleID))
+	if err != nil {
+		return
+	}
+
+	body := fmt.Sprintf("%s 评论了《%s》:\n\n%s\n\n状态: %s\n查看: %s\n",
+		comment.AuthorName,
+		article.ArticleTitle,
+		comment.Content,
+		statusNames[comment.Status],
+		commentURL(base, article.Slug, comment.ID))
+
+	sendMail(mailer.AdminAddress, "新评论: "+article.ArticleTitle, body)
+}
+
+// 评论通过审核后通知被回复的人, 自己回复自己时不通知
+func notifyCommentReply(base string, reply *models.Comment) {
+	if reply.ParentID == 0 || reply.Status != models.CommentStatusApproved {
+		return
+	}
+
+	parent, err := models.GetComment(reply.ParentID)
+	if err != nil {
+		return
+	}
+
+	// 注册用户使用已验证的邮箱
+	to := parent.AuthorEmail
+	if parent.UserID != "" {
+		to = ""
+		user, err := models.GetUserByUserID(parent.UserID)
+		if err == nil && user.EmailVerified {
+			to = user.Email
+		}
+	}
+
+	if to == "" || to == reply.AuthorEmail || (parent.UserID != "" && parent.UserID == reply.UserID) {
+		return
+	}
+
+	article, err := models.GetArticleByID(uint64(reply.ArticleID))
+	if err != nil {
+		return
+	}
+
+	body := fmt.Sprintf("%s 你好,\n\n%s 回复了你在《%s》的评论:\n\n%s\n\n查看: %s\n",
+		parent.AuthorName,
+		reply.AuthorName,
+		article.ArticleTitle,
+		reply.Content,
+		commentURL(base, article.Slug, reply.ID))
+
+	sendMail(to, "你的评论有了新回复: "+article.ArticleTitle, body)
+}
+
+// 通知管理员有新留言
+func notifyNewGuestbook(base string, message *models.GuestbookMessage) {
+	body := fmt.Sprintf("%s 留言:\n\n%s\n\n状态: %s\n查看: %s\n",
+		message.AuthorName,
+		message.Content,
+		statusNames[message.Status],
+		base+"/guestbook")
+
+	sendMail(mailer.AdminAddress, "新留言: "+message.AuthorName, body)
+}
+
+// 通知留言的人博主回复了留言
+func notifyGuestbookReply(base string, reply *models.GuestbookMessage, parent *models.GuestbookMessage) {
+	if parent.AuthorEmail == "" {
+		return
+	}
+
+	body := fmt.Sprintf("%s 你好,\n\n博主回复了你的留言:\n\n%s\n\n查看: %s\n",
+		parent.AuthorName,
+		reply.Content,
+		base+"/guestbook")
+
+	sendMail(parent.AuthorEmail, "博主回复了你的留言", body)
+}
+
+func commentURL(base string, slug string, id uint) string {
+	return base + articlePagePath + slug + "#comment-" + strconv.FormatUint(uint64(id), 10)
+}
+
+// 把邮件放进发送队列, 出错时只记录日志, 不影响请求
+func sendMail(to string, subject string, body string) {
+	if to == "" {
+		return
+	}
+
+	err := mailer.Enqueue(&mailer.Message{
+		To:      []string{to},
+		Subject: subject,
+		Body:    body,
+	})
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+			"to":       to,
+			"subject":  subject,
+		}).Info("Enqueue mail failed")
+	}
+}
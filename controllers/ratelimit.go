@@ -0,0 +1,42 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"github.com/ztplz/blog-server/models"
+)
+
+// 在 window 时间内 key 的次数超过 limit 时返回 429 并带上 Retry-After, redis 出错时不限制
+func checkRateLimit(c *gin.Context, key string, limit int64, window time.Duration, logMsg string) bool {
+	count, retry, err := models.IncreaseRateCount(key, window)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+			"key":      key,
+		}).Info("Increase rate count failed")
+
+		return true
+	}
+
+	if count > limit {
+		c.Header("Retry-After", strconv.Itoa(int(retry.Seconds()+0.5)))
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"statusCode": http.StatusTooManyRequests,
+			"message":    "Too many requests, please try again later",
+		})
+		c.AbortWithStatus(http.StatusTooManyRequests)
+		log.WithFields(log.Fields{
+			"ip":         c.ClientIP(),
+			"count":      count,
+			"statusCode": http.StatusTooManyRequests,
+		}).Info(logMsg)
+
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,243 @@
+/*
+* 博文历史版本
+*
+* 每次修改博文前都会把原来的标题、预览、内容、分类和标签保存为一个历史版本，
+* 可以比较任意两个版本的差异，也可以把博文恢复成某个历史版本
+ */
+
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pmezard/go-difflib/difflib"
+	log "github.com/sirupsen/logrus"
+	"github.com/ztplz/blog-server/middlewares"
+	"github.com/ztplz/blog-server/models"
+)
+
+// GetArticleRevisionsHandler 获取博文的所有历史版本
+func GetArticleRevisionsHandler(c *gin.Context) {
+	id, err := parseArticleID(c)
+	if err != nil {
+		return
+	}
+
+	if !checkArticleOwnerByID(c, id, "Get article revisions failed") {
+		return
+	}
+
+	revisions, err := models.GetRevisionsByArticle(id)
+	if err != nil {
+		abortArticleQueryError(c, err, "Get article revisions failed")
+
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"statusCode": http.StatusOK,
+		"revisions":  *revisions,
+	})
+}
+
+// DiffArticleRevisionsHandler 比较两个历史版本, from 必填, to 不填时和当前版本比较
+func DiffArticleRevisionsHandler(c *gin.Context) {
+	id, err := parseArticleID(c)
+	if err != nil {
+		return
+	}
+
+	fromID, ferr := strconv.ParseUint(c.Query("from"), 10, 64)
+	var toID uint64
+	var terr error
+	if c.Query("to") != "" {
+		toID, terr = strconv.ParseUint(c.Query("to"), 10, 64)
+	}
+	if ferr != nil || terr != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"statusCode": http.StatusBadRequest,
+			"message":    "Incorrect revision id",
+		})
+		c.AbortWithStatus(http.StatusBadRequest)
+		log.WithFields(log.Fields{
+			"errorMsgFrom": ferr,
+			"errorMsgTo":   terr,
+			"statusCode":   http.StatusBadRequest,
+		}).Info("Diff article revisions failed")
+
+		return
+	}
+
+	if !checkArticleOwnerByID(c, id, "Diff article revisions failed") {
+		return
+	}
+
+	from, err := models.GetRevisionByID(id, fromID)
+	if err != nil {
+		abortArticleQueryError(c, err, "Diff article revisions failed")
+
+		return
+	}
+
+	to, err := getRevisionOrCurrent(id, toID)
+	if err != nil {
+		abortArticleQueryError(c, err, "Diff article revisions failed")
+
+		return
+	}
+
+	fromName := "revision " + strconv.FormatUint(fromID, 10)
+	toName := "current"
+	if toID != 0 {
+		toName = "revision " + strconv.FormatUint(toID, 10)
+	}
+
+	// 逐个字段生成 unified diff, 没有变化的字段为空字符串
+	diff := gin.H{}
+	fields := []struct {
+		name string
+		a, b string
+	}{
+		{"article_title", from.ArticleTitle, to.ArticleTitle},
+		{"article_previewtext", from.ArticlePreviewText, to.ArticlePreviewText},
+		{"article_content", from.ArticleContent, to.ArticleContent},
+		{"category", strconv.FormatUint(uint64(from.Category), 10), strconv.FormatUint(uint64(to.Category), 10)},
+		{"tags", joinTagIDs(from.Tags), joinTagIDs(to.Tags)},
+	}
+	for _, field := range fields {
+		diff[field.name], err = difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+			A:        difflib.SplitLines(field.a),
+			B:        difflib.SplitLines(field.b),
+			FromFile: fromName,
+			ToFile:   toName,
+			Context:  3,
+		})
+		if err != nil {
+			abortArticleQueryError(c, err, "Diff article revisions failed")
+
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"statusCode": http.StatusOK,
+		"from":       fromName,
+		"to":         toName,
+		"diff":       diff,
+	})
+}
+
+// RestoreArticleRevisionHandler 把博文恢复成某个历史版本, 恢复前的版本也会保存为历史版本
+func RestoreArticleRevisionHandler(c *gin.Context) {
+	id, err := parseArticleID(c)
+	if err != nil {
+		return
+	}
+
+	revisionID, err := strconv.ParseUint(c.Param("revisionID"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"statusCode": http.StatusBadRequest,
+			"message":    "Incorrect revision id",
+		})
+		c.AbortWithStatus(http.StatusBadRequest)
+		log.WithFields(log.Fields{
+			"errorMsg":   err,
+			"statusCode": http.StatusBadRequest,
+		}).Info("Restore article revision failed")
+
+		return
+	}
+
+	revision, err := models.GetRevisionByID(id, revisionID)
+	if err != nil {
+		abortArticleQueryError(c, err, "Restore article revision failed")
+
+		return
+	}
+
+	article, err := models.GetAnyArticleByID(id)
+	if err != nil {
+		abortArticleQueryError(c, err, "Restore article revision failed")
+
+		return
+	}
+	if !checkArticleOwner(c, article, "Restore article revision failed") {
+		return
+	}
+	middlewares.AuditBefore(c, *article)
+
+	oldSlug := article.Slug
+	titleChanged := article.ArticleTitle != revision.ArticleTitle
+	contentChanged := article.ArticleContent != revision.ArticleContent
+
+	article.ArticleTitle = revision.ArticleTitle
+	article.ArticlePreviewText = revision.ArticlePreviewText
+	article.ArticleContent = revision.ArticleContent
+	article.Category = revision.Category
+	article.Tags = revision.Tags
+	article.PublishAt = formatDatetime(article.PublishAt)
+
+	// 历史版本里的标签可能已经不存在了
+	err = checkArticleTags(c, article.Tags, "Restore article revision failed")
+	if err != nil {
+		return
+	}
+
+	err = saveArticle(article, oldSlug, titleChanged, contentChanged)
+	if err != nil {
+		abortArticleQueryError(c, err, "Restore article revision failed")
+
+		return
+	}
+	middlewares.AuditAfter(c, article)
+
+	c.JSON(http.StatusOK, gin.H{
+		"statusCode": http.StatusOK,
+		"message":    "Restore article revision success",
+		"article":    *article,
+	})
+
+	log.WithFields(log.Fields{
+		"id":         id,
+		"revisionID": revisionID,
+		"statusCode": http.StatusOK,
+	}).Info("Restore article revision success")
+
+	refreshArticles()
+}
+
+// 获取历史版本, revisionID 为 0 时把当前博文作为一个版本返回
+func getRevisionOrCurrent(articleID uint64, revisionID uint64) (*models.ArticleRevision, error) {
+	if revisionID != 0 {
+		return models.GetRevisionByID(articleID, revisionID)
+	}
+
+	article, err := models.GetAnyArticleByID(articleID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.ArticleRevision{
+		ArticleID:          article.ID,
+		ArticleTitle:       article.ArticleTitle,
+		ArticlePreviewText: article.ArticlePreviewText,
+		ArticleContent:     article.ArticleContent,
+		Category:           article.Category,
+		Tags:               article.Tags,
+		CreateAt:           article.UpdateAt,
+	}, nil
+}
+
+// 把标签 id 用逗号连接, 用于比较两个版本的标签
+func joinTagIDs(tags []uint) string {
+	ids := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		ids = append(ids, strconv.FormatUint(uint64(tag), 10))
+	}
+
+	return strings.Join(ids, ",")
+}
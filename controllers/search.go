@@ -0,0 +1,74 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"github.com/ztplz/blog-server/models"
+)
+
+// SearchHandler 搜索博文, q(关键词) limit(每次返回数) page(页数) category(分类id) tag(标签id)
+func SearchHandler(c *gin.Context) {
+	query := strings.TrimSpace(c.Query("q"))
+	if query == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"statusCode": http.StatusBadRequest,
+			"message":    "Miss search keyword",
+		})
+		c.AbortWithStatus(http.StatusBadRequest)
+		log.WithFields(log.Fields{
+			"errorMsg":   "Miss search keyword",
+			"statusCode": http.StatusBadRequest,
+		}).Info("Search articles failed")
+
+		return
+	}
+
+	limit, lerr := strconv.ParseInt(c.DefaultQuery("limit", "10"), 10, 32)
+	page, perr := strconv.ParseInt(c.DefaultQuery("page", "1"), 10, 32)
+	category, cerr := strconv.ParseUint(c.DefaultQuery("category", "0"), 10, 0)
+	tag, terr := strconv.ParseUint(c.DefaultQuery("tag", "0"), 10, 0)
+	if lerr != nil || perr != nil || cerr != nil || terr != nil || limit <= 0 || page <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"statusCode": http.StatusBadRequest,
+			"message":    "Parameter not incorrect",
+		})
+		c.AbortWithStatus(http.StatusBadRequest)
+		log.WithFields(log.Fields{
+			"errorMsgLimit":    lerr,
+			"errorMsgPage":     perr,
+			"errorMsgCategory": cerr,
+			"errorMsgTag":      terr,
+			"statusCode":       http.StatusBadRequest,
+		}).Info("Search articles failed")
+
+		return
+	}
+
+	result := models.SearchArticles(models.SearchQuery{
+		Query:    query,
+		Category: uint(category),
+		Tag:      uint(tag),
+		Limit:    limit,
+		Page:     page,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"statusCode": http.StatusOK,
+		"total":      result.Total,
+		"results":    result.Hits,
+		"facets": gin.H{
+			"categories": result.Categories,
+			"tags":       result.Tags,
+		},
+	})
+
+	log.WithFields(log.Fields{
+		"query":      query,
+		"total":      result.Total,
+		"statusCode": http.StatusOK,
+	}).Info("Search articles success")
+}
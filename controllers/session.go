@@ -0,0 +1,266 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	log "github.com/sirupsen/logrus"
+	"github.com/ztplz/blog-server/middlewares"
+	"github.com/ztplz/blog-server/token"
+)
+
+// 设备名最大长度
+const deviceLengthMax = 64
+
+// RefreshTokenForm 刷新 token 表单
+type RefreshTokenForm struct {
+	RefreshToken string `form:"refresh_token" json:"refresh_token" binding:"required"`
+}
+
+// RefreshTokenHandler 用 refresh token 换新的 access token 和 refresh token
+func RefreshTokenHandler(c *gin.Context) {
+	var refreshVals RefreshTokenForm
+
+	err := c.ShouldBindWith(&refreshVals, binding.JSON)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"statusCode": http.StatusBadRequest,
+			"message":    "Miss refresh token",
+		})
+		c.AbortWithStatus(http.StatusBadRequest)
+		log.WithFields(log.Fields{
+			"errorMsg":   err,
+			"statusCode": http.StatusBadRequest,
+		}).Info("Refresh token failed")
+
+		return
+	}
+
+	pair, err := token.Refresh(refreshVals.RefreshToken, clientInfo(c, ""))
+	if err == token.ErrRefreshInvalid || err == token.ErrRefreshReused {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"statusCode": http.StatusUnauthorized,
+			"message":    err.Error(),
+		})
+		c.AbortWithStatus(http.StatusUnauthorized)
+		log.WithFields(log.Fields{
+			"errorMsg":   err,
+			"ip":         c.ClientIP(),
+			"statusCode": http.StatusUnauthorized,
+		}).Info("Refresh token failed")
+
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"statusCode": http.StatusInternalServerError,
+			"message":    http.StatusText(http.StatusInternalServerError),
+		})
+		c.AbortWithStatus(http.StatusInternalServerError)
+		log.WithFields(log.Fields{
+			"errorMsg":   err,
+			"statusCode": http.StatusInternalServerError,
+		}).Info("Refresh token failed")
+
+		return
+	}
+	middlewares.AuditTarget(c, "session", pair.Claims.SessionID)
+	middlewares.AuditAfter(c, gin.H{
+		"role":    pair.Claims.Role,
+		"subject": pair.Claims.Subject,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"statusCode":     http.StatusOK,
+		"message":        "success",
+		"token":          pair.AccessToken,
+		"max_expire":     pair.Claims.ExpireAt().Format(time.RFC3339),
+		"refresh_token":  pair.RefreshToken,
+		"refresh_expire": pair.RefreshExpireAt.Format(time.RFC3339),
+	})
+}
+
+// JWKSHandler 返回验证 token 的公钥, 格式为标准的 JWK Set, 其他服务用来验证 token
+func JWKSHandler(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, gin.H{
+		"keys": token.JWKS(),
+	})
+}
+
+// GetAdminSessionsHandler 管理员查看自己所有登录的设备
+func GetAdminSessionsHandler(c *gin.Context) {
+	listSessions(c, token.RoleAdmin, adminSubject(c))
+}
+
+// RevokeAdminSessionHandler 管理员注销某个设备的登录
+func RevokeAdminSessionHandler(c *gin.Context) {
+	revokeSession(c, token.RoleAdmin, adminSubject(c))
+}
+
+// RevokeAdminSessionsHandler 管理员注销所有设备的登录, except_current=true 时保留当前设备
+func RevokeAdminSessionsHandler(c *gin.Context) {
+	revokeSessions(c, token.RoleAdmin, adminSubject(c))
+}
+
+// GetUserSessionsHandler 用户查看自己所有登录的设备
+func GetUserSessionsHandler(c *gin.Context) {
+	listSessions(c, token.RoleUser, c.Param("userID"))
+}
+
+// RevokeUserSessionHandler 用户注销某个设备的登录
+func RevokeUserSessionHandler(c *gin.Context) {
+	revokeSession(c, token.RoleUser, c.Param("userID"))
+}
+
+// RevokeUserSessionsHandler 用户注销所有设备的登录, except_current=true 时保留当前设备
+func RevokeUserSessionsHandler(c *gin.Context) {
+	revokeSessions(c, token.RoleUser, c.Param("userID"))
+}
+
+func listSessions(c *gin.Context, role string, subject string) {
+	sessions, err := token.Sessions(role, subject)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"statusCode": http.StatusInternalServerError,
+			"message":    http.StatusText(http.StatusInternalServerError),
+		})
+		c.AbortWithStatus(http.StatusInternalServerError)
+		log.WithFields(log.Fields{
+			"errorMsg":   err,
+			"role":       role,
+			"subject":    subject,
+			"statusCode": http.StatusInternalServerError,
+		}).Info("Get sessions failed")
+
+		return
+	}
+
+	current := middlewares.ExtractClaims(c).SessionID
+	for _, session := range sessions {
+		session.Current = session.ID == current
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"statusCode": http.StatusOK,
+		"sessions":   sessions,
+	})
+}
+
+func revokeSession(c *gin.Context, role string, subject string) {
+	sid := c.Param("sid")
+
+	err := token.RevokeSession(role, subject, sid)
+	if err == token.ErrSessionMissing {
+		c.JSON(http.StatusNotFound, gin.H{
+			"statusCode": http.StatusNotFound,
+			"message":    err.Error(),
+		})
+		c.AbortWithStatus(http.StatusNotFound)
+		log.WithFields(log.Fields{
+			"role":       role,
+			"subject":    subject,
+			"sid":        sid,
+			"statusCode": http.StatusNotFound,
+		}).Info("Revoke session failed")
+
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"statusCode": http.StatusInternalServerError,
+			"message":    http.StatusText(http.StatusInternalServerError),
+		})
+		c.AbortWithStatus(http.StatusInternalServerError)
+		log.WithFields(log.Fields{
+			"errorMsg":   err,
+			"statusCode": http.StatusInternalServerError,
+		}).Info("Revoke session failed")
+
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"statusCode": http.StatusOK,
+		"message":    "Revoke session success",
+	})
+
+	log.WithFields(log.Fields{
+		"role":       role,
+		"subject":    subject,
+		"sid":        sid,
+		"statusCode": http.StatusOK,
+	}).Info("Revoke session success")
+}
+
+func revokeSessions(c *gin.Context, role string, subject string) {
+	exceptCurrent, _ := strconv.ParseBool(c.Query("except_current"))
+
+	var err error
+	if exceptCurrent {
+		var sessions []*token.Session
+		sessions, err = token.Sessions(role, subject)
+		current := middlewares.ExtractClaims(c).SessionID
+		for i := 0; err == nil && i < len(sessions); i++ {
+			if sessions[i].ID != current {
+				err = token.RevokeSession(role, subject, sessions[i].ID)
+			}
+		}
+	} else {
+		err = token.Revoke(role, subject)
+	}
+
+	if err != nil && err != token.ErrSessionMissing {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"statusCode": http.StatusInternalServerError,
+			"message":    http.StatusText(http.StatusInternalServerError),
+		})
+		c.AbortWithStatus(http.StatusInternalServerError)
+		log.WithFields(log.Fields{
+			"errorMsg":   err,
+			"statusCode": http.StatusInternalServerError,
+		}).Info("Revoke sessions failed")
+
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"statusCode": http.StatusOK,
+		"message":    "Revoke sessions success",
+	})
+
+	log.WithFields(log.Fields{
+		"role":          role,
+		"subject":       subject,
+		"exceptCurrent": exceptCurrent,
+		"statusCode":    http.StatusOK,
+	}).Info("Revoke sessions success")
+}
+
+// 当前管理员的 token subject
+func adminSubject(c *gin.Context) string {
+	return strconv.FormatUint(uint64(middlewares.CurrentAdmin(c).ID), 10)
+}
+
+// 登录设备的信息, 没有填写设备名时用 User-Agent 代替
+func clientInfo(c *gin.Context, device string) *token.Client {
+	userAgent := c.Request.UserAgent()
+
+	device = strings.TrimSpace(device)
+	if device == "" {
+		device = userAgent
+	}
+	if len(device) > deviceLengthMax {
+		device = device[:deviceLengthMax]
+	}
+
+	return &token.Client{
+		Device:    device,
+		IP:        c.ClientIP(),
+		UserAgent: userAgent,
+	}
+}
@@ -0,0 +1,347 @@
+/*
+* sitemap 和 robots.txt
+*
+* sitemap 包括首页、已发布的博文、分类和标签页面，超过 50000 个地址时拆分成多个 sitemap
+* 并返回 sitemap 索引。生成的 xml 缓存在 redis 里，博文、分类或标签改变时清除
+ */
+
+package controllers
+
+import (
+	"database/sql"
+	"encoding/xml"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-redis/redis"
+	log "github.com/sirupsen/logrus"
+	"github.com/ztplz/blog-server/middlewares"
+	"github.com/ztplz/blog-server/models"
+)
+
+const (
+	// 每个 sitemap 最多的地址数
+	sitemapURLMax = 50000
+
+	// redis 里缓存 sitemap 的 hash, field 为 sitemap 的页码, sitemap 或 sitemap 索引为 0
+	sitemapKey = "sitemap"
+
+	sitemapXmlns = "http://www.sitemaps.org/schemas/sitemap/0.9"
+
+	// 默认的 robots.txt 规则
+	defaultRobots = "User-agent: *\nAllow: /\n"
+)
+
+// RobotsForm robots.txt 表单
+type RobotsForm struct {
+	Robots string `form:"robots" json:"robots"`
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+
+	// 用于计算 sitemap 索引的 lastmod
+	updated time.Time
+}
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapIndex struct {
+	XMLName  xml.Name     `xml:"sitemapindex"`
+	Xmlns    string       `xml:"xmlns,attr"`
+	Sitemaps []sitemapURL `xml:"sitemap"`
+}
+
+// SitemapHandler 返回 sitemap, 地址超过 50000 个时返回 sitemap 索引
+func SitemapHandler(c *gin.Context) {
+	serveSitemap(c, 0)
+}
+
+// SitemapPageHandler 返回 sitemap 索引里的第 page 个 sitemap
+func SitemapPageHandler(c *gin.Context) {
+	page, err := strconv.Atoi(strings.TrimSuffix(c.Param("page"), ".xml"))
+	if err != nil || page <= 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"statusCode": http.StatusNotFound,
+			"message":    http.StatusText(http.StatusNotFound),
+		})
+		c.AbortWithStatus(http.StatusNotFound)
+
+		return
+	}
+
+	serveSitemap(c, page)
+}
+
+// RobotsHandler 返回 robots.txt, 最后加上 sitemap 的地址
+func RobotsHandler(c *gin.Context) {
+	robots, err := models.GetOption(models.OptionRobots, defaultRobots)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+		}).Info("Get robots failed")
+
+		robots = defaultRobots
+	}
+
+	if !strings.HasSuffix(robots, "\n") {
+		robots += "\n"
+	}
+	robots += "\nSitemap: " + SiteURL + "/sitemap.xml\n"
+
+	c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(robots))
+}
+
+// GetRobotsHandler 管理员获取 robots.txt 规则
+func GetRobotsHandler(c *gin.Context) {
+	robots, err := models.GetOption(models.OptionRobots, defaultRobots)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"statusCode": http.StatusInternalServerError,
+			"message":    http.StatusText(http.StatusInternalServerError),
+		})
+		c.AbortWithStatus(http.StatusInternalServerError)
+		log.WithFields(log.Fields{
+			"errorMsg":   err,
+			"statusCode": http.StatusInternalServerError,
+		}).Info("Get robots failed")
+
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"statusCode": http.StatusOK,
+		"robots":     robots,
+	})
+}
+
+// UpdateRobotsHandler 管理员修改 robots.txt 规则, Sitemap 行会自动加上
+func UpdateRobotsHandler(c *gin.Context) {
+	var robotsVals RobotsForm
+
+	middlewares.AuditTarget(c, "option", models.OptionRobots)
+	if robots, err := models.GetOption(models.OptionRobots, defaultRobots); err == nil {
+		middlewares.AuditBefore(c, robots)
+	}
+
+	err := c.ShouldBindWith(&robotsVals, binding.JSON)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"statusCode": http.StatusBadRequest,
+			"message":    "Robots form incorrect",
+		})
+		c.AbortWithStatus(http.StatusBadRequest)
+		log.WithFields(log.Fields{
+			"errorMsg":   err,
+			"statusCode": http.StatusBadRequest,
+		}).Info("Update robots failed")
+
+		return
+	}
+
+	err = models.SetOption(models.OptionRobots, robotsVals.Robots)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"statusCode": http.StatusInternalServerError,
+			"message":    http.StatusText(http.StatusInternalServerError),
+		})
+		c.AbortWithStatus(http.StatusInternalServerError)
+		log.WithFields(log.Fields{
+			"errorMsg":   err,
+			"statusCode": http.StatusInternalServerError,
+		}).Info("Update robots failed")
+
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"statusCode": http.StatusOK,
+		"message":    "Update robots success",
+	})
+
+	log.WithFields(log.Fields{
+		"statusCode": http.StatusOK,
+	}).Info("Update robots success")
+}
+
+// 先从 redis 读取 sitemap, 没有缓存时生成并写入 redis, page 为 0 时返回 sitemap 或 sitemap 索引
+func serveSitemap(c *gin.Context, page int) {
+	field := strconv.Itoa(page)
+
+	body, err := models.RedisClient.HGet(sitemapKey, field).Bytes()
+	if err != nil {
+		if err != redis.Nil {
+			log.WithFields(log.Fields{
+				"errorMsg": err,
+			}).Info("Get sitemap from redis failed")
+		}
+
+		body, err = buildSitemap(SiteURL, page)
+		if err != nil {
+			statusCode := http.StatusInternalServerError
+			if err == sql.ErrNoRows {
+				statusCode = http.StatusNotFound
+			}
+
+			c.JSON(statusCode, gin.H{
+				"statusCode": statusCode,
+				"message":    http.StatusText(statusCode),
+			})
+			c.AbortWithStatus(statusCode)
+			log.WithFields(log.Fields{
+				"errorMsg":   err,
+				"page":       page,
+				"statusCode": statusCode,
+			}).Info("Build sitemap failed")
+
+			return
+		}
+
+		err = models.RedisClient.HSet(sitemapKey, field, body).Err()
+		if err != nil {
+			log.WithFields(log.Fields{
+				"errorMsg": err,
+			}).Info("Store sitemap to redis failed")
+		}
+	}
+
+	writeConditional(c, body, "application/xml; charset=utf-8", time.Time{})
+}
+
+// 生成 sitemap, page 超出范围时返回 sql.ErrNoRows
+func buildSitemap(base string, page int) ([]byte, error) {
+	urls, err := sitemapURLs(base)
+	if err != nil {
+		return nil, err
+	}
+
+	pages := (len(urls) + sitemapURLMax - 1) / sitemapURLMax
+
+	var doc interface{}
+	switch {
+	case page == 0 && pages <= 1:
+		doc = sitemapURLSet{Xmlns: sitemapXmlns, URLs: urls}
+	case page == 0:
+		index := sitemapIndex{Xmlns: sitemapXmlns}
+		for i := 1; i <= pages; i++ {
+			sitemap := sitemapURL{Loc: base + "/sitemap/" + strconv.Itoa(i) + ".xml"}
+
+			// 索引的 lastmod 为该 sitemap 里最后修改的时间
+			var updated time.Time
+			for _, url := range sitemapPage(urls, i) {
+				if url.updated.After(updated) {
+					updated = url.updated
+				}
+			}
+			if !updated.IsZero() {
+				sitemap.LastMod = updated.Format(time.RFC3339)
+			}
+
+			index.Sitemaps = append(index.Sitemaps, sitemap)
+		}
+		doc = index
+	case pages > 1 && page <= pages:
+		doc = sitemapURLSet{Xmlns: sitemapXmlns, URLs: sitemapPage(urls, page)}
+	default:
+		return nil, sql.ErrNoRows
+	}
+
+	body, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(xml.Header), body...), nil
+}
+
+// 第 page 个 sitemap 的地址, page 从 1 开始
+func sitemapPage(urls []sitemapURL, page int) []sitemapURL {
+	start := (page - 1) * sitemapURLMax
+	end := start + sitemapURLMax
+	if end > len(urls) {
+		end = len(urls)
+	}
+
+	return urls[start:end]
+}
+
+// 生成 sitemap 的全部地址, 分类和标签的 lastmod 为其中最后修改的博文的时间
+func sitemapURLs(base string) ([]sitemapURL, error) {
+	articles, err := models.GetAllArticle()
+	if err != nil {
+		return nil, err
+	}
+
+	categories, err := models.GetAllCategory()
+	if err != nil {
+		return nil, err
+	}
+
+	tags, err := models.GetAllTag()
+	if err != nil {
+		return nil, err
+	}
+
+	var latest time.Time
+	categoryUpdated := make(map[uint]time.Time)
+	tagUpdated := make(map[uint]time.Time)
+
+	urls := []sitemapURL{{Loc: base + "/"}}
+	for _, article := range *articles {
+		updated := parseDatetime(article.UpdateAt)
+		urls = append(urls, newSitemapURL(base+articlePagePath+article.Slug, updated))
+
+		if updated.After(latest) {
+			latest = updated
+		}
+		if updated.After(categoryUpdated[article.Category]) {
+			categoryUpdated[article.Category] = updated
+		}
+		for _, tag := range article.Tags {
+			if updated.After(tagUpdated[tag]) {
+				tagUpdated[tag] = updated
+			}
+		}
+	}
+	urls[0] = newSitemapURL(base+"/", latest)
+
+	for _, category := range categories {
+		id := strconv.FormatUint(uint64(category.ID), 10)
+		urls = append(urls, newSitemapURL(base+"/categories/"+id, categoryUpdated[category.ID]))
+	}
+
+	for _, tag := range *tags {
+		id := strconv.FormatUint(uint64(tag.ID), 10)
+		urls = append(urls, newSitemapURL(base+"/tags/"+id, tagUpdated[tag.ID]))
+	}
+
+	return urls, nil
+}
+
+func newSitemapURL(loc string, updated time.Time) sitemapURL {
+	url := sitemapURL{Loc: loc, updated: updated}
+	if !updated.IsZero() {
+		url.LastMod = updated.Format(time.RFC3339)
+	}
+
+	return url
+}
+
+// 博文、分类或标签改变后清除 redis 里缓存的 sitemap
+func invalidateSitemap() {
+	err := models.RedisClient.Del(sitemapKey).Err()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+		}).Info("Delete sitemap from redis failed")
+	}
+}
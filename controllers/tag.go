@@ -89,7 +89,7 @@ func GetAllTagHandler(c *gin.Context) {
 				continue
 			}
 
-			err = models.RedisClient.HSet("tags", string(tag.ID), mt).Err()
+			err = models.RedisClient.HSet("tags", strconv.FormatUint(uint64(tag.ID), 10), mt).Err()
 			if err != nil {
 				log.WithFields(log.Fields{
 					"errorMsg": err,
@@ -142,14 +142,8 @@ func GetAllTagHandler(c *gin.Context) {
 func AddTagHandler(c *gin.Context) {
 	var tagVals TagForm
 
-	// 管理员鉴权
-	_, err := middlewares.AdminAuthMiddleware(c)
-	if err != nil {
-		return
-	}
-
 	// 检测提交的表单
-	err = c.ShouldBindWith(&tagVals, binding.JSON)
+	err := c.ShouldBindWith(&tagVals, binding.JSON)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"statusCode": http.StatusBadRequest,
@@ -244,6 +238,8 @@ func AddTagHandler(c *gin.Context) {
 
 		return
 	}
+	middlewares.AuditTarget(c, "tag", strconv.FormatInt(lastID, 10))
+	middlewares.AuditAfter(c, models.Tag{ID: uint(lastID), Color: tagVals.Color, TagTitle: tagVals.TagTitle})
 
 	c.JSON(200, gin.H{
 		"statusCode": http.StatusOK,
@@ -255,6 +251,9 @@ func AddTagHandler(c *gin.Context) {
 		"statusCode": http.StatusOK,
 	}).Info("Add tag to mysql success")
 
+	// sitemap 里包含标签页面
+	invalidateSitemap()
+
 	// 同步更新到 redis 里
 	tag, err := json.Marshal(models.Tag{ID: uint(lastID), Color: tagVals.Color, TagTitle: tagVals.TagTitle})
 	if err != nil {
@@ -263,7 +262,7 @@ func AddTagHandler(c *gin.Context) {
 		}).Info("Add tag to redis failed")
 	}
 
-	err = models.RedisClient.HSet("tags", string(lastID), tag).Err()
+	err = models.RedisClient.HSet("tags", strconv.FormatInt(lastID, 10), tag).Err()
 	if err != nil {
 		log.WithFields(log.Fields{
 			"id":  lastID,
@@ -280,12 +279,6 @@ func AddTagHandler(c *gin.Context) {
 
 // UpdateTagHandler 修改标签
 func UpdateTagHandler(c *gin.Context) {
-	// 管理员鉴权
-	_, err := middlewares.AdminAuthMiddleware(c)
-	if err != nil {
-		return
-	}
-
 	// 获取参数
 	id := c.Param("id")
 	color := c.Query("color")
@@ -355,6 +348,15 @@ func UpdateTagHandler(c *gin.Context) {
 		return
 	}
 
+	// 审计日志记录修改前的标签
+	if oldTags, err := models.GetAllTag(); err == nil {
+		for _, oldTag := range *oldTags {
+			if oldTag.ID == uint(uid) {
+				middlewares.AuditBefore(c, oldTag)
+			}
+		}
+	}
+
 	// 数据库更新tag
 	err = models.UpdateTag(uint(uid), color, tagTitle)
 	if err != nil {
@@ -370,12 +372,17 @@ func UpdateTagHandler(c *gin.Context) {
 
 		return
 	}
+	middlewares.AuditAfter(c, models.Tag{ID: uint(uid), Color: color, TagTitle: tagTitle})
 
 	c.JSON(http.StatusBadRequest, gin.H{
 		"statusCode": http.StatusOK,
 		"message":    "标签名修改成功",
 	})
 
+	// 搜索索引里包含标签名
+	rebuildSearchIndex()
+	invalidateSitemap()
+
 	// 同步更新到 redis 里
 	tag, err := json.Marshal(models.Tag{ID: uint(uid), Color: color, TagTitle: tagTitle})
 	if err != nil {
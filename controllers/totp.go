@@ -0,0 +1,416 @@
+/*
+* 管理员两步验证
+*
+* 启用流程: POST /admin/totp 生成密钥和 otpauth 链接, 管理员添加到验证器应用后,
+* POST /admin/totp/confirm 提交一次验证码完成启用, 同时返回恢复码。
+* 启用后登录需要 totp_code, 也可以填恢复码。修改密码等敏感操作需要在请求头
+* X-TOTP-Code 里带上验证码, 或者先调用 POST /admin/totp/verify, 之后一段时间内不用再输入
+ */
+
+package controllers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	log "github.com/sirupsen/logrus"
+	"github.com/ztplz/blog-server/middlewares"
+	"github.com/ztplz/blog-server/models"
+	"github.com/ztplz/blog-server/totp"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// otpauth 链接里显示的名字
+	totpIssuer = "blog"
+
+	// 生成密钥后需要在这个时间内确认
+	totpPendingTTL = 10 * time.Minute
+
+	// 允许前后各一个周期的时间误差
+	totpSkew = 1
+
+	// 通过两步验证后可以进行敏感操作的时间
+	stepUpTTL = 5 * time.Minute
+
+	// 敏感操作时带验证码的请求头
+	stepUpHeader = "X-TOTP-Code"
+)
+
+// TOTPCodeForm 验证码表单, code 可以是验证码或者恢复码
+type TOTPCodeForm struct {
+	Code string `form:"code" json:"code" binding:"required"`
+}
+
+// TOTPDisableForm 关闭两步验证表单
+type TOTPDisableForm struct {
+	Password string `form:"password" json:"password" binding:"required"`
+}
+
+// GetAdminTOTPHandler 获取两步验证状态
+func GetAdminTOTPHandler(c *gin.Context) {
+	admin := middlewares.CurrentAdmin(c)
+
+	var count int64
+	var err error
+	if admin.TOTPEnabled {
+		count, err = models.CountRecoveryCodes(admin.ID)
+		if err != nil {
+			abortTOTPError(c, err, "Get admin totp failed")
+
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"statusCode":          http.StatusOK,
+		"enabled":             admin.TOTPEnabled,
+		"recovery_codes_left": count,
+	})
+}
+
+// EnrollAdminTOTPHandler 生成新的密钥, 需要确认后才会启用
+func EnrollAdminTOTPHandler(c *gin.Context) {
+	admin := middlewares.CurrentAdmin(c)
+
+	if admin.TOTPEnabled {
+		c.JSON(http.StatusConflict, gin.H{
+			"statusCode": http.StatusConflict,
+			"message":    "Two-factor authentication is already enabled",
+		})
+		c.AbortWithStatus(http.StatusConflict)
+		log.WithFields(log.Fields{
+			"statusCode": http.StatusConflict,
+		}).Info("Enroll admin totp failed")
+
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err == nil {
+		err = models.SetAdminPendingTOTP(admin.ID, secret, totpPendingTTL)
+	}
+	if err != nil {
+		abortTOTPError(c, err, "Enroll admin totp failed")
+
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"statusCode": http.StatusOK,
+		"secret":     secret,
+		"uri":        totp.URI(totpIssuer, admin.AdminID, secret),
+		"expire_at":  time.Now().Add(totpPendingTTL).Format(time.RFC3339),
+	})
+
+	log.WithFields(log.Fields{
+		"adminID":    admin.AdminID,
+		"statusCode": http.StatusOK,
+	}).Info("Enroll admin totp success")
+}
+
+// ConfirmAdminTOTPHandler 用验证码确认密钥并启用两步验证, 返回恢复码
+func ConfirmAdminTOTPHandler(c *gin.Context) {
+	var codeVals TOTPCodeForm
+
+	admin := middlewares.CurrentAdmin(c)
+
+	err := c.ShouldBindWith(&codeVals, binding.JSON)
+	if err != nil {
+		abortTOTPCode(c, http.StatusBadRequest, "Miss code", "Confirm admin totp failed")
+
+		return
+	}
+
+	secret, err := models.GetAdminPendingTOTP(admin.ID)
+	if err != nil {
+		abortTOTPError(c, err, "Confirm admin totp failed")
+
+		return
+	}
+	if secret == "" {
+		abortTOTPCode(c, http.StatusBadRequest, "Enrollment expired, please enroll again", "Confirm admin totp failed")
+
+		return
+	}
+
+	_, ok := totp.Validate(secret, codeVals.Code, time.Now(), totpSkew)
+	if !ok {
+		abortTOTPCode(c, http.StatusBadRequest, "Incorrect code", "Confirm admin totp failed")
+
+		return
+	}
+
+	err = models.EnableAdminTOTP(admin.ID, secret)
+	if err != nil {
+		abortTOTPError(c, err, "Confirm admin totp failed")
+
+		return
+	}
+
+	codes, err := models.NewRecoveryCodes(admin.ID)
+	if err != nil {
+		abortTOTPError(c, err, "Confirm admin totp failed")
+
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"statusCode":     http.StatusOK,
+		"message":        "Two-factor authentication enabled",
+		"recovery_codes": codes,
+	})
+
+	log.WithFields(log.Fields{
+		"adminID":    admin.AdminID,
+		"statusCode": http.StatusOK,
+	}).Info("Enable admin totp success")
+}
+
+// DisableAdminTOTPHandler 关闭两步验证, 需要密码和两步验证
+func DisableAdminTOTPHandler(c *gin.Context) {
+	var disableVals TOTPDisableForm
+
+	admin := middlewares.CurrentAdmin(c)
+
+	err := c.ShouldBindWith(&disableVals, binding.JSON)
+	if err != nil {
+		abortTOTPCode(c, http.StatusBadRequest, "Miss password", "Disable admin totp failed")
+
+		return
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(admin.Password), []byte(disableVals.Password))
+	if err != nil {
+		abortTOTPCode(c, http.StatusBadRequest, "Incorrect admin password", "Disable admin totp failed")
+
+		return
+	}
+
+	if !checkAdminStepUp(c, admin, "Disable admin totp failed") {
+		return
+	}
+
+	err = models.DisableAdminTOTP(admin.ID)
+	if err != nil {
+		abortTOTPError(c, err, "Disable admin totp failed")
+
+		return
+	}
+	models.ClearAdminStepUp(admin.ID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"statusCode": http.StatusOK,
+		"message":    "Two-factor authentication disabled",
+	})
+
+	log.WithFields(log.Fields{
+		"adminID":    admin.AdminID,
+		"statusCode": http.StatusOK,
+	}).Info("Disable admin totp success")
+}
+
+// RegenerateRecoveryCodesHandler 重新生成恢复码, 之前的恢复码全部失效
+func RegenerateRecoveryCodesHandler(c *gin.Context) {
+	admin := middlewares.CurrentAdmin(c)
+
+	if !admin.TOTPEnabled {
+		abortTOTPCode(c, http.StatusBadRequest, "Two-factor authentication is not enabled", "Regenerate recovery codes failed")
+
+		return
+	}
+
+	if !checkAdminStepUp(c, admin, "Regenerate recovery codes failed") {
+		return
+	}
+
+	codes, err := models.NewRecoveryCodes(admin.ID)
+	if err != nil {
+		abortTOTPError(c, err, "Regenerate recovery codes failed")
+
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"statusCode":     http.StatusOK,
+		"recovery_codes": codes,
+	})
+
+	log.WithFields(log.Fields{
+		"adminID":    admin.AdminID,
+		"statusCode": http.StatusOK,
+	}).Info("Regenerate recovery codes success")
+}
+
+// AdminStepUpHandler 提交验证码, 之后 stepUpTTL 内进行敏感操作不用再输入
+func AdminStepUpHandler(c *gin.Context) {
+	var codeVals TOTPCodeForm
+
+	admin := middlewares.CurrentAdmin(c)
+
+	err := c.ShouldBindWith(&codeVals, binding.JSON)
+	if err != nil {
+		abortTOTPCode(c, http.StatusBadRequest, "Miss code", "Admin step up failed")
+
+		return
+	}
+
+	if !admin.TOTPEnabled {
+		abortTOTPCode(c, http.StatusBadRequest, "Two-factor authentication is not enabled", "Admin step up failed")
+
+		return
+	}
+
+	// 错误的验证码和登录一样计入失败次数, 防止拿到 token 后暴力猜验证码
+	if !checkLoginLock(c, models.LoginGuardAdmin, admin.AdminID, "Admin step up failed") {
+		return
+	}
+
+	ok, err := verifyAdminCode(admin, codeVals.Code)
+	if err == nil && ok {
+		err = models.SetAdminStepUp(admin.ID, stepUpTTL)
+	}
+	if err != nil {
+		abortTOTPError(c, err, "Admin step up failed")
+
+		return
+	}
+	if !ok {
+		recordLoginFailure(c, models.LoginGuardAdmin, admin.AdminID)
+		abortTOTPCode(c, http.StatusUnauthorized, "Incorrect code", "Admin step up failed")
+
+		return
+	}
+
+	clearLoginFailures(models.LoginGuardAdmin, admin.AdminID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"statusCode": http.StatusOK,
+		"expire_at":  time.Now().Add(stepUpTTL).Format(time.RFC3339),
+	})
+}
+
+// 登录时的两步验证, 没有启用时直接通过
+func checkAdminSecondFactor(c *gin.Context, admin *models.Admin, code string, logMsg string) bool {
+	if !admin.TOTPEnabled {
+		return true
+	}
+
+	if code == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"statusCode":    http.StatusUnauthorized,
+			"message":       "Two-factor code required",
+			"totp_required": true,
+		})
+		c.AbortWithStatus(http.StatusUnauthorized)
+		log.WithFields(log.Fields{
+			"ip":         c.ClientIP(),
+			"statusCode": http.StatusUnauthorized,
+		}).Info(logMsg)
+
+		return false
+	}
+
+	ok, err := verifyAdminCode(admin, code)
+	if err != nil {
+		abortTOTPError(c, err, logMsg)
+
+		return false
+	}
+	if !ok {
+		recordLoginFailure(c, models.LoginGuardAdmin, admin.AdminID)
+		abortTOTPCode(c, http.StatusUnauthorized, "Incorrect two-factor code", logMsg)
+
+		return false
+	}
+
+	return true
+}
+
+// 敏感操作前检查两步验证: 请求头里的验证码, 或者最近通过了 step up。没有启用时直接通过
+func checkAdminStepUp(c *gin.Context, admin *models.Admin, logMsg string) bool {
+	if !admin.TOTPEnabled {
+		return true
+	}
+
+	var ok bool
+	var err error
+	code := c.GetHeader(stepUpHeader)
+	if code != "" {
+		// 请求头里的验证码和 step up 一样计入失败次数
+		if !checkLoginLock(c, models.LoginGuardAdmin, admin.AdminID, logMsg) {
+			return false
+		}
+
+		ok, err = verifyAdminCode(admin, code)
+	} else {
+		ok, err = models.CheckAdminStepUp(admin.ID)
+	}
+	if err != nil {
+		abortTOTPError(c, err, logMsg)
+
+		return false
+	}
+
+	if code != "" {
+		if ok {
+			clearLoginFailures(models.LoginGuardAdmin, admin.AdminID)
+		} else {
+			recordLoginFailure(c, models.LoginGuardAdmin, admin.AdminID)
+		}
+	}
+
+	if !ok {
+		c.JSON(http.StatusForbidden, gin.H{
+			"statusCode":       http.StatusForbidden,
+			"message":          "Two-factor verification required",
+			"step_up_required": true,
+		})
+		c.AbortWithStatus(http.StatusForbidden)
+		log.WithFields(log.Fields{
+			"adminID":    admin.AdminID,
+			"statusCode": http.StatusForbidden,
+		}).Info(logMsg)
+
+		return false
+	}
+
+	return true
+}
+
+// 验证 TOTP 验证码或者恢复码, 同一个验证码不能使用两次
+func verifyAdminCode(admin *models.Admin, code string) (bool, error) {
+	step, ok := totp.Validate(admin.TOTPSecret, code, time.Now(), totpSkew)
+	if ok {
+		return models.MarkAdminTOTPStep(admin.ID, step, totp.Period*time.Duration(2*totpSkew+2))
+	}
+
+	return models.UseRecoveryCode(admin.ID, code)
+}
+
+func abortTOTPCode(c *gin.Context, statusCode int, message string, logMsg string) {
+	c.JSON(statusCode, gin.H{
+		"statusCode": statusCode,
+		"message":    message,
+	})
+	c.AbortWithStatus(statusCode)
+	log.WithFields(log.Fields{
+		"errorMsg":   message,
+		"ip":         c.ClientIP(),
+		"statusCode": statusCode,
+	}).Info(logMsg)
+}
+
+func abortTOTPError(c *gin.Context, err error, logMsg string) {
+	c.JSON(http.StatusInternalServerError, gin.H{
+		"statusCode": http.StatusInternalServerError,
+		"message":    http.StatusText(http.StatusInternalServerError),
+	})
+	c.AbortWithStatus(http.StatusInternalServerError)
+	log.WithFields(log.Fields{
+		"errorMsg":   err,
+		"statusCode": http.StatusInternalServerError,
+	}).Info(logMsg)
+}
@@ -24,12 +24,6 @@ const expireTime int64 = 60
 
 const uploadDir string = "/"
 
-const (
-	base64Table = "123QRSTUabcdVWXYZHijKLAWDCABDstEFGuvwxyzGHIJklmnopqr234560178912"
-)
-
-var coder = base64.NewEncoding(base64Table)
-
 // ConfigStruct 配置
 type ConfigStruct struct {
 	Expiration string     `json:"expiration"`
@@ -57,10 +51,6 @@ func GetAccesskey(c *gin.Context) {
 	})
 }
 
-func base64Encode(src []byte) []byte {
-	return []byte(coder.EncodeToString(src))
-}
-
 func getGmtISO8601(expireEnd int64) string {
 	var tokenExpire = time.Unix(expireEnd, 0).Format("2006-01-02T15:04:05Z")
 
@@ -5,15 +5,17 @@ import (
 	"errors"
 	"net/http"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
-	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
 	"github.com/gin-gonic/gin/binding"
 	log "github.com/sirupsen/logrus"
+	"github.com/ztplz/blog-server/filter"
 	"github.com/ztplz/blog-server/middlewares"
 	"github.com/ztplz/blog-server/models"
+	"github.com/ztplz/blog-server/token"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -22,12 +24,14 @@ type UserRegisterForm struct {
 	UserID   string `form:"user_id" json:"user_id" binding:"required"`
 	UserName string `form:"user_name" json:"user_name" binding:"required"`
 	Password string `form:"password" json:"password" binding:"required"`
+	Email    string `form:"email" json:"email"`
 }
 
 // UserLoginForm  用户登录表单结构
 type UserLoginForm struct {
 	UserID   string `form:"user_id" json:"user_id" binding:"required"`
 	Password string `form:"password" json:"password" binding:"required"`
+	Device   string `form:"device" json:"device"`
 }
 
 // ChangePasswordForm 更改密码表单
@@ -35,15 +39,47 @@ type ChangePasswordForm struct {
 	Password string `form:"password" json:"password" binding:"required"`
 }
 
-// GetAllUser 查询所有用户信息
+// UserRoleForm 修改用户角色表单, role 为空时取消角色
+type UserRoleForm struct {
+	Role string `form:"role" json:"role"`
+}
+
+// BanUserForm 封禁用户表单, expire_at 格式为 2006-01-02 15:04:05 或 RFC3339, 为空时永久封禁
+type BanUserForm struct {
+	Reason   string `form:"reason" json:"reason" binding:"required"`
+	ExpireAt string `form:"expire_at" json:"expire_at"`
+}
+
+// GetAllUser 管理员分页查询用户信息, q(匹配用户ID和用户名) banned(true|false) limit(每次返回数) page(页数)
 func GetAllUser(c *gin.Context) {
-	// 管理员鉴权
-	_, err := middlewares.AdminAuthMiddleware(c)
-	if err != nil {
+	filter := &models.UserFilter{Keyword: strings.TrimSpace(c.Query("q"))}
+
+	var berr error
+	if banned := c.Query("banned"); banned != "" {
+		var b bool
+		b, berr = strconv.ParseBool(banned)
+		filter.Banned = &b
+	}
+
+	limit, lerr := strconv.ParseInt(c.DefaultQuery("limit", "20"), 10, 32)
+	page, perr := strconv.ParseInt(c.DefaultQuery("page", "1"), 10, 32)
+	if berr != nil || lerr != nil || perr != nil || limit <= 0 || page <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"statusCode": http.StatusBadRequest,
+			"message":    "Parameter not incorrect",
+		})
+		c.AbortWithStatus(http.StatusBadRequest)
+		log.WithFields(log.Fields{
+			"errorMsgBanned": berr,
+			"errorMsgLimit":  lerr,
+			"errorMsgPage":   perr,
+			"statusCode":     http.StatusBadRequest,
+		}).Info("Get all user info failed")
+
 		return
 	}
 
-	users, err := models.GetAllUser()
+	users, total, err := models.GetUsers(filter, limit, page)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"statusCode": http.StatusInternalServerError,
@@ -59,9 +95,10 @@ func GetAllUser(c *gin.Context) {
 	}
 
 	// 查询成功
-	c.JSON(http.StatusInternalServerError, gin.H{
-		"statusCode": http.StatusInternalServerError,
+	c.JSON(http.StatusOK, gin.H{
+		"statusCode": http.StatusOK,
 		"message":    "success",
+		"total":      total,
 		"users":      *users,
 	})
 
@@ -71,6 +108,177 @@ func GetAllUser(c *gin.Context) {
 	}).Info("Get all user info success")
 }
 
+// BanUserHandler 管理员把用户加入黑名单, expire_at 为空时永久封禁, 同时强制用户退出登录
+func BanUserHandler(c *gin.Context) {
+	var banVals BanUserForm
+
+	user, ok := getManagedUser(c, "Ban user failed")
+	if !ok {
+		return
+	}
+	middlewares.AuditBefore(c, *user)
+
+	err := c.ShouldBindWith(&banVals, binding.JSON)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"statusCode": http.StatusBadRequest,
+			"message":    "Miss ban reason",
+		})
+		c.AbortWithStatus(http.StatusBadRequest)
+		log.WithFields(log.Fields{
+			"errorMsg":   err,
+			"statusCode": http.StatusBadRequest,
+		}).Info("Ban user failed")
+
+		return
+	}
+
+	// 封禁到期时间必须在现在之后
+	expireAt := ""
+	if banVals.ExpireAt != "" {
+		expire, err := parseBanExpire(banVals.ExpireAt)
+		if err != nil || !expire.After(time.Now()) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"statusCode": http.StatusBadRequest,
+				"message":    "Incorrect expire_at",
+			})
+			c.AbortWithStatus(http.StatusBadRequest)
+			log.WithFields(log.Fields{
+				"errorMsg":   err,
+				"expireAt":   banVals.ExpireAt,
+				"statusCode": http.StatusBadRequest,
+			}).Info("Ban user failed")
+
+			return
+		}
+
+		expireAt = expire.Format("2006-01-02 15:04:05")
+	}
+
+	err = models.BanUser(user.UserID, strings.TrimSpace(banVals.Reason), expireAt)
+	if err != nil {
+		abortUserError(c, err, "Ban user failed")
+
+		return
+	}
+	auditUserAfter(c, user.UserID)
+
+	revokeUserToken(user.UserID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"statusCode": http.StatusOK,
+		"message":    "Ban user success",
+	})
+
+	log.WithFields(log.Fields{
+		"userID":     user.UserID,
+		"reason":     banVals.Reason,
+		"expireAt":   expireAt,
+		"statusCode": http.StatusOK,
+	}).Info("Ban user success")
+}
+
+// UnbanUserHandler 管理员把用户移出黑名单
+func UnbanUserHandler(c *gin.Context) {
+	user, ok := getManagedUser(c, "Unban user failed")
+	if !ok {
+		return
+	}
+	middlewares.AuditBefore(c, *user)
+
+	err := models.UnbanUser(user.UserID)
+	if err != nil {
+		abortUserError(c, err, "Unban user failed")
+
+		return
+	}
+	auditUserAfter(c, user.UserID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"statusCode": http.StatusOK,
+		"message":    "Unban user success",
+	})
+
+	log.WithFields(log.Fields{
+		"userID":     user.UserID,
+		"statusCode": http.StatusOK,
+	}).Info("Unban user success")
+}
+
+// ForceLogoutUserHandler 管理员强制用户退出登录
+func ForceLogoutUserHandler(c *gin.Context) {
+	user, ok := getManagedUser(c, "Force logout user failed")
+	if !ok {
+		return
+	}
+
+	err := revokeUserToken(user.UserID)
+	if err != nil {
+		abortUserError(c, err, "Force logout user failed")
+
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"statusCode": http.StatusOK,
+		"message":    "Force logout user success",
+	})
+
+	log.WithFields(log.Fields{
+		"userID":     user.UserID,
+		"statusCode": http.StatusOK,
+	}).Info("Force logout user success")
+}
+
+// UpdateUserRoleHandler 管理员修改用户的角色, 角色决定用户可以使用的后台功能
+func UpdateUserRoleHandler(c *gin.Context) {
+	var roleVals UserRoleForm
+
+	user, ok := getManagedUser(c, "Update user role failed")
+	if !ok {
+		return
+	}
+	middlewares.AuditBefore(c, *user)
+
+	err := c.ShouldBindWith(&roleVals, binding.JSON)
+	if err != nil || !models.ValidUserRole(roleVals.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"statusCode": http.StatusBadRequest,
+			"message":    "Incorrect role",
+		})
+		c.AbortWithStatus(http.StatusBadRequest)
+		log.WithFields(log.Fields{
+			"errorMsg":   err,
+			"role":       roleVals.Role,
+			"statusCode": http.StatusBadRequest,
+		}).Info("Update user role failed")
+
+		return
+	}
+
+	err = models.UpdateUserRole(user.UserID, roleVals.Role)
+	if err != nil {
+		abortUserError(c, err, "Update user role failed")
+
+		return
+	}
+	auditUserAfter(c, user.UserID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"statusCode":  http.StatusOK,
+		"message":     "Update user role success",
+		"role":        roleVals.Role,
+		"permissions": models.RolePermissions[roleVals.Role],
+	})
+
+	log.WithFields(log.Fields{
+		"userID":     user.UserID,
+		"oldRole":    user.Role,
+		"role":       roleVals.Role,
+		"statusCode": http.StatusOK,
+	}).Info("Update user role success")
+}
+
 // GetUserByUserID 根据 UserID 获取用户信息
 func GetUserByUserID(c *gin.Context) {
 	userID := c.Param("userID")
@@ -89,12 +297,6 @@ func GetUserByUserID(c *gin.Context) {
 		return
 	}
 
-	// 鉴权
-	err := middlewares.UserAuthMiddleware(c, userID)
-	if err != nil {
-		return
-	}
-
 	user, err := models.GetUserByUserID(userID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -222,37 +424,49 @@ func RegisterUser(c *gin.Context) {
 		return
 	}
 
-	// 用户信息校验成功，生成token并把用户信息存入数据库，token放入redis
-	token := jwt.New(jwt.GetSigningMethod(SigningAlgorithm))
-	claims := token.Claims.(jwt.MapClaims)
+	// 检查用户 ID 和用户名是否包含敏感词, 以及同一个 IP 的注册次数
+	_, ok := filterContent(c, filter.RegisterFilter, filter.KindRegister, userID+"\n"+userName, "User register failed")
+	if !ok {
+		return
+	}
 
-	// 设置token过期时间
-	expire := time.Now().Add(Timeout)
-	claims["id"] = userID
-	claims["exp"] = expire.Unix()
-	claims["orig_iat"] = time.Now().Unix()
+	// 邮箱可以不填, 填了需要通过邮件验证
+	email := ""
+	if strings.TrimSpace(userVals.Email) != "" {
+		email, ok = checkUserEmail(c, "", userVals.Email)
+		if !ok {
+			return
+		}
+	}
 
-	// 生成token
-	tokenString, err := token.SignedString(secretKey)
+	// 存储进数据
+	user := &models.User{
+		ID:          0,
+		UserID:      userID,
+		Password:    password,
+		UserName:    userName,
+		Email:       email,
+		Image:       "",
+		CreateAt:    time.Now().Format("2006-01-02 15:04:05"),
+		LastLoginAt: time.Now().Format("2006-01-02 15:04:05"),
+		LoginCount:  1,
+		IsBlacklist: false,
+	}
 
-	// 生成token失败
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"statusCode": http.StatusInternalServerError,
-			"message":    http.StatusText(http.StatusInternalServerError),
+	err = models.UserRegister(user)
+	if err == models.ErrUserIDExist {
+		c.JSON(http.StatusConflict, gin.H{
+			"statusCode": http.StatusConflict,
+			"message":    "用户ID已经存在",
 		})
-		c.AbortWithStatus(http.StatusInternalServerError)
+		c.AbortWithStatus(http.StatusConflict)
 		log.WithFields(log.Fields{
-			"errorMsg":   "Generate token failed",
-			"statusCode": http.StatusInternalServerError,
+			"errorMsg":   err,
+			"statusCode": http.StatusConflict,
 		}).Info("User register failed")
 
 		return
 	}
-
-	// 把用户 token 放入 redis里, token不同步进数据库
-	key := userID + "_token"
-	err = models.RedisClient.Set(key, tokenString, time.Hour*24).Err()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"statusCode": http.StatusInternalServerError,
@@ -260,27 +474,15 @@ func RegisterUser(c *gin.Context) {
 		})
 		c.AbortWithStatus(http.StatusInternalServerError)
 		log.WithFields(log.Fields{
-			"errorMsg":   "Store token to redis failed",
+			"errorMsg":   "Store user info to databse failed",
 			"statusCode": http.StatusInternalServerError,
 		}).Info("User register failed")
 
 		return
 	}
 
-	// 存储进数据
-	user := &models.User{
-		ID:          0,
-		UserID:      userID,
-		Password:    password,
-		UserName:    userName,
-		Image:       "",
-		CreateAt:    time.Now().Format("2006-01-02 15:04:05"),
-		LastLoginAt: time.Now().Format("2006-01-02 15:04:05"),
-		LoginCount:  1,
-		IsBlacklist: false,
-	}
-
-	err = models.UserRegister(user)
+	// 注册成功后签发 token
+	pair, err := token.IssueSession(token.RoleUser, userID, clientInfo(c, ""))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"statusCode": http.StatusInternalServerError,
@@ -288,20 +490,26 @@ func RegisterUser(c *gin.Context) {
 		})
 		c.AbortWithStatus(http.StatusInternalServerError)
 		log.WithFields(log.Fields{
-			"errorMsg":   "Store user info to databse failed",
+			"errorMsg":   err,
 			"statusCode": http.StatusInternalServerError,
 		}).Info("User register failed")
 
 		return
 	}
 
+	if email != "" {
+		sendEmailVerifyMail(SiteURL, userID, email)
+	}
+
 	// 注册成功
 	c.JSON(http.StatusOK, gin.H{
-		"statusCode": http.StatusOK,
-		"message":    "注册成功",
-		"userID":     userID,
-		"token":      tokenString,
-		"max_expire": expire.Format(time.RFC3339),
+		"statusCode":     http.StatusOK,
+		"message":        "注册成功",
+		"userID":         userID,
+		"token":          pair.AccessToken,
+		"max_expire":     pair.Claims.ExpireAt().Format(time.RFC3339),
+		"refresh_token":  pair.RefreshToken,
+		"refresh_expire": pair.RefreshExpireAt.Format(time.RFC3339),
 	})
 
 	log.WithFields(log.Fields{
@@ -314,7 +522,7 @@ func RegisterUser(c *gin.Context) {
 // UpdateUserID 修改用户ID
 func UpdateUserID(c *gin.Context) {
 	oldUserID := c.Param("userID")
-	newUserID := c.Query("new_userID")
+	newUserID := strings.TrimSpace(c.Query("new_userID"))
 
 	if oldUserID == "" || newUserID == "" {
 		c.JSON(http.StatusBadRequest, gin.H{
@@ -330,13 +538,39 @@ func UpdateUserID(c *gin.Context) {
 		return
 	}
 
-	// 鉴权
-	err := middlewares.UserAuthMiddleware(c, oldUserID)
-	if err != nil {
+	// 新的用户ID和注册时一样检查格式和长度
+	newUserID, err := checkUserString(newUserID)
+	if err != nil || !checkUserIDLength(newUserID) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"statusCode": http.StatusBadRequest,
+			"message":    "用户ID必须由字母、数字组成并符合规定长度",
+		})
+		c.AbortWithStatus(http.StatusBadRequest)
+		log.WithFields(log.Fields{
+			"errorMsg":   err,
+			"newUserID":  newUserID,
+			"statusCode": http.StatusBadRequest,
+		}).Info("User userID update failed")
+
 		return
 	}
+	middlewares.AuditBefore(c, *middlewares.CurrentUser(c))
 
 	err = models.UpdateUserID(oldUserID, newUserID)
+	if err == models.ErrUserIDExist {
+		c.JSON(http.StatusConflict, gin.H{
+			"statusCode": http.StatusConflict,
+			"message":    "用户ID已经存在",
+		})
+		c.AbortWithStatus(http.StatusConflict)
+		log.WithFields(log.Fields{
+			"errorMsg":   err,
+			"newUserID":  newUserID,
+			"statusCode": http.StatusConflict,
+		}).Info("User userID update failed")
+
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"statusCode": http.StatusInternalServerError,
@@ -351,14 +585,33 @@ func UpdateUserID(c *gin.Context) {
 		return
 	}
 
-	// 更新redis里token key
-	token, _ := models.RedisClient.Get(oldUserID + "_token").Result()
-	_ = models.RedisClient.Set(newUserID+"_token", token, 6).Err()
+	auditUserAfter(c, newUserID)
+
+	// 原来的 token 属于旧的用户ID, 签发新的 token
+	revokeUserToken(oldUserID)
+	pair, err := token.IssueSession(token.RoleUser, newUserID, clientInfo(c, ""))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"statusCode": http.StatusInternalServerError,
+			"message":    "更改成功，请重新登录",
+		})
+		c.AbortWithStatus(http.StatusInternalServerError)
+		log.WithFields(log.Fields{
+			"errorMsg":   err,
+			"statusCode": http.StatusInternalServerError,
+		}).Info("User userID update failed")
+
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"statusCode": http.StatusOK,
-		"message":    "更改成功",
-		"user_id":    newUserID,
+		"statusCode":     http.StatusOK,
+		"message":        "更改成功",
+		"user_id":        newUserID,
+		"token":          pair.AccessToken,
+		"max_expire":     pair.Claims.ExpireAt().Format(time.RFC3339),
+		"refresh_token":  pair.RefreshToken,
+		"refresh_expire": pair.RefreshExpireAt.Format(time.RFC3339),
 	})
 }
 
@@ -396,13 +649,15 @@ func UpdateUserName(c *gin.Context) {
 		return
 	}
 
-	// 鉴权
-	err := middlewares.UserAuthMiddleware(c, userID)
-	if err != nil {
+	// 检查新用户名是否包含敏感词
+	_, ok := filterContent(c, filter.NameFilter, filter.KindUserName, newUserName, "User userName update failed")
+	if !ok {
 		return
 	}
 
-	err = models.UpdateUserName(oldUserName, newUserName)
+	middlewares.AuditBefore(c, *middlewares.CurrentUser(c))
+
+	err := models.UpdateUserName(oldUserName, newUserName)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"statusCode": http.StatusInternalServerError,
@@ -416,6 +671,7 @@ func UpdateUserName(c *gin.Context) {
 
 		return
 	}
+	auditUserAfter(c, userID)
 
 	// 更新redis里的token key
 	// token, _ := models.RedisClient.Get(userID + "_token").Result()
@@ -466,8 +722,8 @@ func UpdateUserPassword(c *gin.Context) {
 		return
 	}
 
-	// 清除redis里原来的token
-	_ = models.RedisClient.Del(userID + "_token")
+	// 清除redis里原来的token, 所有登录都需要重新登录
+	revokeUserToken(userID)
 
 	c.JSON(http.StatusOK, gin.H{
 		"statusCode": http.StatusOK,
@@ -495,10 +751,16 @@ func UserLoginHandler(c *gin.Context) {
 		return
 	}
 
+	// 账号或者 ip 被锁定时不验证密码
+	if !checkLoginLock(c, models.LoginGuardUser, loginVals.UserID, "User login failed") {
+		return
+	}
+
 	// 从数据库查询密码
 	hashPassword, err := models.GetPasswordByUserID(loginVals.UserID)
 	if err != nil {
 		if err == sql.ErrNoRows {
+			recordLoginFailure(c, models.LoginGuardUser, loginVals.UserID)
 			c.JSON(http.StatusBadRequest, gin.H{
 				"satusCode": http.StatusBadRequest,
 				"message":   "该账号不存在",
@@ -528,6 +790,7 @@ func UserLoginHandler(c *gin.Context) {
 	// 验证密码
 	err = bcrypt.CompareHashAndPassword([]byte(hashPassword), []byte(loginVals.Password))
 	if err != nil {
+		recordLoginFailure(c, models.LoginGuardUser, loginVals.UserID)
 		c.JSON(http.StatusBadRequest, gin.H{
 			"statusCode": http.StatusBadRequest,
 			"message":    "密码不正确",
@@ -541,36 +804,22 @@ func UserLoginHandler(c *gin.Context) {
 		return
 	}
 
-	// 生成token
-	token := jwt.New(jwt.GetSigningMethod(SigningAlgorithm))
-	claims := token.Claims.(jwt.MapClaims)
-
-	// 设置token过期时间
-	expire := time.Now().Add(Timeout)
-	claims["id"] = loginVals.UserID
-	claims["exp"] = expire.Unix()
-	claims["orig_iat"] = time.Now().Unix()
-
-	// 生成token
-	tokenString, err := token.SignedString(secretKey)
-
-	// 生成token失败
+	// 黑名单用户不能登录
+	user, err := models.GetUserByUserID(loginVals.UserID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"statusCode": http.StatusInternalServerError,
-			"message":    "请重新尝试",
-		})
-		c.AbortWithStatus(http.StatusInternalServerError)
-		log.WithFields(log.Fields{
-			"errorMsg":   "Generate token failed",
-			"statusCode": http.StatusInternalServerError,
-		}).Info("User login failed")
+		abortUserError(c, err, "User login failed")
+
+		return
+	}
+	if user.IsBanned() {
+		abortBannedUser(c, user, "User login failed")
 
 		return
 	}
+	clearLoginFailures(models.LoginGuardUser, loginVals.UserID)
 
-	// 把用户 token 放入 redis里, token不同步进数据库
-	err = models.RedisClient.Set(loginVals.UserID+"_token", tokenString, time.Hour*24).Err()
+	// 生成token
+	pair, err := token.IssueSession(token.RoleUser, user.UserID, clientInfo(c, loginVals.Device))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"statusCode": http.StatusInternalServerError,
@@ -578,7 +827,7 @@ func UserLoginHandler(c *gin.Context) {
 		})
 		c.AbortWithStatus(http.StatusInternalServerError)
 		log.WithFields(log.Fields{
-			"errorMsg":   "Store token to redis failed",
+			"errorMsg":   err,
 			"statusCode": http.StatusInternalServerError,
 		}).Info("User login failed")
 
@@ -587,10 +836,12 @@ func UserLoginHandler(c *gin.Context) {
 
 	// 生成token成功
 	c.JSON(http.StatusOK, gin.H{
-		"statusCode": http.StatusOK,
-		"message":    "登录成功",
-		"token":      tokenString,
-		"max_expire": expire.Format(time.RFC3339),
+		"statusCode":     http.StatusOK,
+		"message":        "登录成功",
+		"token":          pair.AccessToken,
+		"max_expire":     pair.Claims.ExpireAt().Format(time.RFC3339),
+		"refresh_token":  pair.RefreshToken,
+		"refresh_expire": pair.RefreshExpireAt.Format(time.RFC3339),
 	})
 
 	log.WithFields(log.Fields{
@@ -657,3 +908,97 @@ func checkUserPasswordLength(password string) bool {
 
 	return true
 }
+
+// 根据路由里的 userID 获取用户, 不存在时返回 404, 非管理员管理有角色的用户时返回 403
+func getManagedUser(c *gin.Context, logMsg string) (*models.User, bool) {
+	user, err := models.GetUserByUserID(c.Param("userID"))
+	if err != nil {
+		abortUserError(c, err, logMsg)
+
+		return nil, false
+	}
+
+	// 有角色的用户只能由管理员管理
+	if staff := middlewares.CurrentStaff(c); staff != nil && user.Role != "" {
+		c.JSON(http.StatusForbidden, gin.H{
+			"statusCode": http.StatusForbidden,
+			"message":    "You don't have permission to manage this user",
+		})
+		c.AbortWithStatus(http.StatusForbidden)
+		log.WithFields(log.Fields{
+			"userID":     user.UserID,
+			"staff":      staff.UserID,
+			"statusCode": http.StatusForbidden,
+		}).Info(logMsg)
+
+		return nil, false
+	}
+
+	return user, true
+}
+
+// 修改后重新查询用户, 作为审计日志里修改后的内容, 查询失败时不记录
+func auditUserAfter(c *gin.Context, userID string) {
+	user, err := models.GetUserByUserID(userID)
+	if err == nil {
+		middlewares.AuditAfter(c, user)
+	}
+}
+
+// 用户查询出错时返回响应, 用户不存在返回 404, 其他错误返回 500
+func abortUserError(c *gin.Context, err error, logMsg string) {
+	statusCode := http.StatusInternalServerError
+	message := http.StatusText(http.StatusInternalServerError)
+	if err == sql.ErrNoRows {
+		statusCode = http.StatusNotFound
+		message = "User not found"
+	}
+
+	c.JSON(statusCode, gin.H{
+		"statusCode": statusCode,
+		"message":    message,
+	})
+	c.AbortWithStatus(statusCode)
+	log.WithFields(log.Fields{
+		"errorMsg":   err,
+		"statusCode": statusCode,
+	}).Info(logMsg)
+}
+
+// 黑名单用户返回 403, 带上封禁原因和到期时间
+func abortBannedUser(c *gin.Context, user *models.User, logMsg string) {
+	c.JSON(http.StatusForbidden, gin.H{
+		"statusCode":    http.StatusForbidden,
+		"message":       "User is in blacklist",
+		"ban_reason":    user.BanReason,
+		"ban_expire_at": user.BanExpireAt,
+	})
+	c.AbortWithStatus(http.StatusForbidden)
+	log.WithFields(log.Fields{
+		"userID":     user.UserID,
+		"statusCode": http.StatusForbidden,
+	}).Info(logMsg)
+}
+
+// 删除用户的所有会话, 用户需要重新登录
+func revokeUserToken(userID string) error {
+	err := token.Revoke(token.RoleUser, userID)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+			"userID":   userID,
+		}).Info("Delete user token from redis failed")
+	}
+
+	return err
+}
+
+// 解析封禁到期时间, 支持 2006-01-02 15:04:05 和 RFC3339
+func parseBanExpire(value string) (time.Time, error) {
+	expire, err := time.ParseInLocation("2006-01-02 15:04:05", value, time.Local)
+	if err != nil {
+		expire, err = time.Parse(time.RFC3339, value)
+	}
+
+	return expire, err
+}
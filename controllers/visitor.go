@@ -2,6 +2,7 @@ package controllers
 
 import (
 	"net/http"
+	"strconv"
 
 	"github.com/ztplz/blog-server/middlewares"
 
@@ -36,7 +37,7 @@ func GetVisitCount(c *gin.Context) {
 		}
 
 		// 如果从数据库读取成功就同步到 redis 里
-		err = models.RedisClient.Set("all_visitor_count", string(count), 0).Err()
+		err = models.RedisClient.Set("all_visitor_count", strconv.FormatUint(uint64(count), 10), 0).Err()
 		if err != nil {
 			log.WithFields(log.Fields{
 				"message": err,
@@ -51,3 +52,35 @@ func GetVisitCount(c *gin.Context) {
 		"today_visitor_count": len(middlewares.IPPool),
 	})
 }
+
+// GetStatsHandler 后台站点统计, 包括访客数、博文数、用户数和待审核的评论留言数
+func GetStatsHandler(c *gin.Context) {
+	stats, err := models.GetSiteStats()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"statusCode": http.StatusInternalServerError,
+			"message":    http.StatusText(http.StatusInternalServerError),
+		})
+		c.AbortWithStatus(http.StatusInternalServerError)
+		log.WithFields(log.Fields{
+			"errorMsg":   err,
+			"statusCode": http.StatusInternalServerError,
+		}).Info("Get site stats failed")
+
+		return
+	}
+
+	count, err := models.GetAllVisitorCount()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+		}).Info("Get all visitor count failed")
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"statusCode":          http.StatusOK,
+		"stats":               stats,
+		"all_visitor_count":   count,
+		"today_visitor_count": len(middlewares.IPPool),
+	})
+}
@@ -0,0 +1,116 @@
+package filter
+
+// Match 敏感词在文本里出现的位置, Start 和 End 为 rune 下标, 不包括 End
+type Match struct {
+	Word  string
+	Start int
+	End   int
+}
+
+type acNode struct {
+	next map[rune]int
+
+	// 失配时跳转的节点
+	fail int
+
+	// 以这个节点结尾的敏感词下标, 包括失配链上的
+	output []int
+}
+
+// Matcher Aho-Corasick 自动机, 按 rune 匹配, 中文和英文都可以使用
+type Matcher struct {
+	nodes []acNode
+	words [][]rune
+}
+
+// NewMatcher 用敏感词建立自动机, 空字符串会被忽略
+func NewMatcher(words []string) *Matcher {
+	m := &Matcher{nodes: []acNode{{next: make(map[rune]int)}}}
+
+	for _, word := range words {
+		runes := []rune(word)
+		if len(runes) == 0 {
+			continue
+		}
+
+		node := 0
+		for _, r := range runes {
+			child, ok := m.nodes[node].next[r]
+			if !ok {
+				child = len(m.nodes)
+				m.nodes = append(m.nodes, acNode{next: make(map[rune]int)})
+				m.nodes[node].next[r] = child
+			}
+			node = child
+		}
+
+		m.nodes[node].output = append(m.nodes[node].output, len(m.words))
+		m.words = append(m.words, runes)
+	}
+
+	// 按层次遍历计算失配指针
+	queue := []int{}
+	for _, child := range m.nodes[0].next {
+		queue = append(queue, child)
+	}
+
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+
+		for r, child := range m.nodes[node].next {
+			fail := m.nodes[node].fail
+			for fail != 0 {
+				if _, ok := m.nodes[fail].next[r]; ok {
+					break
+				}
+				fail = m.nodes[fail].fail
+			}
+			if next, ok := m.nodes[fail].next[r]; ok && next != child {
+				fail = next
+			} else {
+				fail = 0
+			}
+
+			m.nodes[child].fail = fail
+			m.nodes[child].output = append(m.nodes[child].output, m.nodes[fail].output...)
+			queue = append(queue, child)
+		}
+	}
+
+	return m
+}
+
+// Find 找出文本里出现的全部敏感词
+func (m *Matcher) Find(text []rune) []Match {
+	var matches []Match
+
+	node := 0
+	for i, r := range text {
+		for node != 0 {
+			if _, ok := m.nodes[node].next[r]; ok {
+				break
+			}
+			node = m.nodes[node].fail
+		}
+		if next, ok := m.nodes[node].next[r]; ok {
+			node = next
+		}
+
+		for _, index := range m.nodes[node].output {
+			word := m.words[index]
+			matches = append(matches, Match{
+				Word:  string(word),
+				Start: i + 1 - len(word),
+				End:   i + 1,
+			})
+		}
+	}
+
+	return matches
+}
+
+// Len 敏感词的数量
+func (m *Matcher) Len() int {
+	return len(m.words)
+}
@@ -0,0 +1,50 @@
+package filter
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMatcherFind(t *testing.T) {
+	tests := []struct {
+		words []string
+		text  string
+		want  []Match
+	}{
+		{[]string{"he", "she", "his", "hers"}, "ushers", []Match{
+			{"she", 1, 4},
+			{"he", 2, 4},
+			{"hers", 2, 6},
+		}},
+		{[]string{"a", "ab", "bab", "bc", "bca", "c", "caa"}, "abccab", []Match{
+			{"a", 0, 1},
+			{"ab", 0, 2},
+			{"bc", 1, 3},
+			{"c", 2, 3},
+			{"c", 3, 4},
+			{"a", 4, 5},
+			{"ab", 4, 6},
+		}},
+		// 按 rune 匹配, 下标为 rune 下标
+		{[]string{"敏感", "感词"}, "有敏感词", []Match{
+			{"敏感", 1, 3},
+			{"感词", 2, 4},
+		}},
+		{[]string{"", "spam"}, "no match here", nil},
+		{nil, "anything", nil},
+	}
+
+	for _, test := range tests {
+		got := NewMatcher(test.words).Find([]rune(test.text))
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("NewMatcher(%q).Find(%q) = %v, want %v", test.words, test.text, got, test.want)
+		}
+	}
+}
+
+func TestMatcherLen(t *testing.T) {
+	m := NewMatcher([]string{"a", "", "b"})
+	if m.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", m.Len())
+	}
+}
@@ -0,0 +1,166 @@
+/*
+* 用户提交内容的过滤
+*
+* 内容依次经过 Pipeline 里的每个 Stage，每个 Stage 返回一个分数和原因，
+* 总分达到 holdScore 时内容需要人工审核，达到 rejectScore 时直接拒绝。
+* 原因会保存下来，方便管理员知道内容为什么被拦下
+ */
+
+package filter
+
+import (
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// 内容的种类, 用于区分重复内容和提交频率的计数
+const (
+	// KindRegister 用户注册, 检查用户 ID 和用户名
+	KindRegister = "register"
+
+	// KindUserName 修改用户名或者游客名字
+	KindUserName = "user_name"
+
+	// KindComment 评论内容
+	KindComment = "comment"
+
+	// KindGuestbook 留言内容
+	KindGuestbook = "guestbook"
+)
+
+// 过滤的结果
+const (
+	// ActionPass 通过
+	ActionPass = "pass"
+
+	// ActionHold 需要人工审核
+	ActionHold = "hold"
+
+	// ActionReject 直接拒绝
+	ActionReject = "reject"
+)
+
+// Content 要检查的内容
+type Content struct {
+	Kind string
+	Text string
+	IP   string
+}
+
+// Result 某个 Stage 的检查结果, 分数为 0 表示没有问题
+type Result struct {
+	Stage  string `json:"stage"`
+	Score  int    `json:"score"`
+	Reason string `json:"reason"`
+}
+
+// Stage 过滤的一个步骤
+type Stage interface {
+	// Name 步骤的名字, 会出现在 Result 里
+	Name() string
+
+	// Check 检查内容, 返回分数和原因
+	Check(content *Content) (int, string, error)
+}
+
+// Verdict 内容经过全部 Stage 后的结果, Results 只包括分数大于 0 的结果
+type Verdict struct {
+	Action  string   `json:"action"`
+	Score   int      `json:"score"`
+	Results []Result `json:"results"`
+}
+
+// Reason 把全部原因连成一个字符串
+func (v *Verdict) Reason() string {
+	reasons := make([]string, 0, len(v.Results))
+	for _, result := range v.Results {
+		reasons = append(reasons, result.Stage+": "+result.Reason)
+	}
+
+	return strings.Join(reasons, "; ")
+}
+
+// Pipeline 由多个 Stage 组成的过滤器
+type Pipeline struct {
+	stages      []Stage
+	holdScore   int
+	rejectScore int
+}
+
+// NewPipeline 新建过滤器, 总分达到 holdScore 时需要审核, 达到 rejectScore 时拒绝
+func NewPipeline(holdScore int, rejectScore int, stages ...Stage) *Pipeline {
+	return &Pipeline{
+		stages:      stages,
+		holdScore:   holdScore,
+		rejectScore: rejectScore,
+	}
+}
+
+// Run 依次执行每个 Stage, 出错的 Stage 记录日志后跳过
+func (p *Pipeline) Run(content *Content) *Verdict {
+	verdict := &Verdict{Action: ActionPass, Results: []Result{}}
+
+	for _, stage := range p.stages {
+		score, reason, err := stage.Check(content)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"errorMsg": err,
+				"stage":    stage.Name(),
+				"kind":     content.Kind,
+			}).Info("Filter stage failed")
+
+			continue
+		}
+		if score <= 0 {
+			continue
+		}
+
+		verdict.Score += score
+		verdict.Results = append(verdict.Results, Result{
+			Stage:  stage.Name(),
+			Score:  score,
+			Reason: reason,
+		})
+	}
+
+	switch {
+	case verdict.Score >= p.rejectScore:
+		verdict.Action = ActionReject
+	case verdict.Score >= p.holdScore:
+		verdict.Action = ActionHold
+	}
+
+	if verdict.Action != ActionPass {
+		log.WithFields(log.Fields{
+			"kind":   content.Kind,
+			"ip":     content.IP,
+			"action": verdict.Action,
+			"reason": verdict.Reason(),
+		}).Info("Content filtered")
+	}
+
+	return verdict
+}
+
+var (
+	// NameFilter 用户名和游客名字, 包含敏感词直接拒绝
+	NameFilter = NewPipeline(100, 100,
+		&SensitiveWordStage{Score: 100},
+	)
+
+	// RegisterFilter 用户注册, 包含敏感词或者同一个 IP 一小时内注册超过 5 次直接拒绝
+	RegisterFilter = NewPipeline(100, 100,
+		&SensitiveWordStage{Score: 100},
+		&VelocityStage{Limit: 5, Window: time.Hour, Score: 100},
+	)
+
+	// CommentFilter 评论和留言内容, 有一项问题需要审核, 两项或者提交过快直接拒绝
+	CommentFilter = NewPipeline(50, 100,
+		&SensitiveWordStage{Score: 50},
+		&LinkStage{Max: 2, Score: 50},
+		&DuplicateStage{Window: 24 * time.Hour, Score: 50},
+		&VelocityStage{Limit: 10, Window: 10 * time.Minute, Score: 100},
+	)
+)
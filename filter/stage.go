@@ -0,0 +1,215 @@
+package filter
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/ztplz/blog-server/models"
+)
+
+// 当前使用的敏感词自动机, 修改敏感词时整个替换
+var (
+	sensitiveMu      sync.RWMutex
+	sensitiveWords   = []string{}
+	sensitiveMatcher = NewMatcher(nil)
+)
+
+// 链接, 包括没有写协议的 www. 开头的地址
+var linkRegexp = regexp.MustCompile(`(?i)(https?://|www\.)[^\s<>"']+`)
+
+// InitialSensitiveWords 从站点设置里读取敏感词
+func InitialSensitiveWords() {
+	value, err := models.GetOption(models.OptionSensitiveWords, "")
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+		}).Fatal("Load sensitive words failed")
+	}
+
+	SetSensitiveWords(ParseWords(value))
+
+	log.WithFields(log.Fields{
+		"count": len(SensitiveWords()),
+	}).Info("Load sensitive words success")
+}
+
+// ParseWords 把每行一个的敏感词转换成数组, 去掉空行和重复的词, 英文统一小写
+func ParseWords(value string) []string {
+	seen := make(map[string]bool)
+	words := []string{}
+
+	for _, line := range strings.Split(value, "\n") {
+		word := strings.ToLower(strings.TrimSpace(line))
+		if word == "" || seen[word] {
+			continue
+		}
+
+		seen[word] = true
+		words = append(words, word)
+	}
+
+	return words
+}
+
+// SetSensitiveWords 替换当前使用的敏感词
+func SetSensitiveWords(words []string) {
+	matcher := NewMatcher(words)
+
+	sensitiveMu.Lock()
+	sensitiveWords = words
+	sensitiveMatcher = matcher
+	sensitiveMu.Unlock()
+}
+
+// SensitiveWords 当前使用的敏感词
+func SensitiveWords() []string {
+	sensitiveMu.RLock()
+	defer sensitiveMu.RUnlock()
+
+	return sensitiveWords
+}
+
+// SensitiveWordStage 检查敏感词, 英文不区分大小写, 并且只匹配完整的单词
+type SensitiveWordStage struct {
+	Score int
+}
+
+// Name 步骤的名字
+func (s *SensitiveWordStage) Name() string {
+	return "sensitive_word"
+}
+
+// Check 出现敏感词时返回 Score, 原因里列出命中的敏感词
+func (s *SensitiveWordStage) Check(content *Content) (int, string, error) {
+	sensitiveMu.RLock()
+	matcher := sensitiveMatcher
+	sensitiveMu.RUnlock()
+
+	if matcher.Len() == 0 {
+		return 0, "", nil
+	}
+
+	text := []rune(strings.ToLower(content.Text))
+
+	seen := make(map[string]bool)
+	words := []string{}
+	for _, match := range matcher.Find(text) {
+		if seen[match.Word] || !wordBoundary(text, match) {
+			continue
+		}
+
+		seen[match.Word] = true
+		words = append(words, match.Word)
+	}
+
+	if len(words) == 0 {
+		return 0, "", nil
+	}
+
+	return s.Score, "contains sensitive words: " + strings.Join(words, ", "), nil
+}
+
+// 英文敏感词两边不能紧挨着字母或数字, 避免 class 命中 ass
+func wordBoundary(text []rune, match Match) bool {
+	if isASCIIWord(text[match.Start]) && match.Start > 0 && isASCIIWord(text[match.Start-1]) {
+		return false
+	}
+	if isASCIIWord(text[match.End-1]) && match.End < len(text) && isASCIIWord(text[match.End]) {
+		return false
+	}
+
+	return true
+}
+
+func isASCIIWord(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9'
+}
+
+// LinkStage 限制链接的数量
+type LinkStage struct {
+	Max   int
+	Score int
+}
+
+// Name 步骤的名字
+func (s *LinkStage) Name() string {
+	return "link_count"
+}
+
+// Check 链接超过 Max 个时返回 Score
+func (s *LinkStage) Check(content *Content) (int, string, error) {
+	count := len(linkRegexp.FindAllStringIndex(content.Text, -1))
+	if count <= s.Max {
+		return 0, "", nil
+	}
+
+	return s.Score, fmt.Sprintf("contains %d links, max %d", count, s.Max), nil
+}
+
+// DuplicateStage 检查 Window 时间内是否提交过相同的内容, 忽略大小写和空白字符
+type DuplicateStage struct {
+	Window time.Duration
+	Score  int
+}
+
+// Name 步骤的名字
+func (s *DuplicateStage) Name() string {
+	return "duplicate_content"
+}
+
+// Check 内容的摘要存在 redis 里, 已经存在时返回 Score
+func (s *DuplicateStage) Check(content *Content) (int, string, error) {
+	normalized := strings.Join(strings.Fields(strings.ToLower(content.Text)), "")
+	if normalized == "" {
+		return 0, "", nil
+	}
+
+	sum := sha1.Sum([]byte(normalized))
+	key := "filter_duplicate_" + content.Kind + "_" + hex.EncodeToString(sum[:])
+
+	ok, err := models.RedisClient.SetNX(key, 1, s.Window).Result()
+	if err != nil {
+		return 0, "", err
+	}
+	if ok {
+		return 0, "", nil
+	}
+
+	return s.Score, "same content submitted within " + s.Window.String(), nil
+}
+
+// VelocityStage 限制同一个 IP 在 Window 时间内的提交次数
+type VelocityStage struct {
+	Limit  int64
+	Window time.Duration
+	Score  int
+}
+
+// Name 步骤的名字
+func (s *VelocityStage) Name() string {
+	return "ip_velocity"
+}
+
+// Check 提交次数记录在 redis 里, 超过 Limit 时返回 Score
+func (s *VelocityStage) Check(content *Content) (int, string, error) {
+	if content.IP == "" {
+		return 0, "", nil
+	}
+
+	count, _, err := models.IncreaseRateCount("filter_velocity_"+content.Kind+"_"+content.IP, s.Window)
+	if err != nil {
+		return 0, "", err
+	}
+
+	if count <= s.Limit {
+		return 0, "", nil
+	}
+
+	return s.Score, fmt.Sprintf("%d submissions from %s within %s, limit %d", count, content.IP, s.Window, s.Limit), nil
+}
@@ -0,0 +1,29 @@
+package filter
+
+import "testing"
+
+func TestWordBoundary(t *testing.T) {
+	tests := []struct {
+		text  string
+		match Match
+		want  bool
+	}{
+		{"ass", Match{"ass", 0, 3}, true},
+		{"an ass here", Match{"ass", 3, 6}, true},
+		{"class", Match{"ass", 2, 5}, false},
+		{"assert", Match{"ass", 0, 3}, false},
+		{"ass1", Match{"ass", 0, 3}, false},
+		{"ass!", Match{"ass", 0, 3}, true},
+		// 中文前后没有单词边界
+		{"这是敏感词", Match{"敏感", 2, 4}, true},
+		{"a敏感b", Match{"敏感", 1, 3}, true},
+		{"中ass文", Match{"ass", 1, 4}, true},
+	}
+
+	for _, test := range tests {
+		got := wordBoundary([]rune(test.text), test.match)
+		if got != test.want {
+			t.Errorf("wordBoundary(%q, %v) = %v, want %v", test.text, test.match, got, test.want)
+		}
+	}
+}
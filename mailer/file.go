@@ -0,0 +1,32 @@
+package mailer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// FileMailer 把邮件写成 Dir 目录下的 .eml 文件, 用于开发和测试
+type FileMailer struct {
+	Dir  string
+	From string
+}
+
+// Send 写入邮件, 文件名为写入时的纳秒时间戳
+func (m *FileMailer) Send(msg *Message) error {
+	err := os.MkdirAll(m.Dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	name := strconv.FormatInt(time.Now().UnixNano(), 10) + ".eml"
+
+	return ioutil.WriteFile(filepath.Join(m.Dir, name), buildMessage(m.From, msg), 0644)
+}
+
+// String 用于日志
+func (m *FileMailer) String() string {
+	return "file://" + m.Dir
+}
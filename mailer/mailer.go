@@ -0,0 +1,111 @@
+/*
+* 发送邮件
+*
+* Mailer 是发送邮件的接口，SMTPMailer 通过 SMTP 服务器发送，FileMailer 把邮件写成 .eml 文件，
+* 用于开发和测试。请求里不直接发送邮件，而是用 Enqueue 放进 redis 队列，由后台的 worker 发送，
+* 发送失败时按指数退避重试
+ */
+
+package mailer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"mime"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Message 邮件, Body 为纯文本
+type Message struct {
+	To      []string `json:"to"`
+	Subject string   `json:"subject"`
+	Body    string   `json:"body"`
+}
+
+// Mailer 发送邮件的接口
+type Mailer interface {
+	Send(msg *Message) error
+}
+
+var (
+	// Default 后台 worker 使用的 Mailer
+	Default Mailer
+
+	// AdminAddress 接收新评论和新留言通知的邮箱, 为空时不通知
+	AdminAddress string
+)
+
+// InitialMailer 初始化 Mailer
+func InitialMailer() {
+	// 开发时把邮件写到 mail 目录
+	Default = &FileMailer{
+		Dir:  "mail",
+		From: "blog@localhost",
+	}
+
+	// 使用 SMTP 服务器发送
+	// Default = &SMTPMailer{
+	// 	Host:     "smtp.example.com",
+	// 	Port:     587,
+	// 	Username: "blog@example.com",
+	// 	Password: "123456",
+	// 	From:     "blog@example.com",
+	// }
+
+	AdminAddress = "admin@localhost"
+
+	log.WithFields(log.Fields{
+		"mailer": Default,
+	}).Info("Mailer initial success")
+}
+
+// 生成完整的邮件内容, 标题和正文使用 UTF-8 和 base64 编码
+func buildMessage(from string, msg *Message) []byte {
+	var buf bytes.Buffer
+
+	writeHeader(&buf, "From", from)
+	writeHeader(&buf, "To", strings.Join(msg.To, ", "))
+	writeHeader(&buf, "Subject", mime.BEncoding.Encode("UTF-8", stripNewlines(msg.Subject)))
+	writeHeader(&buf, "Date", time.Now().Format(time.RFC1123Z))
+	writeHeader(&buf, "Message-ID", messageID(from))
+	writeHeader(&buf, "MIME-Version", "1.0")
+	writeHeader(&buf, "Content-Type", "text/plain; charset=UTF-8")
+	writeHeader(&buf, "Content-Transfer-Encoding", "base64")
+	buf.WriteString("\r\n")
+
+	// 每行最多 76 个字符
+	body := base64.StdEncoding.EncodeToString([]byte(msg.Body))
+	for len(body) > 76 {
+		buf.WriteString(body[:76] + "\r\n")
+		body = body[76:]
+	}
+	buf.WriteString(body + "\r\n")
+
+	return buf.Bytes()
+}
+
+func writeHeader(buf *bytes.Buffer, name string, value string) {
+	buf.WriteString(name + ": " + stripNewlines(value) + "\r\n")
+}
+
+// 去掉换行, 防止注入别的头
+func stripNewlines(value string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(value)
+}
+
+func messageID(from string) string {
+	b := make([]byte, 16)
+	rand.Read(b)
+
+	domain := "localhost"
+	if i := strings.LastIndex(from, "@"); i != -1 {
+		domain = strings.TrimSuffix(from[i+1:], ">")
+	}
+
+	return "<" + hex.EncodeToString(b) + "@" + domain + ">"
+}
@@ -0,0 +1,232 @@
+package mailer
+
+import (
+	"encoding/json"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis"
+	log "github.com/sirupsen/logrus"
+	"github.com/ztplz/blog-server/models"
+)
+
+const (
+	// 等待发送的邮件, redis list
+	queueKey = "mail_queue"
+
+	// 等待重试的邮件, redis sorted set, score 为下次发送的 unix 时间
+	retryKey = "mail_retry"
+
+	// 重试多次仍然失败的邮件, redis list
+	failedKey = "mail_failed"
+
+	// 正在发送的邮件, redis list, 后面接 worker 的主机名, 发送完成后删除,
+	// worker 重启时放回发送队列, 进程崩溃也不会丢失邮件
+	processingKeyPrefix = "mail_processing:"
+
+	// MaxAttempts 每封邮件最多发送的次数
+	MaxAttempts = 6
+
+	// 第一次重试的等待时间, 之后每次翻倍, 最多等待 retryDelayMax
+	retryDelay    = 30 * time.Second
+	retryDelayMax = time.Hour
+
+	// worker 每次等待新邮件的时间, 超时后检查一遍需要重试的邮件
+	pollTimeout = 5 * time.Second
+)
+
+// 把到时间的邮件从重试队列移回发送队列, 在 redis 里原子执行, 多个 worker 同时移动时每封邮件只会移动一次
+var moveDueRetriesScript = redis.NewScript(`
+local due = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1])
+for _, data in ipairs(due) do
+	redis.call('ZREM', KEYS[1], data)
+	redis.call('LPUSH', KEYS[2], data)
+end
+return #due
+`)
+
+// 队列里的邮件
+type job struct {
+	Message   *Message `json:"message"`
+	Attempts  int      `json:"attempts"`
+	LastError string   `json:"last_error"`
+	CreateAt  string   `json:"create_at"`
+}
+
+// Enqueue 把邮件放进 redis 队列, 由 worker 发送
+func Enqueue(msg *Message) error {
+	if len(msg.To) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(&job{
+		Message:  msg,
+		CreateAt: time.Now().Format("2006-01-02 15:04:05"),
+	})
+	if err != nil {
+		return err
+	}
+
+	return models.RedisClient.LPush(queueKey, data).Err()
+}
+
+// StartWorker 启动后台发送邮件的 worker, 同时运行多个实例时每个实例的主机名不能相同
+func StartWorker() {
+	processingKey := processingKeyPrefix + workerName()
+	recoverProcessing(processingKey)
+
+	go func() {
+		for {
+			moveDueRetries()
+
+			data, err := models.RedisClient.BRPopLPush(queueKey, processingKey, pollTimeout).Result()
+			if err == redis.Nil {
+				continue
+			}
+			if err != nil {
+				log.WithFields(log.Fields{
+					"errorMsg": err,
+				}).Info("Pop mail queue failed")
+
+				time.Sleep(pollTimeout)
+
+				continue
+			}
+
+			process(processingKey, data)
+		}
+	}()
+}
+
+// 用主机名区分不同实例的正在发送队列
+func workerName() string {
+	name, err := os.Hostname()
+	if err != nil || name == "" {
+		return "localhost"
+	}
+
+	return name
+}
+
+// 把上次退出时没有发送完成的邮件放回发送队列
+func recoverProcessing(processingKey string) {
+	count := 0
+	for {
+		err := models.RedisClient.RPopLPush(processingKey, queueKey).Err()
+		if err == redis.Nil {
+			break
+		}
+		if err != nil {
+			log.WithFields(log.Fields{
+				"errorMsg": err,
+			}).Info("Recover mail processing queue failed")
+
+			return
+		}
+		count++
+	}
+
+	if count > 0 {
+		log.WithFields(log.Fields{
+			"count": count,
+		}).Info("Recover mail processing queue success")
+	}
+}
+
+// 发送一封邮件, 失败时放进重试队列, 超过次数后放进失败队列, 处理完后从 processingKey 里删除
+func process(processingKey string, data string) {
+	var j job
+
+	err := json.Unmarshal([]byte(data), &j)
+	if err != nil || j.Message == nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+			"job":      data,
+		}).Info("Unmarshal mail job failed")
+
+		finishJob(processingKey, data, nil)
+
+		return
+	}
+
+	j.Attempts++
+	err = Default.Send(j.Message)
+	if err == nil {
+		log.WithFields(log.Fields{
+			"to":       j.Message.To,
+			"subject":  j.Message.Subject,
+			"attempts": j.Attempts,
+		}).Info("Send mail success")
+
+		finishJob(processingKey, data, nil)
+
+		return
+	}
+
+	j.LastError = err.Error()
+	log.WithFields(log.Fields{
+		"errorMsg": err,
+		"to":       j.Message.To,
+		"subject":  j.Message.Subject,
+		"attempts": j.Attempts,
+	}).Info("Send mail failed")
+
+	next, err := json.Marshal(&j)
+	if err != nil {
+		finishJob(processingKey, data, nil)
+
+		return
+	}
+
+	finishJob(processingKey, data, func(pipe redis.Pipeliner) {
+		if j.Attempts >= MaxAttempts {
+			pipe.LPush(failedKey, next)
+		} else {
+			at := time.Now().Add(backoff(j.Attempts)).Unix()
+			pipe.ZAdd(retryKey, redis.Z{Score: float64(at), Member: next})
+		}
+	})
+}
+
+// 在同一个事务里把邮件从 processingKey 删除并执行 requeue, 放进重试或失败队列
+func finishJob(processingKey string, data string, requeue func(pipe redis.Pipeliner)) {
+	_, err := models.RedisClient.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.LRem(processingKey, 1, data)
+		if requeue != nil {
+			requeue(pipe)
+		}
+
+		return nil
+	})
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+		}).Info("Store mail job to redis failed")
+	}
+}
+
+// 第 attempts 次失败后的等待时间
+func backoff(attempts int) time.Duration {
+	delay := retryDelay
+	for i := 1; i < attempts && delay < retryDelayMax; i++ {
+		delay *= 2
+	}
+	if delay > retryDelayMax {
+		delay = retryDelayMax
+	}
+
+	return delay
+}
+
+// 把到时间的邮件从重试队列移回发送队列
+func moveDueRetries() {
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+
+	err := moveDueRetriesScript.Run(models.RedisClient, []string{retryKey, queueKey}, now).Err()
+	if err != nil && err != redis.Nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+		}).Info("Move mail retries to queue failed")
+	}
+}
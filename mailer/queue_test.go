@@ -0,0 +1,30 @@
+package mailer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{0, 30 * time.Second},
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{5, 8 * time.Minute},
+		{7, 32 * time.Minute},
+		// 超过 retryDelayMax 后不再增加
+		{8, time.Hour},
+		{MaxAttempts * 10, time.Hour},
+	}
+
+	for _, test := range tests {
+		got := backoff(test.attempts)
+		if got != test.want {
+			t.Errorf("backoff(%d) = %v, want %v", test.attempts, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package mailer
+
+import (
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+)
+
+// SMTPMailer 通过 SMTP 服务器发送邮件, 服务器支持时使用 STARTTLS, Username 为空时不认证
+type SMTPMailer struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+// Send 发送邮件
+func (m *SMTPMailer) Send(msg *Message) error {
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	from := m.From
+	if address, err := mail.ParseAddress(m.From); err == nil {
+		from = address.Address
+	}
+
+	addr := net.JoinHostPort(m.Host, strconv.Itoa(m.Port))
+
+	return smtp.SendMail(addr, auth, from, msg.To, buildMessage(m.From, msg))
+}
+
+// String 用于日志, 不包括密码
+func (m *SMTPMailer) String() string {
+	return "smtp://" + net.JoinHostPort(m.Host, strconv.Itoa(m.Port))
+}
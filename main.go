@@ -13,8 +13,12 @@ import (
 	"os"
 
 	log "github.com/sirupsen/logrus"
+	"github.com/ztplz/blog-server/controllers"
+	"github.com/ztplz/blog-server/filter"
+	"github.com/ztplz/blog-server/mailer"
 	"github.com/ztplz/blog-server/models"
 	"github.com/ztplz/blog-server/router"
+	"github.com/ztplz/blog-server/token"
 	"github.com/ztplz/blog-server/middlewares"
 	"gopkg.in/robfig/cron.v2"
 )
@@ -23,6 +27,8 @@ func main() {
 	// 定时器设置
 	c := cron.New()
 	c.AddFunc("0 0 0 * * *", middlewares.AddYesterdayVisitorCount)
+	// 每分钟检查一次定时博文
+	c.AddFunc("0 * * * * *", controllers.PublishScheduledArticles)
 	c.Start()
 	// 日志设置
 	// log.SetFormatter(&log.JSONFormatter{})
@@ -32,13 +38,41 @@ func main() {
 	models.InitialRedis()
 	defer models.RedisClient.Close()
 
+	// 初始化 token 配置
+	token.InitialToken()
+
 	// 初始化数据库连接
 	models.InitialDB()
 	defer models.DB.Close()
 
-	// 初始化管理员账户
+	// 给旧的数据库补上新增的表、字段和索引
+	models.InitialMigration()
+
+	// 没有管理员时创建初始管理员账户
 	models.InitialAdmin()
 
+	// 把旧博文的 tag_list 迁移到 article_tag 表
+	models.InitialArticleTag()
+
+	// 给旧博文生成 slug
+	models.InitialArticleSlug()
+
+	// 渲染旧博文的 markdown
+	models.InitialArticleHTML()
+
+	// 建立搜索索引
+	models.InitialSearchIndex()
+
+	// 读取敏感词
+	filter.InitialSensitiveWords()
+
+	// 初始化邮件发送, 启动后台发送邮件的 worker
+	mailer.InitialMailer()
+	mailer.StartWorker()
+
+	// 读取站点的地址
+	controllers.InitialSiteURL()
+
 	// 初始化路由
 	router.InitialRouter()
 
@@ -0,0 +1,215 @@
+package middlewares
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"github.com/ztplz/blog-server/models"
+)
+
+// gin.Context 里保存审计内容的 key
+const (
+	auditBeforeKey = "audit_before"
+	auditAfterKey  = "audit_after"
+	auditTargetKey = "audit_target"
+)
+
+// 审计日志里保存的请求内容的最大长度, 超过时不保存请求内容
+const auditBodyMax = 64 << 10
+
+// 请求内容里不能写进审计日志的字段
+var auditRedactFields = map[string]bool{
+	"password":      true,
+	"old_password":  true,
+	"new_password":  true,
+	"code":          true,
+	"totp_code":     true,
+	"token":         true,
+	"refresh_token": true,
+}
+
+// 注册的所有路由, 用来把请求路径换回路由
+var auditRoutes gin.RoutesInfo
+
+// SetAuditRoutes 设置审计日志使用的路由表, 注册完所有路由之后调用
+func SetAuditRoutes(routes gin.RoutesInfo) {
+	auditRoutes = routes
+}
+
+// AuditMiddleware 把修改操作写进审计日志, 放在认证中间件之后, GET HEAD OPTIONS 请求不记录。
+// targetType 为操作对象的类型, 对象 id 为路由参数, 处理函数可以用 AuditBefore AuditAfter
+// 提供修改前后的内容, 没有提供修改后的内容时记录去掉密码等字段的请求内容
+func AuditMiddleware(targetType string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
+
+			return
+		}
+
+		body := readAuditBody(c)
+
+		c.Next()
+
+		entry := &models.AuditLog{
+			ActorType:  models.AuditActorAnonymous,
+			Action:     c.Request.Method + " " + routePattern(c),
+			TargetType: targetType,
+			StatusCode: c.Writer.Status(),
+			IP:         c.ClientIP(),
+			UserAgent:  c.Request.UserAgent(),
+			RequestID:  RequestID(c),
+		}
+
+		if admin, ok := c.Get(adminKey); ok {
+			entry.ActorType = models.AuditActorAdmin
+			entry.ActorID = strconv.FormatUint(uint64(admin.(*models.Admin).ID), 10)
+		} else if user, ok := c.Get(userKey); ok {
+			entry.ActorType = models.AuditActorUser
+			entry.ActorID = user.(*models.User).UserID
+		}
+
+		if target, ok := c.Get(auditTargetKey); ok {
+			t := target.([2]string)
+			entry.TargetType, entry.TargetID = t[0], t[1]
+		} else {
+			values := make([]string, 0, len(c.Params))
+			for _, param := range c.Params {
+				values = append(values, param.Value)
+			}
+			entry.TargetID = strings.Join(values, "/")
+		}
+
+		if before, ok := c.Get(auditBeforeKey); ok {
+			entry.Before = auditJSON(before)
+		}
+
+		if after, ok := c.Get(auditAfterKey); ok {
+			entry.After = auditJSON(after)
+		} else if body != nil {
+			entry.After = auditJSON(body)
+		} else if len(c.Request.URL.Query()) > 0 {
+			entry.After = auditJSON(c.Request.URL.Query())
+		}
+
+		err := models.AddAuditLog(entry)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"errorMsg":  err,
+				"action":    entry.Action,
+				"requestID": entry.RequestID,
+			}).Info("Audit failed")
+		}
+	}
+}
+
+// AuditBefore 提供修改前的内容, v 会在请求结束后转成 JSON
+func AuditBefore(c *gin.Context, v interface{}) {
+	c.Set(auditBeforeKey, v)
+}
+
+// AuditAfter 提供修改后的内容, v 会在请求结束后转成 JSON
+func AuditAfter(c *gin.Context, v interface{}) {
+	c.Set(auditAfterKey, v)
+}
+
+// AuditTarget 修改操作对象的类型和 id, 同一组路由操作不同对象时使用
+func AuditTarget(c *gin.Context, targetType string, targetID string) {
+	c.Set(auditTargetKey, [2]string{targetType, targetID})
+}
+
+// 读取 JSON 请求内容并放回去, 去掉密码等字段, 不是 JSON 对象或者太大时返回 nil
+func readAuditBody(c *gin.Context) map[string]interface{} {
+	if c.Request.Body == nil || c.ContentType() != gin.MIMEJSON {
+		return nil
+	}
+
+	data, err := ioutil.ReadAll(c.Request.Body)
+	c.Request.Body = ioutil.NopCloser(bytes.NewReader(data))
+	if err != nil || len(data) > auditBodyMax {
+		return nil
+	}
+
+	var body map[string]interface{}
+	err = json.Unmarshal(data, &body)
+	if err != nil {
+		return nil
+	}
+
+	for field := range body {
+		if auditRedactFields[strings.ToLower(field)] {
+			body[field] = "******"
+		}
+	}
+
+	return body
+}
+
+// 把请求路径里的路由参数换回参数名, 比如 /api/v1/tags/3 换成 /api/v1/tags/:id,
+// 先在路由表里找方法相同、固定路径和参数都对得上的路由, 参数值和固定路径相同时也不会换错
+func routePattern(c *gin.Context) string {
+	segments := strings.Split(c.Request.URL.Path, "/")
+
+	for _, route := range auditRoutes {
+		if route.Method == c.Request.Method && matchRoute(route.Path, segments, c.Params) {
+			return route.Path
+		}
+	}
+
+	// 没有路由表时参数按在路径里出现的顺序从后往前替换
+	end := len(segments)
+	for p := len(c.Params) - 1; p >= 0; p-- {
+		for i := end - 1; i >= 0; i-- {
+			if segments[i] == c.Params[p].Value {
+				segments[i] = ":" + c.Params[p].Key
+				end = i
+				break
+			}
+		}
+	}
+
+	return strings.Join(segments, "/")
+}
+
+// 路由的每一段都和请求路径相同, 参数段的值等于同名参数, 并且用到了所有参数
+func matchRoute(path string, segments []string, params gin.Params) bool {
+	parts := strings.Split(path, "/")
+	if len(parts) != len(segments) {
+		return false
+	}
+
+	count := 0
+	for i, part := range parts {
+		if strings.HasPrefix(part, ":") {
+			value, ok := params.Get(part[1:])
+			if !ok || value != segments[i] {
+				return false
+			}
+			count++
+
+			continue
+		}
+
+		if part != segments[i] {
+			return false
+		}
+	}
+
+	return count == len(params)
+}
+
+func auditJSON(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+
+	return string(data)
+}
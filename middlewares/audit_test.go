@@ -0,0 +1,85 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRoutePattern(t *testing.T) {
+	routes := auditRoutes
+	defer func() { auditRoutes = routes }()
+
+	SetAuditRoutes(gin.RoutesInfo{
+		{Method: http.MethodPut, Path: "/api/v1/tags/:id"},
+		{Method: http.MethodPut, Path: "/api/v1/admin/accounts/:id"},
+		{Method: http.MethodPut, Path: "/api/v1/articles/:id/revisions/:revisionID"},
+		{Method: http.MethodPut, Path: "/api/v1/articles/:id/revisions/diff"},
+		{Method: http.MethodPut, Path: "/api/v1/categories/:name"},
+	})
+
+	tests := []struct {
+		path   string
+		params gin.Params
+		want   string
+	}{
+		{"/api/v1/tags", nil, "/api/v1/tags"},
+		{"/api/v1/tags/3", gin.Params{{Key: "id", Value: "3"}}, "/api/v1/tags/:id"},
+		// 参数值和前面的固定路径相同
+		{"/api/v1/tags/tags", gin.Params{{Key: "id", Value: "tags"}}, "/api/v1/tags/:id"},
+		{"/api/v1/admin/accounts/admin", gin.Params{{Key: "id", Value: "admin"}}, "/api/v1/admin/accounts/:id"},
+		{"/api/v1/articles/3/revisions/3", gin.Params{{Key: "id", Value: "3"}, {Key: "revisionID", Value: "3"}}, "/api/v1/articles/:id/revisions/:revisionID"},
+		{"/api/v1/articles/revisions/revisions/revisions", gin.Params{{Key: "id", Value: "revisions"}, {Key: "revisionID", Value: "revisions"}}, "/api/v1/articles/:id/revisions/:revisionID"},
+		{"/api/v1/articles/3/revisions/diff", gin.Params{{Key: "id", Value: "3"}}, "/api/v1/articles/:id/revisions/diff"},
+		{"/api/v1/categories/%E6%8A%80%E6%9C%AF", gin.Params{{Key: "name", Value: "技术"}}, "/api/v1/categories/:name"},
+	}
+
+	for _, test := range tests {
+		u, err := url.Parse(test.path)
+		if err != nil {
+			t.Fatalf("url.Parse(%q) error: %v", test.path, err)
+		}
+
+		c := &gin.Context{
+			Request: &http.Request{Method: http.MethodPut, URL: u},
+			Params:  test.params,
+		}
+
+		got := routePattern(c)
+		if got != test.want {
+			t.Errorf("routePattern(%q, %v) = %q, want %q", test.path, test.params, got, test.want)
+		}
+	}
+}
+
+func TestRoutePatternWithoutRoutes(t *testing.T) {
+	routes := auditRoutes
+	defer func() { auditRoutes = routes }()
+
+	SetAuditRoutes(nil)
+
+	tests := []struct {
+		path   string
+		params gin.Params
+		want   string
+	}{
+		{"/api/v1/tags", nil, "/api/v1/tags"},
+		{"/api/v1/tags/3", gin.Params{{Key: "id", Value: "3"}}, "/api/v1/tags/:id"},
+		{"/api/v1/tags/tags", gin.Params{{Key: "id", Value: "tags"}}, "/api/v1/tags/:id"},
+		{"/api/v1/articles/3/revisions/3", gin.Params{{Key: "id", Value: "3"}, {Key: "revisionID", Value: "3"}}, "/api/v1/articles/:id/revisions/:revisionID"},
+	}
+
+	for _, test := range tests {
+		c := &gin.Context{
+			Request: &http.Request{Method: http.MethodPut, URL: &url.URL{Path: test.path}},
+			Params:  test.params,
+		}
+
+		got := routePattern(c)
+		if got != test.want {
+			t.Errorf("routePattern(%q, %v) = %q, want %q", test.path, test.params, got, test.want)
+		}
+	}
+}
@@ -10,283 +10,195 @@
 package middlewares
 
 import (
+	"database/sql"
 	"errors"
 	"net/http"
-	"strings"
-	"time"
+	"strconv"
 
-	jwt "github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
-	// "github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	"github.com/ztplz/blog-server/models"
+	"github.com/ztplz/blog-server/token"
 )
 
-// SigningAlgorithm token加密算法
-var SigningAlgorithm = "HS256"
-
-// secret key
-var secretKey = []byte("adminblog")
+// gin.Context 里保存认证结果的 key
+const (
+	claimsKey = "token_claims"
+	adminKey  = "auth_admin"
+	userKey   = "auth_user"
+)
 
-// Timeout token持续时间, 设置为一周
-// var Timeout = time.Hour * 24 * 7
-var Timeout = time.Second
+// AdminAuthMiddleware 后台token认证中间件, 认证通过后可以用 CurrentAdmin 获取管理员
+func AdminAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		_, err := AuthenticateAdmin(c)
+		if err != nil {
+			return
+		}
 
-// AdminLogin  管理员登录表单
-type AdminLogin struct {
-	AdminID  string `form:"admin_id" json:"admin_id" binding:"required"`
-	Password string `form:"password" json:"password" binding:"required"`
+		c.Next()
+	}
 }
 
-// AdminAuthMiddleware 后台token认证中间件
-func AdminAuthMiddleware(c *gin.Context) (*models.Admin, error) {
-	token, err := parseToken(c, "admin")
+// UserAuthMiddleware 用户token认证中间件, 路由里有 :userID 时只能操作自己的账号
+func UserAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		_, err := AuthenticateUser(c, c.Param("userID"))
+		if err != nil {
+			return
+		}
 
-	// 如果解析 token 发生错误
-	if err != nil {
-		c.Header("WWW-Authenticate", "JWT realm=gin jwt")
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"statusCode": http.StatusUnauthorized,
-			"message":    err.Error(),
-		})
-		c.AbortWithStatus(http.StatusUnauthorized)
-		log.WithFields(log.Fields{
-			"errorMsg":   err,
-			"statusCode": http.StatusUnauthorized,
-		}).Info("Admin auth failed")
+		c.Next()
+	}
+}
 
+// AuthenticateAdmin 验证管理员 token, 失败时返回响应并中止请求
+func AuthenticateAdmin(c *gin.Context) (*models.Admin, error) {
+	claims, err := authenticate(c, token.RoleAdmin, "Admin auth failed")
+	if err != nil {
 		return nil, err
 	}
 
-	claims := token.Claims.(jwt.MapClaims)
-
-	id := claims["id"].(string)
-	c.Set("JWT_PAYLOAD", claims)
-	c.Set("AdminID", id)
+	return loadAdmin(c, claims, "Admin auth failed")
+}
 
-	// 从数据取出管理员 ID
-	admin, err := models.AdminByID()
+// AuthenticateUser 验证用户 token, userID 不为空时 token 必须属于这个用户, 失败时返回响应并中止请求
+func AuthenticateUser(c *gin.Context, userID string) (*models.User, error) {
+	claims, err := authenticate(c, token.RoleUser, "User auth failed")
 	if err != nil {
-		c.Header("WWW-Authenticate", "JWT realm=gin jwt")
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"statusCode": http.StatusInternalServerError,
-			"message":    http.StatusText(http.StatusInternalServerError),
-		})
-		c.AbortWithStatus(http.StatusInternalServerError)
-		log.WithFields(log.Fields{
-			"errorMsg":   "admin info query failed",
-			"statusCode": http.StatusInternalServerError,
-		}).Info("Admin auth failed")
-
 		return nil, err
 	}
 
-	if id != admin.AdminID {
-		c.Header("WWW-Authenticate", "JWT realm=gin jwt")
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"statusCode": http.StatusUnauthorized,
+	if userID != "" && claims.Subject != userID {
+		c.JSON(http.StatusForbidden, gin.H{
+			"statusCode": http.StatusForbidden,
 			"message":    "You don't have permission to access",
 		})
-		c.AbortWithStatus(http.StatusUnauthorized)
+		c.AbortWithStatus(http.StatusForbidden)
 		log.WithFields(log.Fields{
-			"errorMsg":   "Id don't match adminID",
-			"statusCode": http.StatusUnauthorized,
-		}).Info("Admin auth failed")
+			"userID":     userID,
+			"subject":    claims.Subject,
+			"statusCode": http.StatusForbidden,
+		}).Info("User auth failed")
 
 		return nil, errors.New("Incorrect token")
 	}
 
-	// redis延长6个小时 token 存储时间
-	rtoken, err := models.RedisClient.Get("admin_token").Result()
-	if err != nil {
-		return nil, err
-	}
-
-	err = models.RedisClient.Set("admin_token", rtoken, time.Hour*6).Err()
-	if err != nil {
-		log.WithFields(log.Fields{
-			"errorMsg": err,
-		}).Info("Admin token store to redis failed")
-	}
-
-	return admin, nil
+	return loadUser(c, claims, "User auth failed")
 }
 
-// UserAuthMiddleware 后台token认证中间件
-func UserAuthMiddleware(c *gin.Context, userID string) error {
-	_, err := parseToken(c, userID)
+// CurrentAdmin 获取认证通过的管理员, 只能在 AdminAuthMiddleware 之后使用
+func CurrentAdmin(c *gin.Context) *models.Admin {
+	return c.MustGet(adminKey).(*models.Admin)
+}
 
-	// 如果解析 token 发生错误
-	if err != nil {
-		c.Header("WWW-Authenticate", "JWT realm=gin jwt")
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"statusCode": http.StatusUnauthorized,
-			"message":    err.Error(),
-		})
-		c.AbortWithStatus(http.StatusUnauthorized)
-		log.WithFields(log.Fields{
-			"errorMsg":   err,
-			"statusCode": http.StatusUnauthorized,
-		}).Info("Admin auth failed")
+// CurrentUser 获取认证通过的用户, 只能在 UserAuthMiddleware 之后使用
+func CurrentUser(c *gin.Context) *models.User {
+	return c.MustGet(userKey).(*models.User)
+}
 
-		return err
+// ExtractClaims 获取认证通过的 token claims, 没有认证时返回 nil
+func ExtractClaims(c *gin.Context) *token.Claims {
+	claims, exists := c.Get(claimsKey)
+	if !exists {
+		return nil
 	}
 
-	// rtoken, err := models.RedisClient.Get(userID + "_token").Result()
-	// if err != nil {
-	// 	c.JSON(http.StatusUnauthorized, gin.H{
-	// 		"statusCode": http.StatusUnauthorized,
-	// 		"message":    "请重新登录",
-	// 	})
-	// 	c.AbortWithStatus(http.StatusUnauthorized)
-	// 	log.WithFields(log.Fields{
-	// 		"errorMsg":   err,
-	// 		"statusCode": http.StatusUnauthorized,
-	// 	}).Info("Admin auth failed")
-
-	// 	return err
-	// }
-
-	// if *token != rtoken {
-	// 	c.JSON(http.StatusUnauthorized, gin.H{
-	// 		"statusCode": http.StatusUnauthorized,
-	// 		"message":    "你没有权限查看",
-	// 	})
-	// 	c.AbortWithStatus(http.StatusUnauthorized)
-	// 	log.WithFields(log.Fields{
-	// 		"errorMsg":   err,
-	// 		"statusCode": http.StatusUnauthorized,
-	// 	}).Info("Admin auth failed")
-
-	// 	return err
-	// }
-
-	// claims := token.Claims.(jwt.MapClaims)
-
-	// id := claims["id"].(string)
-	// c.Set("JWT_PAYLOAD", claims)
-	// c.Set("id", id)
-
-	// // 从数据取出用户 ID
-	// user, err := models.AdminByID()
-	// if err != nil {
-	// 	c.Header("WWW-Authenticate", "JWT realm=gin jwt")
-	// 	c.JSON(http.StatusInternalServerError, gin.H{
-	// 		"statusCode": http.StatusInternalServerError,
-	// 		"message":    http.StatusText(http.StatusInternalServerError),
-	// 	})
-	// 	c.AbortWithStatus(http.StatusInternalServerError)
-	// 	log.WithFields(log.Fields{
-	// 		"errorMsg":   "user info query failed",
-	// 		"statusCode": http.StatusInternalServerError,
-	// 	}).Info("User auth failed")
-
-	// 	return nil, err
-	// }
-
-	// if id != user.UserID {
-	// 	c.Header("WWW-Authenticate", "JWT realm=gin jwt")
-	// 	c.JSON(http.StatusUnauthorized, gin.H{
-	// 		"statusCode": http.StatusUnauthorized,
-	// 		"message":    "You don't have permission to access",
-	// 	})
-	// 	c.AbortWithStatus(http.StatusUnauthorized)
-	// 	log.WithFields(log.Fields{
-	// 		"errorMsg":   "Id don't match adminID",
-	// 		"statusCode": http.StatusUnauthorized,
-	// 	}).Info("Admin auth failed")
-
-	// 	return nil, errors.New("Incorrect token")
-	// }
-
-	// redis延长6个小时 token 存储时间
-
-	// err = models.RedisClient.GetSet(userID+"_token", rtoken, time.Hour*6).Err()
-	// if err != nil {
-	// 	log.WithFields(log.Fields{
-	// 		"errorMsg": err,
-	// 	}).Info("Admin token store to redis failed")
-	// }
-
-	return nil
+	return claims.(*token.Claims)
 }
 
-// ExtractClaims 提取 JWT claims
-func ExtractClaims(c *gin.Context) jwt.MapClaims {
-	_, exists := c.Get("JWT_PAYLOAD")
-	if !exists {
-		emptyClaims := make(jwt.MapClaims)
+// 从请求头取出 token 并验证, role 为空时管理员和用户的 token 都可以
+func authenticate(c *gin.Context, role string, logMsg string) (*token.Claims, error) {
+	tokenString, err := token.FromHeader(c.Request)
+	if err == nil {
+		var claims *token.Claims
+		claims, err = token.Verify(tokenString, role)
+		if err == nil {
+			c.Set(claimsKey, claims)
 
-		return emptyClaims
+			return claims, nil
+		}
 	}
 
-	jwtClaims, _ := c.Get("JWT_PAYLOAD")
+	abortUnauthorized(c, err, logMsg)
 
-	return jwtClaims.(jwt.MapClaims)
+	return nil, err
 }
 
-// 解析token
-func parseToken(c *gin.Context, key string) (*jwt.Token, error) {
-	var token string
-	var err error
-
-	parts := strings.Split("header:Authorization", ":")
-	token, err = jwtFromHeader(c, parts[1])
+// 取出 token 对应的管理员, 管理员被删除后 token 不能继续使用
+func loadAdmin(c *gin.Context, claims *token.Claims, logMsg string) (*models.Admin, error) {
+	id, err := strconv.ParseUint(claims.Subject, 10, 32)
 	if err != nil {
-		return nil, err
-	}
-
-	// 查询 redis 里是否有这个token
-	rToken, err := models.RedisClient.Get(key + "_token").Result()
-	if err != nil {
-		log.WithFields(log.Fields{
-			"errorMsg": err,
-		}).Info("Admin auth failed")
+		abortUnauthorized(c, errors.New("You don't have permission to access"), logMsg)
 
-		return nil, errors.New("token is expired")
+		return nil, errors.New("Incorrect token")
 	}
 
-	// 如果 redis 里token为空值
-	if rToken == "" {
-		return nil, errors.New("You don't login in or token expired")
-	}
+	// 从数据取出管理员信息
+	admin, err := models.AdminByID(uint(id))
+	if err == sql.ErrNoRows {
+		abortUnauthorized(c, errors.New("Admin not found"), logMsg)
 
-	// 比对token
-	if token != rToken {
-		return nil, errors.New("Token is not match")
+		return nil, err
 	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"statusCode": http.StatusInternalServerError,
+			"message":    http.StatusText(http.StatusInternalServerError),
+		})
+		c.AbortWithStatus(http.StatusInternalServerError)
+		log.WithFields(log.Fields{
+			"errorMsg":   "admin info query failed",
+			"statusCode": http.StatusInternalServerError,
+		}).Info(logMsg)
 
-	return jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
-		if jwt.GetSigningMethod(SigningAlgorithm) != token.Method {
-			err := errors.New("Invalid signing algorithm")
+		return nil, err
+	}
 
-			return nil, err
-		}
+	c.Set(adminKey, admin)
 
-		return secretKey, nil
-	})
+	return admin, nil
 }
 
-// 从请求头提取 token
-func jwtFromHeader(c *gin.Context, key string) (string, error) {
-	authHeader := c.Request.Header.Get(key)
-	log.Info(authHeader)
-
-	// 如果请求头 Authorization 部分为空
-	if authHeader == "" {
-		err := errors.New("Auth header empty")
+// 取出 token 对应的用户, 黑名单用户不能继续使用 token
+func loadUser(c *gin.Context, claims *token.Claims, logMsg string) (*models.User, error) {
+	user, err := models.GetUserByUserID(claims.Subject)
+	if err != nil {
+		abortUnauthorized(c, errors.New("User not found"), logMsg)
 
-		return "", err
+		return nil, err
 	}
 
-	// 要求使用 Bearer Token
-	parts := strings.SplitN(authHeader, " ", 2)
-
-	if !(len(parts) == 2 && parts[0] == "Bearer") {
-		err := errors.New("Invalid auth header")
+	if user.IsBanned() {
+		c.JSON(http.StatusForbidden, gin.H{
+			"statusCode":    http.StatusForbidden,
+			"message":       "User is in blacklist",
+			"ban_reason":    user.BanReason,
+			"ban_expire_at": user.BanExpireAt,
+		})
+		c.AbortWithStatus(http.StatusForbidden)
+		log.WithFields(log.Fields{
+			"userID":     user.UserID,
+			"statusCode": http.StatusForbidden,
+		}).Info(logMsg)
 
-		return "", err
+		return nil, errors.New("User is in blacklist")
 	}
 
-	return parts[1], nil
+	c.Set(userKey, user)
+
+	return user, nil
+}
+
+func abortUnauthorized(c *gin.Context, err error, logMsg string) {
+	c.Header("WWW-Authenticate", "JWT realm=gin jwt")
+	c.JSON(http.StatusUnauthorized, gin.H{
+		"statusCode": http.StatusUnauthorized,
+		"message":    err.Error(),
+	})
+	c.AbortWithStatus(http.StatusUnauthorized)
+	log.WithFields(log.Fields{
+		"errorMsg":   err,
+		"statusCode": http.StatusUnauthorized,
+	}).Info(logMsg)
 }
@@ -0,0 +1,84 @@
+package middlewares
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	log "github.com/sirupsen/logrus"
+	"github.com/ztplz/blog-server/models"
+	"github.com/ztplz/blog-server/token"
+)
+
+// PermissionMiddleware 后台权限中间件, 管理员可以访问所有路由,
+// 用户的角色需要拥有 permissions 里的任意一个权限。认证通过后可以用 CurrentStaff 获取用户
+func PermissionMiddleware(permissions ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		err := authorize(c, permissions)
+		if err != nil {
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// HasPermission 当前认证通过的管理员或者用户是否拥有某个权限
+func HasPermission(c *gin.Context, permission string) bool {
+	if _, ok := c.Get(adminKey); ok {
+		return true
+	}
+
+	user := CurrentStaff(c)
+
+	return user != nil && models.HasPermission(user.Role, permission)
+}
+
+// CurrentStaff 获取 PermissionMiddleware 认证通过的用户, 管理员操作时返回 nil
+func CurrentStaff(c *gin.Context) *models.User {
+	user, exists := c.Get(userKey)
+	if !exists {
+		return nil
+	}
+
+	return user.(*models.User)
+}
+
+func authorize(c *gin.Context, permissions []string) error {
+	claims, err := authenticate(c, "", "Permission auth failed")
+	if err != nil {
+		return err
+	}
+
+	if claims.Role == token.RoleAdmin {
+		_, err = loadAdmin(c, claims, "Permission auth failed")
+
+		return err
+	}
+
+	user, err := loadUser(c, claims, "Permission auth failed")
+	if err != nil {
+		return err
+	}
+
+	for _, permission := range permissions {
+		if models.HasPermission(user.Role, permission) {
+			return nil
+		}
+	}
+
+	c.JSON(http.StatusForbidden, gin.H{
+		"statusCode": http.StatusForbidden,
+		"message":    "You don't have permission to access",
+	})
+	c.AbortWithStatus(http.StatusForbidden)
+	log.WithFields(log.Fields{
+		"userID":      user.UserID,
+		"role":        user.Role,
+		"permissions": permissions,
+		"path":        c.Request.URL.Path,
+		"statusCode":  http.StatusForbidden,
+	}).Info("Permission auth failed")
+
+	return errors.New("Permission denied")
+}
@@ -0,0 +1,45 @@
+package middlewares
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequestIDHeader 请求 id 的请求头和响应头
+const RequestIDHeader = "X-Request-ID"
+
+const requestIDKey = "request_id"
+
+// 客户端或者反向代理传来的请求 id 只接受字母、数字、- 和 _
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// RequestIDMiddleware 给每个请求分配 id, 请求头里有合法的 X-Request-ID 时沿用, 否则随机生成,
+// 并在响应头里返回, 方便和日志、审计日志对应
+func RequestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(RequestIDHeader)
+		if !requestIDPattern.MatchString(requestID) {
+			requestID = newRequestID()
+		}
+
+		c.Set(requestIDKey, requestID)
+		c.Header(RequestIDHeader, requestID)
+
+		c.Next()
+	}
+}
+
+// RequestID 获取当前请求的 id
+func RequestID(c *gin.Context) string {
+	return c.GetString(requestIDKey)
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
@@ -1,6 +1,9 @@
 package models
 
 import (
+	"database/sql"
+	"errors"
+
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -15,16 +18,26 @@ const (
 
 //sql查询语句
 const (
-	qCreateInitAdmin = "INSERT INTO admin (admin_id, password, admin_name, image, last_login_at, ip) VALUES (?, ?, ?, ?, ?, ?)"
-	qAdminByAdminID  = "SELECT id, admin_id, password, admin_name, image, last_login_at FROM admin WHERE admin_id=?"
-	qAdminByID       = "SELECT id, admin_id, password, admin_name, image, last_login_at, ip FROM admin WHERE id=?"
+	adminColumns = "id, admin_id, password, admin_name, image, last_login_at, ip, totp_secret, totp_enabled"
+
+	// 没有管理员时才创建初始管理员, 多个实例同时启动也只会创建一个
+	qCreateInitAdmin = "INSERT INTO admin (admin_id, password, admin_name, image, last_login_at, ip) SELECT ?, ?, ?, ?, ?, ? FROM DUAL WHERE NOT EXISTS (SELECT 1 FROM admin)"
+	qInsertAdmin     = "INSERT INTO admin (admin_id, password, admin_name, image, last_login_at, ip) VALUES (?, ?, ?, ?, ?, ?)"
+	qAdminByAdminID  = "SELECT " + adminColumns + " FROM admin WHERE admin_id = ?"
+	qAdminByID       = "SELECT " + adminColumns + " FROM admin WHERE id = ?"
+	qAllAdmin        = "SELECT " + adminColumns + " FROM admin ORDER BY id ASC"
+	qLockAdminCount  = "SELECT COUNT(*) FROM admin FOR UPDATE"
+	qDeleteAdmin     = "DELETE FROM admin WHERE id = ?"
 	// qAdminByID = "SELECT id, admin_id, password, admin_name, email, image FROM admin WHERE id = ?"
 	// qAll = "SELECT * FROM admin"
-	qUpdateLastLoginAt   = "UPDATE admin SET last_login_at = ?, ip = ? WHERE id = 1"
-	qUpdateAdminPassword = "UPDATE admin SET password = ? WHERE id = 1"
-	qUpdateAdminInfo     = "UPDATE admin SET admin_id = ?, admin_name = ?, image = ? WHERE id = 1"
+	qUpdateLastLoginAt   = "UPDATE admin SET last_login_at = ?, ip = ? WHERE id = ?"
+	qUpdateAdminPassword = "UPDATE admin SET password = ? WHERE id = ?"
+	qUpdateAdminInfo     = "UPDATE admin SET admin_id = ?, admin_name = ?, image = ? WHERE id = ?"
 )
 
+// ErrLastAdmin 不能删除最后一个管理员
+var ErrLastAdmin = errors.New("Can't delete the last admin")
+
 // LoginAdminForm 后台登录账号密码表单结构
 // type LoginAdminForm struct {
 // 	AdminID  string `json:"admin_id"`
@@ -35,14 +48,16 @@ const (
 type Admin struct {
 	ID          uint   `db:"id" json:"id"`
 	AdminID     string `db:"admin_id" json:"admin_id"`
-	Password    string `db:"password" json:"password"`
+	Password    string `db:"password" json:"-"`
 	AdminName   string `db:"admin_name" json:"admin_name"`
 	Image       string `db:"image" json:"image"`
 	LastLoginAt string `db:"last_login_at" json:"last_login_at"`
 	IP          string `db:"ip" json:"ip"`
+	TOTPSecret  string `db:"totp_secret" json:"-"`
+	TOTPEnabled bool   `db:"totp_enabled" json:"totp_enabled"`
 }
 
-// InitialAdmin 初始化管理员账号
+// InitialAdmin 初始化管理员账号, 数据库里已经有管理员时不做任何事
 func InitialAdmin() {
 	// 加密初始管理员密码
 	hp, err := bcrypt.GenerateFromPassword([]byte("admin"), bcrypt.DefaultCost)
@@ -61,35 +76,134 @@ func InitialAdmin() {
 	}
 	defer stmt.Close()
 
-	_, err = stmt.Exec("admin", string(hp), "admin", "", "", "")
+	// 多个实例同时启动时, 后插入的会因为 admin_id 唯一索引返回重复错误, 表示已经创建过
+	res, err := stmt.Exec("admin", string(hp), "admin", "", "", "")
+	if isDuplicateKey(err) {
+		log.Info("Admin already exist, skip creating initial admin")
+
+		return
+	}
 	if err != nil {
 		log.WithFields(log.Fields{
 			"errorMsg": err,
 		}).Fatal("Insert initial password to database failed")
 	}
 
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		log.Info("Admin already exist, skip creating initial admin")
+
+		return
+	}
+
 	log.Info("Create initial password success")
 }
 
-// AdminByID 从数据库根据 ID 查询管理员信息
-func AdminByID() (*Admin, error) {
-	var a Admin
+// AdminByID 从数据库根据 ID 查询管理员信息, 不存在时返回 sql.ErrNoRows
+func AdminByID(id uint) (*Admin, error) {
+	return queryAdmin(qAdminByID, id)
+}
 
-	// 根据管理员ID查询管理员信息
-	err := DB.QueryRow(qAdminByID, 1).Scan(&a.ID, &a.AdminID, &a.Password, &a.AdminName, &a.Image, &a.LastLoginAt, &a.IP)
+// AdminByAdminID 根据登录用的管理员 ID 查询管理员信息, 不存在时返回 sql.ErrNoRows
+func AdminByAdminID(adminID string) (*Admin, error) {
+	return queryAdmin(qAdminByAdminID, adminID)
+}
+
+// GetAllAdmin 获取所有管理员, 按创建先后排列
+func GetAllAdmin() ([]*Admin, error) {
+	rows, err := DB.Query(qAllAdmin)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"errorMsg": err,
-		}).Info("Query admin infomation failed")
+		}).Info("Query all admin failed")
 
-		return &a, err
+		return nil, err
 	}
+	defer rows.Close()
 
-	return &a, nil
+	admins := []*Admin{}
+	for rows.Next() {
+		a := &Admin{}
+		err = scanAdmin(rows, a)
+		if err != nil {
+			return nil, err
+		}
+
+		admins = append(admins, a)
+	}
+
+	return admins, rows.Err()
+}
+
+// AddAdmin 新增管理员, 返回新管理员的 id
+func AddAdmin(adminID string, password string, adminName string) (int64, error) {
+	hp, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := DB.Exec(qInsertAdmin, adminID, string(hp), adminName, "", "", "")
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+			"adminID":  adminID,
+		}).Info("Insert admin failed")
+
+		return 0, err
+	}
+
+	return res.LastInsertId()
+}
+
+// DeleteAdmin 删除管理员和他的恢复码, 只剩一个管理员时返回 ErrLastAdmin, 不存在时返回 sql.ErrNoRows
+func DeleteAdmin(id uint) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+		}).Info("Begin transaction failed")
+
+		return err
+	}
+	defer tx.Rollback()
+
+	var count int64
+	err = tx.QueryRow(qLockAdminCount).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count <= 1 {
+		return ErrLastAdmin
+	}
+
+	res, err := tx.Exec(qDeleteAdmin, id)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+			"id":       id,
+		}).Info("Delete admin failed")
+
+		return err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	_, err = tx.Exec(qDeleteRecoveryCodes, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 // UpdateTimeIP 存储管理员登录时间
-func UpdateTimeIP(lastLoginAt string, ip string) error {
+func UpdateTimeIP(id uint, lastLoginAt string, ip string) error {
 	// sql预处理
 	stmt, err := DB.Prepare(qUpdateLastLoginAt)
 	if err != nil {
@@ -103,7 +217,7 @@ func UpdateTimeIP(lastLoginAt string, ip string) error {
 	}
 
 	// sql执行
-	_, err = stmt.Exec(lastLoginAt, ip)
+	_, err = stmt.Exec(lastLoginAt, ip, id)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"errorMsg":      err,
@@ -118,7 +232,7 @@ func UpdateTimeIP(lastLoginAt string, ip string) error {
 }
 
 // UpdateAdminPassword 数据库更改管理员密码
-func UpdateAdminPassword(password string) error {
+func UpdateAdminPassword(id uint, password string) error {
 	// 加密密码
 	hp, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
@@ -140,7 +254,7 @@ func UpdateAdminPassword(password string) error {
 	}
 
 	// sql执行
-	_, err = stmt.Exec(hp)
+	_, err = stmt.Exec(hp, id)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"errorMsg": err,
@@ -153,7 +267,7 @@ func UpdateAdminPassword(password string) error {
 }
 
 // UpdateAdminInfo 更改管理员信息
-func UpdateAdminInfo(adminID, adminName, image string) error {
+func UpdateAdminInfo(id uint, adminID, adminName, image string) error {
 	// sql预处理
 	stmt, err := DB.Prepare(qUpdateAdminInfo)
 	if err != nil {
@@ -165,7 +279,7 @@ func UpdateAdminInfo(adminID, adminName, image string) error {
 	}
 
 	// sql执行
-	_, err = stmt.Exec(adminID, adminName, image)
+	_, err = stmt.Exec(adminID, adminName, image, id)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"errorMsg": err,
@@ -177,6 +291,26 @@ func UpdateAdminInfo(adminID, adminName, image string) error {
 	return nil
 }
 
+func queryAdmin(query string, arg interface{}) (*Admin, error) {
+	var a Admin
+
+	err := scanAdmin(DB.QueryRow(query, arg), &a)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+		}).Info("Query admin infomation failed")
+
+		return nil, err
+	}
+
+	return &a, nil
+}
+
+// scanAdmin 按 adminColumns 的顺序读取一行管理员信息
+func scanAdmin(row rowScanner, a *Admin) error {
+	return row.Scan(&a.ID, &a.AdminID, &a.Password, &a.AdminName, &a.Image, &a.LastLoginAt, &a.IP, &a.TOTPSecret, &a.TOTPEnabled)
+}
+
 // MarshalJSON 序列化
 // func (a *Admin) MarshalJSON() ([]byte, error) {
 // 	type admin Admin
@@ -0,0 +1,214 @@
+/*
+* 管理员两步验证
+*
+* 启用前新密钥先放在 redis 里, 管理员用验证器应用确认一次验证码后才写入数据库。
+* 恢复码只保存 sha256, 每个只能使用一次。用过的 TOTP 周期记录在 redis 里防止重放
+ */
+
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis"
+	log "github.com/sirupsen/logrus"
+)
+
+// 恢复码的数量和长度
+const (
+	RecoveryCodeCount = 10
+	recoveryCodeSize  = 5
+)
+
+const (
+	qUpdateAdminTOTP     = "UPDATE admin SET totp_secret = ?, totp_enabled = ? WHERE id = ?"
+	qDeleteRecoveryCodes = "DELETE FROM admin_recovery_code WHERE admin_id = ?"
+	qInsertRecoveryCode  = "INSERT INTO admin_recovery_code (admin_id, code_hash, create_at) VALUES (?, ?, ?)"
+	qUseRecoveryCode     = "UPDATE admin_recovery_code SET used_at = ? WHERE admin_id = ? AND code_hash = ? AND used_at IS NULL"
+	qCountRecoveryCodes  = "SELECT COUNT(*) FROM admin_recovery_code WHERE admin_id = ? AND used_at IS NULL"
+)
+
+// SetAdminPendingTOTP 保存还没有确认的密钥, ttl 后过期
+func SetAdminPendingTOTP(id uint, secret string, ttl time.Duration) error {
+	return RedisClient.Set(adminTOTPKey(id, "pending"), secret, ttl).Err()
+}
+
+// GetAdminPendingTOTP 获取还没有确认的密钥, 不存在时返回空字符串
+func GetAdminPendingTOTP(id uint) (string, error) {
+	secret, err := RedisClient.Get(adminTOTPKey(id, "pending")).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+
+	return secret, err
+}
+
+// EnableAdminTOTP 启用两步验证, 同时删除还没有确认的密钥
+func EnableAdminTOTP(id uint, secret string) error {
+	_, err := DB.Exec(qUpdateAdminTOTP, secret, true, id)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+		}).Info("Enable admin totp failed")
+
+		return err
+	}
+
+	RedisClient.Del(adminTOTPKey(id, "pending"))
+
+	return nil
+}
+
+// DisableAdminTOTP 关闭两步验证, 同时删除所有恢复码
+func DisableAdminTOTP(id uint) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(qUpdateAdminTOTP, "", false, id)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+		}).Info("Disable admin totp failed")
+
+		return err
+	}
+
+	_, err = tx.Exec(qDeleteRecoveryCodes, id)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+		}).Info("Delete admin recovery codes failed")
+
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// MarkAdminTOTPStep 记录用过的 TOTP 周期, 同一个周期已经用过时返回 false
+func MarkAdminTOTPStep(id uint, step int64, ttl time.Duration) (bool, error) {
+	key := adminTOTPKey(id, "step_"+strconv.FormatInt(step, 10))
+
+	return RedisClient.SetNX(key, 1, ttl).Result()
+}
+
+// NewRecoveryCodes 生成新的恢复码, 之前的恢复码全部失效, 返回明文只展示一次
+func NewRecoveryCodes(id uint) ([]string, error) {
+	codes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, recoveryCodeSize*2)
+		_, err := rand.Read(b)
+		if err != nil {
+			return nil, err
+		}
+
+		s := hex.EncodeToString(b)
+		codes[i] = s[:recoveryCodeSize*2] + "-" + s[recoveryCodeSize*2:]
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(qDeleteRecoveryCodes, id)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+		}).Info("Delete admin recovery codes failed")
+
+		return nil, err
+	}
+
+	now := time.Now().Format("2006-01-02 15:04:05")
+	for _, code := range codes {
+		_, err = tx.Exec(qInsertRecoveryCode, id, hashRecoveryCode(code), now)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"errorMsg": err,
+			}).Info("Insert admin recovery code failed")
+
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// UseRecoveryCode 使用恢复码, 不存在或者已经用过时返回 false
+func UseRecoveryCode(id uint, code string) (bool, error) {
+	now := time.Now().Format("2006-01-02 15:04:05")
+	result, err := DB.Exec(qUseRecoveryCode, now, id, hashRecoveryCode(code))
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+		}).Info("Use admin recovery code failed")
+
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n == 1, nil
+}
+
+// CountRecoveryCodes 剩余可用的恢复码数量
+func CountRecoveryCodes(id uint) (int64, error) {
+	var count int64
+
+	err := DB.QueryRow(qCountRecoveryCodes, id).Scan(&count)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+		}).Info("Count admin recovery codes failed")
+	}
+
+	return count, err
+}
+
+// SetAdminStepUp 记录管理员刚刚通过了两步验证, ttl 内可以进行敏感操作
+func SetAdminStepUp(id uint, ttl time.Duration) error {
+	return RedisClient.Set(adminTOTPKey(id, "step_up"), 1, ttl).Err()
+}
+
+// CheckAdminStepUp 检查管理员是否在有效时间内通过了两步验证
+func CheckAdminStepUp(id uint) (bool, error) {
+	n, err := RedisClient.Exists(adminTOTPKey(id, "step_up")).Result()
+
+	return n == 1, err
+}
+
+// ClearAdminStepUp 清除两步验证记录, 退出登录时使用
+func ClearAdminStepUp(id uint) error {
+	return RedisClient.Del(adminTOTPKey(id, "step_up")).Err()
+}
+
+// 忽略大小写、空格和连字符
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	code = strings.Replace(code, "-", "", -1)
+	sum := sha256.Sum256([]byte(code))
+
+	return hex.EncodeToString(sum[:])
+}
+
+func adminTOTPKey(id uint, name string) string {
+	return "admin_totp_" + strconv.FormatUint(uint64(id), 10) + "_" + name
+}
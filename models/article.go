@@ -1,6 +1,9 @@
 package models
 
 import (
+	"database/sql"
+	"time"
+
 	log "github.com/sirupsen/logrus"
 )
 
@@ -11,13 +14,55 @@ const (
 	// ArticlePreviewTextLengthMax 文章预览最多字符数
 	ArticlePreviewTextLengthMax = 600
 
-	qGetArticleCount  = "SELECT COUNT(*) as count FROM article"
-	qGetAllArticle    = "SELECT * FROM article"
-	qAddArticle       = "INSERT INTO article (create_at, update_at, visit_count, reply_count, article_title, article_previewtext, article_content, top, category, tag_list) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
-	qGetArticleByPage = `SELECT id, create_at, update_at, visit_count, reply_count, article_title, article_previewtext, article_content, top, category, tag_list 
-						FROM article 
-							ORDER BY id DESC LIMIT ?, ?`
-	qGetArticleByID = "SELECT id, create_at, update_at, visit_count, reply_count, article_title, article_previewtext, article_content, top, category, tag_list FROM article WHERE id = ?"
+	// ArticleStatusDraft 草稿, 只有管理员可见
+	ArticleStatusDraft = "draft"
+
+	// ArticleStatusPublished 已发布
+	ArticleStatusPublished = "published"
+
+	// ArticleStatusScheduled 定时发布, 到 publish_at 时由定时任务发布
+	ArticleStatusScheduled = "scheduled"
+
+	// ArticleStatusPrivate 私密博文, 只有管理员可见
+	ArticleStatusPrivate = "private"
+
+	// articleColumns 查询博文时的字段顺序, 和 scanArticle 保持一致
+	articleColumns = "id, create_at, update_at, visit_count, reply_count, article_title, slug, article_previewtext, article_content, article_html, article_toc, top, category, status, publish_at, is_trash, trash_at, author_id"
+
+	// publicArticle 对外公开的博文: 不在回收站且已发布
+	publicArticle = "is_trash = 0 AND status = '" + ArticleStatusPublished + "'"
+
+	// ArticleSortCreated 按发表时间从新到旧排序
+	ArticleSortCreated = "created"
+
+	// ArticleSortUpdated 按修改时间从新到旧排序
+	ArticleSortUpdated = "updated"
+
+	// ArticleSortVisits 按访问量从多到少排序
+	ArticleSortVisits = "visits"
+
+	qGetArticleCount     = "SELECT COUNT(*) as count FROM article WHERE "
+	qGetAllArticle       = "SELECT " + articleColumns + " FROM article WHERE " + publicArticle + " ORDER BY id ASC"
+	qAddArticle          = "INSERT INTO article (create_at, update_at, visit_count, reply_count, article_title, slug, article_previewtext, article_content, article_html, article_toc, top, category, status, publish_at, author_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+	qGetArticleByPage    = "SELECT " + articleColumns + " FROM article WHERE "
+	qGetArticleByID      = "SELECT " + articleColumns + " FROM article WHERE id = ? AND " + publicArticle
+	qGetAnyArticleByID   = "SELECT " + articleColumns + " FROM article WHERE id = ? AND is_trash = 0"
+	qGetTrashArticleByID = "SELECT " + articleColumns + " FROM article WHERE id = ? AND is_trash = 1"
+	qUpdateArticle       = `UPDATE article
+						SET update_at = ?, article_title = ?, slug = ?, article_previewtext = ?, article_content = ?, article_html = ?, article_toc = ?, top = ?, category = ?, status = ?, publish_at = ?
+							WHERE id = ? AND is_trash = 0`
+	qPublishScheduledArticle = "UPDATE article SET status = '" + ArticleStatusPublished + "' WHERE status = '" + ArticleStatusScheduled + "' AND publish_at <= ? AND is_trash = 0"
+	qTrashArticle            = "UPDATE article SET is_trash = 1, trash_at = ? WHERE id = ? AND is_trash = 0"
+	qRestoreArticle          = "UPDATE article SET is_trash = 0, trash_at = '' WHERE id = ? AND is_trash = 1"
+	qLockTrashArticle        = "SELECT id FROM article WHERE id = ? AND is_trash = 1 FOR UPDATE"
+	qLockAllTrashArticle     = "SELECT id FROM article WHERE is_trash = 1 FOR UPDATE"
+	qPurgeArticleTags        = "DELETE FROM article_tag WHERE article_id = ?"
+	qPurgeArticleSlugs       = "DELETE FROM article_slug WHERE article_id = ?"
+	qPurgeArticleRevisions   = "DELETE FROM article_revision WHERE article_id = ?"
+	qPurgeArticleComments    = "DELETE FROM comment WHERE article_id = ?"
+	qPurgeArticle            = "DELETE FROM article WHERE id = ?"
+	qGetTrashArticle         = "SELECT " + articleColumns + " FROM article WHERE is_trash = 1 ORDER BY trash_at DESC"
+	qUpdateArticleAuthor     = "UPDATE article SET author_id = ? WHERE author_id = ?"
 )
 
 // Article 文章的数据结构
@@ -28,28 +73,208 @@ type Article struct {
 	VisitCount         uint   `db:"visit_count" json:"visit_count"`
 	ReplyCount         uint   `db:"reply._count" json:"reply_count"`
 	ArticleTitle       string `db:"article_title" json:"article_title"`
+	Slug               string `db:"slug" json:"slug"`
 	ArticlePreviewText string `db:"article_previewtext" json:"article_previewtext"`
 	ArticleContent     string `db:"article_content" json:"article_content"`
+	ArticleHTML        string `db:"article_html" json:"article_html"`
+	ArticleTOC         string `db:"article_toc" json:"article_toc"`
 	Top                bool   `db:"top" json:"top"`
 	Category           uint   `db:"category" json:"category"`
-	TagList            string `db:"tag_list" json:"tag_list"`
+	Tags               []uint `db:"-" json:"tags"`
+	Status             string `db:"status" json:"status"`
+	PublishAt          string `db:"publish_at" json:"publish_at"`
+	IsTrash            bool   `db:"is_trash" json:"is_trash"`
+	TrashAt            string `db:"trash_at" json:"trash_at"`
+	AuthorID           string `db:"author_id" json:"author_id"`
+}
+
+// ArticleFilter 博文列表的过滤条件, 零值表示不过滤
+// From 和 To 为 2006-01-02 格式的日期, 按发表日期过滤, 包括当天
+// Admin 为 true 时是后台列表, 包括草稿、定时和私密博文, 可以再按 Status 和 AuthorID 过滤
+type ArticleFilter struct {
+	Category uint
+	Tag      uint
+	From     string
+	To       string
+	Top      bool
+	Sort     string
+	Admin    bool
+	Status   string
+	AuthorID string
+}
+
+// Match 判断博文是否满足过滤条件, 用于过滤 redis 里的博文
+func (f *ArticleFilter) Match(article *Article) bool {
+	if f.Category != 0 && article.Category != f.Category {
+		return false
+	}
+
+	if f.Tag != 0 && !articleHasTag(article, f.Tag) {
+		return false
+	}
+
+	if f.Top && !article.Top {
+		return false
+	}
+
+	// create_at 以日期开头, 可以直接按字符串比较
+	date := article.CreateAt
+	if len(date) > len("2006-01-02") {
+		date = date[:len("2006-01-02")]
+	}
+
+	if f.From != "" && date < f.From {
+		return false
+	}
+
+	if f.To != "" && date > f.To {
+		return false
+	}
+
+	return true
+}
+
+// Less 按排序方式判断博文 a 是否排在 b 前面, 和 mysql 的排序保持一致
+func (f *ArticleFilter) Less(a *Article, b *Article) bool {
+	switch f.Sort {
+	case ArticleSortUpdated:
+		if a.UpdateAt != b.UpdateAt {
+			return a.UpdateAt > b.UpdateAt
+		}
+	case ArticleSortVisits:
+		if a.VisitCount != b.VisitCount {
+			return a.VisitCount > b.VisitCount
+		}
+	}
+
+	return a.ID > b.ID
+}
+
+// 生成过滤条件对应的 where 子句和参数
+func (f *ArticleFilter) where() (string, []interface{}) {
+	where := publicArticle
+	var args []interface{}
+
+	if f.Admin {
+		where = "is_trash = 0"
+
+		if f.Status != "" {
+			where += " AND status = ?"
+			args = append(args, f.Status)
+		}
+
+		if f.AuthorID != "" {
+			where += " AND author_id = ?"
+			args = append(args, f.AuthorID)
+		}
+	}
+
+	if f.Category != 0 {
+		where += " AND category = ?"
+		args = append(args, f.Category)
+	}
+
+	if f.Tag != 0 {
+		where += " AND id IN (SELECT article_id FROM article_tag WHERE tag_id = ?)"
+		args = append(args, f.Tag)
+	}
+
+	if f.Top {
+		where += " AND top = 1"
+	}
+
+	if f.From != "" {
+		where += " AND create_at >= ?"
+		args = append(args, f.From)
+	}
+
+	if f.To != "" {
+		where += " AND create_at < DATE_ADD(?, INTERVAL 1 DAY)"
+		args = append(args, f.To)
+	}
+
+	return where, args
+}
+
+// 排序方式对应的 order by 子句
+func (f *ArticleFilter) orderBy() string {
+	switch f.Sort {
+	case ArticleSortUpdated:
+		return " ORDER BY update_at DESC, id DESC"
+	case ArticleSortVisits:
+		return " ORDER BY visit_count DESC, id DESC"
+	default:
+		return " ORDER BY id DESC"
+	}
+}
+
+// 判断博文是否有某个标签
+func articleHasTag(article *Article, tag uint) bool {
+	for _, id := range article.Tags {
+		if id == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// rowScanner sql.Row 和 sql.Rows 共有的 Scan 方法
+type rowScanner interface {
+	Scan(dest ...interface{}) error
 }
 
-// AddArticle 增加文章
+// scanArticle 按 articleColumns 的顺序读取一行博文
+func scanArticle(row rowScanner, article *Article) error {
+	return row.Scan(
+		&article.ID,
+		&article.CreateAt,
+		&article.UpdateAt,
+		&article.VisitCount,
+		&article.ReplyCount,
+		&article.ArticleTitle,
+		&article.Slug,
+		&article.ArticlePreviewText,
+		&article.ArticleContent,
+		&article.ArticleHTML,
+		&article.ArticleTOC,
+		&article.Top,
+		&article.Category,
+		&article.Status,
+		&article.PublishAt,
+		&article.IsTrash,
+		&article.TrashAt,
+		&article.AuthorID)
+}
+
+// AddArticle 增加文章, 博文和标签在同一个事务里保存
+// 别的博文同时用了相同的 slug 时, 重新生成 slug 再保存
 func AddArticle(article *Article) (int64, error) {
-	stmt, err := DB.Prepare(qAddArticle)
+	id, err := addArticle(article)
+	for i := 0; i < slugRetryMax && isDuplicateKey(err); i++ {
+		article.Slug, err = UniqueSlug(article.ArticleTitle, 0)
+		if err == nil {
+			id, err = addArticle(article)
+		}
+	}
+
+	return id, err
+}
+
+func addArticle(article *Article) (int64, error) {
+	tx, err := DB.Begin()
 	if err != nil {
 		log.WithFields(log.Fields{
 			"errorMsg": err,
 			"article":  *article,
-		}).Info("Sql prepare failed")
+		}).Info("Begin transaction failed")
 
 		return 0, err
 	}
-	defer stmt.Close()
 
-	res, err := stmt.Exec(article.CreateAt, article.UpdateAt, article.VisitCount, article.ReplyCount, article.ArticleTitle, article.ArticlePreviewText, article.ArticleContent, article.Top, article.Category, article.TagList)
+	res, err := tx.Exec(qAddArticle, article.CreateAt, article.UpdateAt, article.VisitCount, article.ReplyCount, article.ArticleTitle, article.Slug, article.ArticlePreviewText, article.ArticleContent, article.ArticleHTML, article.ArticleTOC, article.Top, article.Category, article.Status, article.PublishAt, article.AuthorID)
 	if err != nil {
+		tx.Rollback()
 		log.WithFields(log.Fields{
 			"errorMsg": err,
 			"article":  *article,
@@ -59,6 +284,7 @@ func AddArticle(article *Article) (int64, error) {
 
 	lastID, err := res.LastInsertId()
 	if err != nil {
+		tx.Rollback()
 		log.WithFields(log.Fields{
 			"errorMsg": err,
 			"article":  *article,
@@ -67,15 +293,45 @@ func AddArticle(article *Article) (int64, error) {
 		return 0, err
 	}
 
+	err = setArticleTags(tx, uint(lastID), article.Tags)
+	if err != nil {
+		tx.Rollback()
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+			"article":  *article,
+		}).Info("Save article tags failed")
+
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
 	return lastID, nil
 }
 
-// GetArticleByID 根据 id 查询
+// GetArticleByID 根据 id 查询已发布的博文
 func GetArticleByID(id uint64) (*Article, error) {
+	return getArticle(qGetArticleByID, id)
+}
+
+// GetAnyArticleByID 根据 id 查询博文, 包括草稿、定时和私密博文, 供后台使用
+func GetAnyArticleByID(id uint64) (*Article, error) {
+	return getArticle(qGetAnyArticleByID, id)
+}
+
+// GetTrashArticleByID 根据 id 查询回收站里的博文
+func GetTrashArticleByID(id uint64) (*Article, error) {
+	return getArticle(qGetTrashArticleByID, id)
+}
+
+func getArticle(query string, id uint64) (*Article, error) {
 	var article Article
 
-	row := DB.QueryRow(qGetArticleByID, uint(id))
-	err := row.Scan(&article.ID, &article.CreateAt, &article.UpdateAt, &article.VisitCount, &article.ReplyCount, &article.ArticleTitle, &article.ArticlePreviewText, &article.ArticleContent, &article.Top, &article.Category, &article.TagList)
+	row := DB.QueryRow(query, uint(id))
+	err := scanArticle(row, &article)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"errorMsg": err,
@@ -84,57 +340,80 @@ func GetArticleByID(id uint64) (*Article, error) {
 		return nil, err
 	}
 
-	return &article, nil
+	return loadOneArticleTags(&article)
 }
 
-// GetArticleByPage 分页查询
-func GetArticleByPage(limit int64, page int64) (*[]Article, error) {
+// GetArticleByPage 按过滤条件分页查询, 同时返回满足条件的博文总数
+func GetArticleByPage(filter *ArticleFilter, limit int64, page int64) (*[]Article, int64, error) {
 	var article Article
-	var articles []Article
+	articles := []Article{}
 
-	// 查询区间
-	crows, err := DB.Query(qGetArticleCount)
+	where, args := filter.where()
+
+	// 满足条件的博文总数
+	var counts int64
+	err := DB.QueryRow(qGetArticleCount+where, args...).Scan(&counts)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"errorMsg": err,
+			"filter":   *filter,
 		}).Info("Query article count failed")
 
-		return nil, err
+		return nil, 0, err
 	}
-	defer crows.Close()
 
-	var counts int64
+	// 查询区间
+	qoffset := limit * (page - 1)
+	qlimit := limit
 
-	for crows.Next() {
-		err = crows.Scan(&counts)
-	}
+	rows, err := DB.Query(qGetArticleByPage+where+filter.orderBy()+" LIMIT ?, ?", append(args, qoffset, qlimit)...)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"errorMsg": err,
-		}).Info("Scan article count failed")
+			"filter":   *filter,
+			"page":     page,
+			"limit":    limit,
+		}).Info("DB query article failed")
 
-		return nil, err
+		return nil, 0, err
 	}
+	defer rows.Close()
 
-	var qoffset int64
-	var qlimit int64
+	for rows.Next() {
+		// 这里以后优化性能
+		err = scanArticle(rows, &article)
+		articles = append(articles, article)
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+		}).Info("Rows scan failed")
+
+		return nil, 0, err
+	}
 
-	if limit*page > counts {
-		qoffset = limit*(page-1) + 1
-		qlimit = counts - limit*(page-1)
+	err = loadArticleTags(articles)
+	if err != nil {
+		return nil, 0, err
 	}
 
-	qoffset = limit*(page-1) + 1
-	qlimit = limit
+	return &articles, counts, nil
+}
 
-	rows, err := DB.Query(qGetArticleByPage, qoffset, qlimit)
-	// rows, err := DB.Query(qGetArticleByPage, 0, 30)
+// GetAllArticle 取出所有博文
+func GetAllArticle() (*[]Article, error) {
+	var article Article
+	var articles []Article
+
+	// 查询所有博文
+	rows, err := DB.Query(qGetAllArticle)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"errorMsg": err,
-			"page":     page,
-			"limit":    limit,
-		}).Info("DB query article failed")
+		}).Info("DB query all article failed")
 
 		return nil, err
 	}
@@ -142,18 +421,7 @@ func GetArticleByPage(limit int64, page int64) (*[]Article, error) {
 
 	for rows.Next() {
 		// 这里以后优化性能
-		err = rows.Scan(
-			&article.ID,
-			&article.CreateAt,
-			&article.UpdateAt,
-			&article.VisitCount,
-			&article.ReplyCount,
-			&article.ArticleTitle,
-			&article.ArticlePreviewText,
-			&article.ArticleContent,
-			&article.Top,
-			&article.Category,
-			&article.TagList)
+		err = scanArticle(rows, &article)
 		articles = append(articles, article)
 	}
 	err = rows.Err()
@@ -165,39 +433,224 @@ func GetArticleByPage(limit int64, page int64) (*[]Article, error) {
 		return nil, err
 	}
 
+	err = loadArticleTags(articles)
+	if err != nil {
+		return nil, err
+	}
+
 	return &articles, nil
 }
 
-// GetAllArticle 取出所有博文
-func GetAllArticle() (*[]Article, error) {
+// UpdateArticle 修改博文, 回收站里的博文不能修改
+// 修改前把原来的标题、预览、内容、分类和标签保存到 article_revision, slug 改变时记录旧的 slug,
+// 都和修改在同一个事务里。别的博文同时用了相同的 slug 时, 重新生成 slug 再保存
+func UpdateArticle(article *Article, oldSlug string) error {
+	err := updateArticle(article, oldSlug)
+	for i := 0; i < slugRetryMax && article.Slug != oldSlug && isDuplicateKey(err); i++ {
+		article.Slug, err = UniqueSlug(article.ArticleTitle, article.ID)
+		if err == nil {
+			err = updateArticle(article, oldSlug)
+		}
+	}
+
+	return err
+}
+
+func updateArticle(article *Article, oldSlug string) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+			"id":       article.ID,
+		}).Info("Begin transaction failed")
+
+		return err
+	}
+
+	_, err = tx.Exec(qAddRevision, time.Now().Format("2006-01-02 15:04:05"), article.ID)
+	if err != nil {
+		tx.Rollback()
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+			"id":       article.ID,
+		}).Info("Save article revision failed")
+
+		return err
+	}
+
+	_, err = tx.Exec(qUpdateArticle, article.UpdateAt, article.ArticleTitle, article.Slug, article.ArticlePreviewText, article.ArticleContent, article.ArticleHTML, article.ArticleTOC, article.Top, article.Category, article.Status, article.PublishAt, article.ID)
+	if err != nil {
+		tx.Rollback()
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+			"id":       article.ID,
+		}).Info("Update article failed")
+
+		return err
+	}
+
+	err = setArticleTags(tx, article.ID, article.Tags)
+	if err != nil {
+		tx.Rollback()
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+			"id":       article.ID,
+		}).Info("Save article tags failed")
+
+		return err
+	}
+
+	err = setSlugHistory(tx, article.ID, oldSlug, article.Slug)
+	if err != nil {
+		tx.Rollback()
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+			"id":       article.ID,
+		}).Info("Save slug history failed")
+
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// 查询单篇博文的标签
+func loadOneArticleTags(article *Article) (*Article, error) {
+	articles := []Article{*article}
+
+	err := loadArticleTags(articles)
+	if err != nil {
+		return nil, err
+	}
+
+	return &articles[0], nil
+}
+
+// PublishScheduledArticle 发布所有到时间的定时博文, 返回发布的博文数
+func PublishScheduledArticle() (int64, error) {
+	res, err := DB.Exec(qPublishScheduledArticle, time.Now().Format("2006-01-02 15:04:05"))
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+		}).Info("Publish scheduled article failed")
+
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// TrashArticle 把博文移入回收站, 博文不存在时返回 sql.ErrNoRows
+func TrashArticle(id uint64) error {
+	return execArticleByID(qTrashArticle, time.Now().Format("2006-01-02 15:04:05"), id)
+}
+
+// RestoreArticle 从回收站恢复博文, 回收站里没有该博文时返回 sql.ErrNoRows
+func RestoreArticle(id uint64) error {
+	return execArticleByID(qRestoreArticle, id)
+}
+
+// PurgeArticle 从回收站彻底删除博文, 回收站里没有该博文时返回 sql.ErrNoRows
+func PurgeArticle(id uint64) error {
+	count, err := purgeArticles(qLockTrashArticle, id)
+	if err == nil && count == 0 {
+		return sql.ErrNoRows
+	}
+
+	return err
+}
+
+// PurgeAllArticle 清空回收站, 返回删除的博文数
+func PurgeAllArticle() (int64, error) {
+	return purgeArticles(qLockAllTrashArticle)
+}
+
+// 在同一个事务里删除 query 选出的回收站博文, 以及博文的标签、旧 slug、历史版本和评论
+func purgeArticles(query string, args ...interface{}) (int64, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+		}).Info("Begin transaction failed")
+
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+		}).Info("Query trash article failed")
+
+		return 0, err
+	}
+
+	ids := []uint64{}
+	for rows.Next() {
+		var id uint64
+		err = rows.Scan(&id)
+		if err != nil {
+			break
+		}
+
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err == nil {
+		err = rows.Err()
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+		}).Info("Rows scan failed")
+
+		return 0, err
+	}
+
+	for _, id := range ids {
+		for _, purge := range []string{qPurgeArticleTags, qPurgeArticleSlugs, qPurgeArticleRevisions, qPurgeArticleComments, qPurgeArticle} {
+			_, err = tx.Exec(purge, id)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"errorMsg": err,
+					"id":       id,
+				}).Info("Purge article failed")
+
+				return 0, err
+			}
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+		}).Info("Commit transaction failed")
+
+		return 0, err
+	}
+
+	return int64(len(ids)), nil
+}
+
+// GetTrashArticle 取出回收站里的所有博文
+func GetTrashArticle() (*[]Article, error) {
 	var article Article
-	var articles []Article
+	articles := []Article{}
 
-	// 查询所有博文
-	rows, err := DB.Query(qGetAllArticle)
+	rows, err := DB.Query(qGetTrashArticle)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"errorMsg": err,
-		}).Info("DB query all article failed")
+		}).Info("DB query trash article failed")
 
 		return nil, err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		// 这里以后优化性能
-		err = rows.Scan(
-			&article.ID,
-			&article.CreateAt,
-			&article.UpdateAt,
-			&article.VisitCount,
-			&article.ReplyCount,
-			&article.ArticleTitle,
-			&article.ArticlePreviewText,
-			&article.ArticleContent,
-			&article.Top,
-			&article.Category,
-			&article.TagList)
+		err = scanArticle(rows, &article)
 		articles = append(articles, article)
 	}
 	err = rows.Err()
@@ -209,5 +662,45 @@ func GetAllArticle() (*[]Article, error) {
 		return nil, err
 	}
 
+	err = loadArticleTags(articles)
+	if err != nil {
+		return nil, err
+	}
+
 	return &articles, nil
 }
+
+// 执行以博文 id 为最后一个参数的语句, 没有行受影响时返回 sql.ErrNoRows
+func execArticleByID(query string, args ...interface{}) error {
+	stmt, err := DB.Prepare(query)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+			"args":     args,
+		}).Info("Sql prepare failed")
+
+		return err
+	}
+	defer stmt.Close()
+
+	res, err := stmt.Exec(args...)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+			"args":     args,
+		}).Info("Sql exec failed")
+
+		return err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
@@ -0,0 +1,288 @@
+/*
+* 博文标签
+*
+* 博文和标签的关系保存在 article_tag 表里，一篇博文可以有多个标签。
+* 旧版本把标签 id 用 _ 连接后保存在 article.tag_list 里，启动时由 InitialArticleTag 迁移
+ */
+
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	qGetTagCount       = "SELECT COUNT(*) FROM tags WHERE id IN "
+	qGetArticleTags    = "SELECT article_id, tag_id FROM article_tag WHERE article_id IN "
+	qAddArticleTag     = "INSERT INTO article_tag (article_id, tag_id) VALUES (?, ?)"
+	qDeleteArticleTags = "DELETE FROM article_tag WHERE article_id = ?"
+
+	// 迁移旧的 tag_list 字段
+	qHasColumn = `SELECT COUNT(*) FROM information_schema.columns
+						WHERE table_schema = DATABASE() AND table_name = ? AND column_name = ?`
+	qGetArticleTagList      = "SELECT id, tag_list FROM article"
+	qMigrateArticleTag      = "INSERT IGNORE INTO article_tag (article_id, tag_id) SELECT ?, id FROM tags WHERE id = ?"
+	qDropArticleTagList     = "ALTER TABLE article DROP COLUMN tag_list"
+	qMigrateRevisionTagList = "UPDATE article_revision SET tag_list = REPLACE(tag_list, '_', ',')"
+	qRenameRevisionTagList  = "ALTER TABLE article_revision CHANGE tag_list tags varchar(255) NOT NULL DEFAULT ''"
+)
+
+// ErrTagNotFound 提交的标签 id 不存在
+var ErrTagNotFound = errors.New("Tag not found")
+
+// CheckTagsExist 检查标签 id 是否都存在, 有不存在的标签时返回 ErrTagNotFound
+func CheckTagsExist(tags []uint) error {
+	ids := uniqueIDs(tags)
+	if len(ids) == 0 {
+		return nil
+	}
+
+	var count int
+	err := DB.QueryRow(qGetTagCount+inPlaceholders(len(ids)), uintArgs(ids)...).Scan(&count)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+			"tags":     tags,
+		}).Info("Query tag count failed")
+
+		return err
+	}
+
+	if count != len(ids) {
+		return ErrTagNotFound
+	}
+
+	return nil
+}
+
+// 用新的标签替换博文原来的标签, 在保存博文的事务里执行
+func setArticleTags(tx *sql.Tx, articleID uint, tags []uint) error {
+	_, err := tx.Exec(qDeleteArticleTags, articleID)
+	if err != nil {
+		return err
+	}
+
+	for _, tag := range uniqueIDs(tags) {
+		_, err = tx.Exec(qAddArticleTag, articleID, tag)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// 一次查询出多篇博文的标签, 填到每篇博文的 Tags 里
+func loadArticleTags(articles []Article) error {
+	if len(articles) == 0 {
+		return nil
+	}
+
+	index := make(map[uint]int)
+	ids := make([]uint, 0, len(articles))
+	for i := range articles {
+		articles[i].Tags = []uint{}
+		index[articles[i].ID] = i
+		ids = append(ids, articles[i].ID)
+	}
+
+	rows, err := DB.Query(qGetArticleTags+inPlaceholders(len(ids))+" ORDER BY tag_id", uintArgs(ids)...)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+		}).Info("Query article tags failed")
+
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var articleID, tagID uint
+		err = rows.Scan(&articleID, &tagID)
+		if err != nil {
+			break
+		}
+
+		if i, ok := index[articleID]; ok {
+			articles[i].Tags = append(articles[i].Tags, tagID)
+		}
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+		}).Info("Rows scan failed")
+
+		return err
+	}
+
+	return nil
+}
+
+// InitialArticleTag 把旧的 article.tag_list 迁移到 article_tag 表, 迁移完成后删除 tag_list 字段
+// 已经迁移过的数据库没有 tag_list 字段, 不会重复执行
+func InitialArticleTag() {
+	legacy, err := hasColumn("article", "tag_list")
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+		}).Fatal("Query article columns failed")
+	}
+
+	if legacy {
+		migrateArticleTagList()
+	}
+
+	// 历史版本的标签改成用逗号连接
+	legacy, err = hasColumn("article_revision", "tag_list")
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+		}).Fatal("Query article revision columns failed")
+	}
+
+	if legacy {
+		_, err = DB.Exec(qMigrateRevisionTagList)
+		if err == nil {
+			_, err = DB.Exec(qRenameRevisionTagList)
+		}
+		if err != nil {
+			log.WithFields(log.Fields{
+				"errorMsg": err,
+			}).Fatal("Migrate article revision tag_list failed")
+		}
+	}
+}
+
+// 把 tag_list 里的每个标签插入 article_tag, 已经不存在的标签直接忽略
+func migrateArticleTagList() {
+	rows, err := DB.Query(qGetArticleTagList)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+		}).Fatal("Query article tag_list failed")
+	}
+
+	tagLists := make(map[uint]string)
+	for rows.Next() {
+		var id uint
+		var tagList string
+		err = rows.Scan(&id, &tagList)
+		tagLists[id] = tagList
+	}
+	rows.Close()
+	if err == nil {
+		err = rows.Err()
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+		}).Fatal("Rows scan failed")
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+		}).Fatal("Begin transaction failed")
+	}
+
+	count := 0
+	for id, tagList := range tagLists {
+		for _, tag := range strings.Split(tagList, "_") {
+			tagID, perr := strconv.ParseUint(tag, 10, 0)
+			if perr != nil {
+				continue
+			}
+
+			_, err = tx.Exec(qMigrateArticleTag, id, tagID)
+			if err != nil {
+				tx.Rollback()
+				log.WithFields(log.Fields{
+					"errorMsg": err,
+					"id":       id,
+				}).Fatal("Migrate article tag_list failed")
+			}
+			count++
+		}
+	}
+
+	err = tx.Commit()
+	if err == nil {
+		// ALTER TABLE 会隐式提交, 放在事务外面执行
+		_, err = DB.Exec(qDropArticleTagList)
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+		}).Fatal("Migrate article tag_list failed")
+	}
+
+	log.WithFields(log.Fields{
+		"articles": len(tagLists),
+		"tags":     count,
+	}).Info("Migrate article tag_list success")
+}
+
+// 查询表里是否有某个字段
+func hasColumn(table string, column string) (bool, error) {
+	var count int
+
+	err := DB.QueryRow(qHasColumn, table, column).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+
+	return count != 0, nil
+}
+
+// 去掉重复的 id, 保持原来的顺序
+func uniqueIDs(ids []uint) []uint {
+	seen := make(map[uint]bool)
+	unique := []uint{}
+
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+
+	return unique
+}
+
+// 生成 IN 查询的占位符, 例如 (?, ?, ?)
+func inPlaceholders(n int) string {
+	return "(" + strings.TrimSuffix(strings.Repeat("?, ", n), ", ") + ")"
+}
+
+// 把 id 数组转换成查询参数
+func uintArgs(ids []uint) []interface{} {
+	args := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		args = append(args, id)
+	}
+
+	return args
+}
+
+// 把用逗号连接的标签 id 转换成数组, 用于读取历史版本
+func parseTagIDs(s string) []uint {
+	tags := []uint{}
+
+	for _, tag := range strings.Split(s, ",") {
+		id, err := strconv.ParseUint(tag, 10, 0)
+		if err == nil {
+			tags = append(tags, uint(id))
+		}
+	}
+
+	return tags
+}
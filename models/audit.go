@@ -0,0 +1,180 @@
+package models
+
+import (
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// 操作人的类型
+const (
+	// AuditActorAdmin 管理员
+	AuditActorAdmin = "admin"
+
+	// AuditActorUser 用户
+	AuditActorUser = "user"
+
+	// AuditActorAnonymous 没有登录, 比如注册和登录
+	AuditActorAnonymous = "anonymous"
+)
+
+// AuditExportMax 一次最多导出的审计日志条数
+const AuditExportMax = 10000
+
+// 审计日志只追加, 不提供修改和删除
+const (
+	auditColumns     = "id, create_at, actor_type, actor_id, action, target_type, target_id, before_json, after_json, status_code, ip, user_agent, request_id"
+	qAddAuditLog     = "INSERT INTO audit_log (actor_type, actor_id, action, target_type, target_id, before_json, after_json, status_code, ip, user_agent, request_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+	qGetAuditCount   = "SELECT COUNT(*) FROM audit_log WHERE "
+	qGetAuditsByPage = "SELECT " + auditColumns + " FROM audit_log WHERE "
+)
+
+// AuditLog 审计日志, Before 和 After 为修改前后的 JSON, 没有时为空字符串
+type AuditLog struct {
+	ID         uint   `db:"id" json:"id"`
+	CreateAt   string `db:"create_at" json:"create_at"`
+	ActorType  string `db:"actor_type" json:"actor_type"`
+	ActorID    string `db:"actor_id" json:"actor_id"`
+	Action     string `db:"action" json:"action"`
+	TargetType string `db:"target_type" json:"target_type"`
+	TargetID   string `db:"target_id" json:"target_id"`
+	Before     string `db:"before_json" json:"before"`
+	After      string `db:"after_json" json:"after"`
+	StatusCode int    `db:"status_code" json:"status_code"`
+	IP         string `db:"ip" json:"ip"`
+	UserAgent  string `db:"user_agent" json:"user_agent"`
+	RequestID  string `db:"request_id" json:"request_id"`
+}
+
+// AuditFilter 审计日志的筛选条件, 零值表示不过滤, From 和 To 为日期 2006-01-02
+type AuditFilter struct {
+	ActorType  string
+	ActorID    string
+	Action     string
+	TargetType string
+	TargetID   string
+	RequestID  string
+	From       string
+	To         string
+}
+
+func (f *AuditFilter) where() (string, []interface{}) {
+	conds := []string{"1 = 1"}
+	args := []interface{}{}
+
+	for _, eq := range []struct {
+		column string
+		value  string
+	}{
+		{"actor_type", f.ActorType},
+		{"actor_id", f.ActorID},
+		{"target_type", f.TargetType},
+		{"target_id", f.TargetID},
+		{"request_id", f.RequestID},
+	} {
+		if eq.value != "" {
+			conds = append(conds, eq.column+" = ?")
+			args = append(args, eq.value)
+		}
+	}
+
+	if f.Action != "" {
+		like := "%" + strings.NewReplacer("\\", "\\\\", "%", "\\%", "_", "\\_").Replace(f.Action) + "%"
+		conds = append(conds, "action LIKE ?")
+		args = append(args, like)
+	}
+
+	if f.From != "" {
+		conds = append(conds, "create_at >= ?")
+		args = append(args, f.From)
+	}
+
+	if f.To != "" {
+		conds = append(conds, "create_at < DATE_ADD(?, INTERVAL 1 DAY)")
+		args = append(args, f.To)
+	}
+
+	return strings.Join(conds, " AND "), args
+}
+
+// AddAuditLog 追加一条审计日志
+func AddAuditLog(entry *AuditLog) error {
+	_, err := DB.Exec(qAddAuditLog, entry.ActorType, entry.ActorID, entry.Action, entry.TargetType, entry.TargetID, entry.Before, entry.After, entry.StatusCode, entry.IP, entry.UserAgent, entry.RequestID)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg":  err,
+			"action":    entry.Action,
+			"requestID": entry.RequestID,
+		}).Info("Add audit log failed")
+
+		return err
+	}
+
+	return nil
+}
+
+// GetAuditLogs 分页获取审计日志, 最新的在前, 同时返回总数
+func GetAuditLogs(filter *AuditFilter, limit int64, page int64) ([]*AuditLog, int64, error) {
+	var count int64
+
+	where, args := filter.where()
+
+	err := DB.QueryRow(qGetAuditCount+where, args...).Scan(&count)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+		}).Info("Query audit log count failed")
+
+		return nil, 0, err
+	}
+
+	rows, err := DB.Query(qGetAuditsByPage+where+" ORDER BY id DESC LIMIT ?, ?", append(args, limit*(page-1), limit)...)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+		}).Info("Query audit logs failed")
+
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	entries := []*AuditLog{}
+	for rows.Next() {
+		entry := &AuditLog{}
+		err = scanAuditLog(rows, entry)
+		if err != nil {
+			break
+		}
+
+		entries = append(entries, entry)
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+		}).Info("Rows scan failed")
+
+		return nil, 0, err
+	}
+
+	return entries, count, nil
+}
+
+func scanAuditLog(row rowScanner, entry *AuditLog) error {
+	return row.Scan(
+		&entry.ID,
+		&entry.CreateAt,
+		&entry.ActorType,
+		&entry.ActorID,
+		&entry.Action,
+		&entry.TargetType,
+		&entry.TargetID,
+		&entry.Before,
+		&entry.After,
+		&entry.StatusCode,
+		&entry.IP,
+		&entry.UserAgent,
+		&entry.RequestID)
+}
@@ -0,0 +1,457 @@
+/*
+* 博文评论
+*
+* 评论可以回复别的评论，parent_id 为 0 的是顶层评论。注册用户和游客都可以评论，
+* 游客需要填写名字和邮箱。评论内容支持简化的 markdown，只保留段落、强调、代码、引用、列表和链接
+*
+* 新评论默认待审核，已经有评论通过审核的注册用户直接通过。只有通过审核的评论才对外显示，
+* 博文的 reply_count 也只统计通过审核的评论，审核状态改变时在同一个事务里更新
+ */
+
+package models
+
+import (
+	"bytes"
+	"database/sql"
+	"regexp"
+
+	"github.com/microcosm-cc/bluemonday"
+	log "github.com/sirupsen/logrus"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+)
+
+const (
+	// CommentContentLengthMax 评论最多字数
+	CommentContentLengthMax = 2000
+
+	// CommentAuthorLengthMax 游客名字最多字数
+	CommentAuthorLengthMax = 20
+
+	// CommentStatusPending 待审核
+	CommentStatusPending = "pending"
+
+	// CommentStatusApproved 已通过审核, 对外显示
+	CommentStatusApproved = "approved"
+
+	// CommentStatusSpam 垃圾评论
+	CommentStatusSpam = "spam"
+
+	// CommentStatusDeleted 已删除
+	CommentStatusDeleted = "deleted"
+
+	commentColumns = "id, article_id, parent_id, user_id, author_name, author_email, content, content_html, ip, status, filter_score, filter_reason, create_at"
+
+	qAddComment              = "INSERT INTO comment (article_id, parent_id, user_id, author_name, author_email, content, content_html, ip, status, filter_score, filter_reason, create_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+	qGetCommentsByArticle    = "SELECT " + commentColumns + " FROM comment WHERE article_id = ? AND status = '" + CommentStatusApproved + "' ORDER BY id ASC"
+	qGetComment              = "SELECT " + commentColumns + " FROM comment WHERE id = ?"
+	qGetCommentParent        = "SELECT id FROM comment WHERE id = ? AND article_id = ? AND status = '" + CommentStatusApproved + "'"
+	qGetCommentCountByStatus = "SELECT COUNT(*) FROM comment WHERE status = ?"
+	qGetCommentsByStatus     = "SELECT " + commentColumns + " FROM comment WHERE status = ? ORDER BY id ASC LIMIT ?, ?"
+	qGetUserApprovedCount    = "SELECT COUNT(*) FROM comment WHERE user_id = ? AND status = '" + CommentStatusApproved + "'"
+	qGetCommentStatusForLock = "SELECT id, article_id, status FROM comment WHERE id IN "
+	qGetCommentStatuses      = "SELECT id, status FROM comment WHERE id IN "
+	qUpdateCommentStatus     = "UPDATE comment SET status = ? WHERE id IN "
+	qLockPublicArticle       = "SELECT id FROM article WHERE id = ? AND " + publicArticle + " FOR UPDATE"
+	qUpdateReplyCount        = "UPDATE article SET reply_count = reply_count + ? WHERE id = ?"
+)
+
+// Comment 评论的数据结构, Replies 为回复这条评论的评论
+type Comment struct {
+	ID           uint       `db:"id" json:"id"`
+	ArticleID    uint       `db:"article_id" json:"article_id"`
+	ParentID     uint       `db:"parent_id" json:"parent_id"`
+	UserID       string     `db:"user_id" json:"user_id"`
+	AuthorName   string     `db:"author_name" json:"author_name"`
+	AuthorEmail  string     `db:"author_email" json:"-"`
+	Content      string     `db:"content" json:"content"`
+	ContentHTML  string     `db:"content_html" json:"content_html"`
+	IP           string     `db:"ip" json:"-"`
+	Status       string     `db:"status" json:"status"`
+	FilterScore  int        `db:"filter_score" json:"-"`
+	FilterReason string     `db:"filter_reason" json:"-"`
+	CreateAt     string     `db:"create_at" json:"create_at"`
+	Replies      []*Comment `db:"-" json:"replies"`
+}
+
+// 评论的 markdown 解析器, 不支持原始 html
+var commentMarkdown = goldmark.New(
+	goldmark.WithExtensions(extension.Linkify, extension.Strikethrough),
+)
+
+// 评论的 html 过滤规则, 标题和图片等元素只保留文字, 链接加上 nofollow
+var commentPolicy = func() *bluemonday.Policy {
+	p := bluemonday.NewPolicy()
+	p.AllowElements("p", "br", "strong", "em", "del", "code", "pre", "blockquote", "ul", "ol", "li")
+	p.AllowStandardURLs()
+	p.AllowURLSchemes("http", "https", "mailto")
+	p.AllowAttrs("href").Matching(regexp.MustCompile(`^(https?://|mailto:)`)).OnElements("a")
+	p.RequireNoFollowOnLinks(true)
+	p.AddTargetBlankToFullyQualifiedLinks(true)
+
+	return p
+}()
+
+// RenderCommentMarkdown 把评论内容渲染成过滤后的 html
+func RenderCommentMarkdown(content string) (string, error) {
+	var buf bytes.Buffer
+
+	err := commentMarkdown.Convert([]byte(content), &buf)
+	if err != nil {
+		return "", err
+	}
+
+	return commentPolicy.Sanitize(buf.String()), nil
+}
+
+// ValidCommentStatus 检查是否是合法的审核状态
+func ValidCommentStatus(status string) bool {
+	switch status {
+	case CommentStatusPending, CommentStatusApproved, CommentStatusSpam, CommentStatusDeleted:
+		return true
+	}
+
+	return false
+}
+
+// AddComment 增加评论, 通过审核的评论和博文的评论数在同一个事务里更新
+// 博文不存在或者未发布时返回 sql.ErrNoRows
+func AddComment(comment *Comment) (int64, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+		}).Info("Begin transaction failed")
+
+		return 0, err
+	}
+
+	var articleID uint
+	err = tx.QueryRow(qLockPublicArticle, comment.ArticleID).Scan(&articleID)
+	if err == nil && comment.Status == CommentStatusApproved {
+		_, err = tx.Exec(qUpdateReplyCount, 1, comment.ArticleID)
+	}
+	if err != nil {
+		tx.Rollback()
+		log.WithFields(log.Fields{
+			"errorMsg":  err,
+			"articleID": comment.ArticleID,
+		}).Info("Increase article reply count failed")
+
+		return 0, err
+	}
+
+	res, err := tx.Exec(qAddComment, comment.ArticleID, comment.ParentID, comment.UserID, comment.AuthorName, comment.AuthorEmail, comment.Content, comment.ContentHTML, comment.IP, comment.Status, comment.FilterScore, comment.FilterReason, comment.CreateAt)
+	if err != nil {
+		tx.Rollback()
+		log.WithFields(log.Fields{
+			"errorMsg":  err,
+			"articleID": comment.ArticleID,
+		}).Info("Add comment failed")
+
+		return 0, err
+	}
+
+	lastID, err := res.LastInsertId()
+	if err != nil {
+		tx.Rollback()
+
+		return 0, err
+	}
+
+	return lastID, tx.Commit()
+}
+
+// GetComment 根据 id 获取评论, 不存在时返回 sql.ErrNoRows
+func GetComment(id uint) (*Comment, error) {
+	comment := &Comment{Replies: []*Comment{}}
+
+	err := scanComment(DB.QueryRow(qGetComment, id), comment)
+	if err != nil {
+		return nil, err
+	}
+
+	return comment, nil
+}
+
+// GetCommentStatuses 获取评论的审核状态, key 为评论 id, 不存在的评论不包括在内
+func GetCommentStatuses(ids []uint) (map[uint]string, error) {
+	return queryStatuses(qGetCommentStatuses, ids)
+}
+
+// 查询 id 在 ids 里的评论或留言的审核状态
+func queryStatuses(query string, ids []uint) (map[uint]string, error) {
+	statuses := make(map[uint]string)
+
+	ids = uniqueIDs(ids)
+	if len(ids) == 0 {
+		return statuses, nil
+	}
+
+	rows, err := DB.Query(query+inPlaceholders(len(ids)), uintArgs(ids)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id uint
+		var status string
+		err = rows.Scan(&id, &status)
+		if err != nil {
+			return nil, err
+		}
+
+		statuses[id] = status
+	}
+
+	return statuses, rows.Err()
+}
+
+// CheckCommentParent 检查回复的评论是否属于同一篇博文并且已通过审核, 不存在时返回 sql.ErrNoRows
+func CheckCommentParent(articleID uint, parentID uint) error {
+	var id uint
+
+	return DB.QueryRow(qGetCommentParent, parentID, articleID).Scan(&id)
+}
+
+// GetCommentsByArticle 获取博文通过审核的评论, 回复放在被回复评论的 Replies 里, 返回顶层评论
+func GetCommentsByArticle(articleID uint64) ([]*Comment, error) {
+	rows, err := DB.Query(qGetCommentsByArticle, articleID)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg":  err,
+			"articleID": articleID,
+		}).Info("DB query comments failed")
+
+		return nil, err
+	}
+	defer rows.Close()
+
+	var comments []*Comment
+	for rows.Next() {
+		comment := &Comment{Replies: []*Comment{}}
+		err = scanComment(rows, comment)
+		if err != nil {
+			break
+		}
+
+		comments = append(comments, comment)
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+		}).Info("Rows scan failed")
+
+		return nil, err
+	}
+
+	return commentTree(comments), nil
+}
+
+func scanComment(row rowScanner, comment *Comment) error {
+	return row.Scan(
+		&comment.ID,
+		&comment.ArticleID,
+		&comment.ParentID,
+		&comment.UserID,
+		&comment.AuthorName,
+		&comment.AuthorEmail,
+		&comment.Content,
+		&comment.ContentHTML,
+		&comment.IP,
+		&comment.Status,
+		&comment.FilterScore,
+		&comment.FilterReason,
+		&comment.CreateAt)
+}
+
+// 按 parent_id 把评论组织成树, 找不到父评论的回复当作顶层评论
+func commentTree(comments []*Comment) []*Comment {
+	byID := make(map[uint]*Comment)
+	for _, comment := range comments {
+		byID[comment.ID] = comment
+	}
+
+	roots := []*Comment{}
+	for _, comment := range comments {
+		parent, ok := byID[comment.ParentID]
+		if comment.ParentID == 0 || !ok {
+			roots = append(roots, comment)
+
+			continue
+		}
+
+		parent.Replies = append(parent.Replies, comment)
+	}
+
+	return roots
+}
+
+// CountUserApprovedComments 查询用户已通过审核的评论数
+func CountUserApprovedComments(userID string) (int64, error) {
+	var count int64
+
+	err := DB.QueryRow(qGetUserApprovedCount, userID).Scan(&count)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+			"userID":   userID,
+		}).Info("Query user approved comment count failed")
+
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// GetCommentsByStatus 分页获取某个审核状态的评论, 按提交时间从旧到新排列, 同时返回总数
+func GetCommentsByStatus(status string, limit int64, page int64) ([]*Comment, int64, error) {
+	var count int64
+
+	err := DB.QueryRow(qGetCommentCountByStatus, status).Scan(&count)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+			"status":   status,
+		}).Info("Query comment count failed")
+
+		return nil, 0, err
+	}
+
+	rows, err := DB.Query(qGetCommentsByStatus, status, limit*(page-1), limit)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+			"status":   status,
+		}).Info("DB query comments failed")
+
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	comments := []*Comment{}
+	for rows.Next() {
+		comment := &Comment{Replies: []*Comment{}}
+		err = scanComment(rows, comment)
+		if err != nil {
+			break
+		}
+
+		comments = append(comments, comment)
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+		}).Info("Rows scan failed")
+
+		return nil, 0, err
+	}
+
+	return comments, count, nil
+}
+
+// SetCommentStatus 修改评论的审核状态, 同一个事务里按通过审核的评论数的变化更新博文的评论数
+// 返回状态改变的评论 id, 一条评论都不存在时返回 sql.ErrNoRows
+func SetCommentStatus(ids []uint, status string) ([]uint, error) {
+	ids = uniqueIDs(ids)
+	if len(ids) == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+		}).Info("Begin transaction failed")
+
+		return nil, err
+	}
+
+	rows, err := tx.Query(qGetCommentStatusForLock+inPlaceholders(len(ids))+" FOR UPDATE", uintArgs(ids)...)
+	if err != nil {
+		tx.Rollback()
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+		}).Info("DB query comment status failed")
+
+		return nil, err
+	}
+
+	var found int64
+	changed := []uint{}
+	delta := make(map[uint]int)
+	for rows.Next() {
+		var id, articleID uint
+		var old string
+		err = rows.Scan(&id, &articleID, &old)
+		if err != nil {
+			break
+		}
+
+		found++
+		if old == status {
+			continue
+		}
+
+		changed = append(changed, id)
+		if old == CommentStatusApproved {
+			delta[articleID]--
+		}
+		if status == CommentStatusApproved {
+			delta[articleID]++
+		}
+	}
+	rows.Close()
+	if err == nil {
+		err = rows.Err()
+	}
+	if err == nil && found == 0 {
+		err = sql.ErrNoRows
+	}
+	if err != nil {
+		tx.Rollback()
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+		}).Info("Rows scan failed")
+
+		return nil, err
+	}
+
+	args := append([]interface{}{status}, uintArgs(ids)...)
+	_, err = tx.Exec(qUpdateCommentStatus+inPlaceholders(len(ids)), args...)
+	if err != nil {
+		tx.Rollback()
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+			"status":   status,
+		}).Info("Update comment status failed")
+
+		return nil, err
+	}
+
+	for articleID, n := range delta {
+		if n == 0 {
+			continue
+		}
+
+		_, err = tx.Exec(qUpdateReplyCount, n, articleID)
+		if err != nil {
+			tx.Rollback()
+			log.WithFields(log.Fields{
+				"errorMsg":  err,
+				"articleID": articleID,
+			}).Info("Update article reply count failed")
+
+			return nil, err
+		}
+	}
+
+	return changed, tx.Commit()
+}
@@ -13,10 +13,13 @@ import (
 	"database/sql"
 
 	// _ "github.com/go-sql-driver/mysql"  因为放在models包，所以不在main函数里引入
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
 	log "github.com/sirupsen/logrus"
 )
 
+// 违反唯一索引时 mysql 返回的错误码
+const mysqlDuplicateEntry = 1062
+
 // DB  定义为sql.DB类型
 var DB *sql.DB
 
@@ -42,3 +45,10 @@ func InitialDB() {
 
 	DB = db
 }
+
+// 是否是违反唯一索引的错误
+func isDuplicateKey(err error) bool {
+	e, ok := err.(*mysql.MySQLError)
+
+	return ok && e.Number == mysqlDuplicateEntry
+}
@@ -0,0 +1,239 @@
+/*
+* 留言板
+*
+* 留言和博文无关，注册用户和游客都可以留言，审核状态和评论一样。
+* 管理员可以回复留言和置顶留言，管理员的回复 is_owner 为 true，直接通过审核
+ */
+
+package models
+
+import (
+	"database/sql"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	guestbookColumns = "id, parent_id, user_id, author_name, author_email, content, content_html, ip, is_owner, is_pinned, status, filter_score, filter_reason, create_at"
+
+	qAddGuestbook          = "INSERT INTO guestbook (parent_id, user_id, author_name, author_email, content, content_html, ip, is_owner, status, filter_score, filter_reason, create_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+	qGetGuestbookByID      = "SELECT " + guestbookColumns + " FROM guestbook WHERE id = ?"
+	qGetGuestbookCount     = "SELECT COUNT(*) FROM guestbook WHERE parent_id = 0 AND status = '" + CommentStatusApproved + "'"
+	qGetGuestbookByPage    = "SELECT " + guestbookColumns + " FROM guestbook WHERE parent_id = 0 AND status = '" + CommentStatusApproved + "' ORDER BY is_pinned DESC, id DESC LIMIT ?, ?"
+	qGetGuestbookReplies   = "SELECT " + guestbookColumns + " FROM guestbook WHERE status = '" + CommentStatusApproved + "' AND parent_id IN "
+	qGetGuestbookStatusCnt = "SELECT COUNT(*) FROM guestbook WHERE status = ?"
+	qGetGuestbookByStatus  = "SELECT " + guestbookColumns + " FROM guestbook WHERE status = ? ORDER BY id ASC LIMIT ?, ?"
+	qGetGuestbookIDCount   = "SELECT COUNT(*) FROM guestbook WHERE id IN "
+	qGetGuestbookStatuses  = "SELECT id, status FROM guestbook WHERE id IN "
+	qUpdateGuestbookStatus = "UPDATE guestbook SET status = ? WHERE id IN "
+	qPinGuestbook          = "UPDATE guestbook SET is_pinned = ? WHERE id = ? AND parent_id = 0"
+)
+
+// GuestbookMessage 留言的数据结构, Replies 为管理员的回复
+type GuestbookMessage struct {
+	ID           uint                `db:"id" json:"id"`
+	ParentID     uint                `db:"parent_id" json:"parent_id"`
+	UserID       string              `db:"user_id" json:"user_id"`
+	AuthorName   string              `db:"author_name" json:"author_name"`
+	AuthorEmail  string              `db:"author_email" json:"-"`
+	Content      string              `db:"content" json:"content"`
+	ContentHTML  string              `db:"content_html" json:"content_html"`
+	IP           string              `db:"ip" json:"-"`
+	IsOwner      bool                `db:"is_owner" json:"is_owner"`
+	IsPinned     bool                `db:"is_pinned" json:"is_pinned"`
+	Status       string              `db:"status" json:"status"`
+	FilterScore  int                 `db:"filter_score" json:"-"`
+	FilterReason string              `db:"filter_reason" json:"-"`
+	CreateAt     string              `db:"create_at" json:"create_at"`
+	Replies      []*GuestbookMessage `db:"-" json:"replies"`
+}
+
+// AddGuestbookMessage 增加留言或者回复
+func AddGuestbookMessage(message *GuestbookMessage) (int64, error) {
+	res, err := DB.Exec(qAddGuestbook, message.ParentID, message.UserID, message.AuthorName, message.AuthorEmail, message.Content, message.ContentHTML, message.IP, message.IsOwner, message.Status, message.FilterScore, message.FilterReason, message.CreateAt)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+		}).Info("Add guestbook message failed")
+
+		return 0, err
+	}
+
+	return res.LastInsertId()
+}
+
+// GetGuestbookMessage 根据 id 获取留言, 不存在时返回 sql.ErrNoRows
+func GetGuestbookMessage(id uint) (*GuestbookMessage, error) {
+	message := &GuestbookMessage{Replies: []*GuestbookMessage{}}
+
+	err := scanGuestbook(DB.QueryRow(qGetGuestbookByID, id), message)
+	if err != nil {
+		return nil, err
+	}
+
+	return message, nil
+}
+
+// GetGuestbookByPage 分页获取通过审核的留言和回复, 置顶的留言在前, 其他按时间从新到旧排列
+func GetGuestbookByPage(limit int64, page int64) ([]*GuestbookMessage, int64, error) {
+	var count int64
+
+	err := DB.QueryRow(qGetGuestbookCount).Scan(&count)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+		}).Info("Query guestbook count failed")
+
+		return nil, 0, err
+	}
+
+	messages, err := queryGuestbook(qGetGuestbookByPage, limit*(page-1), limit)
+	if err != nil || len(messages) == 0 {
+		return messages, count, err
+	}
+
+	byID := make(map[uint]*GuestbookMessage)
+	ids := make([]uint, 0, len(messages))
+	for _, message := range messages {
+		byID[message.ID] = message
+		ids = append(ids, message.ID)
+	}
+
+	replies, err := queryGuestbook(qGetGuestbookReplies+inPlaceholders(len(ids))+" ORDER BY id ASC", uintArgs(ids)...)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	for _, reply := range replies {
+		parent := byID[reply.ParentID]
+		parent.Replies = append(parent.Replies, reply)
+	}
+
+	return messages, count, nil
+}
+
+// GetGuestbookByStatus 分页获取某个审核状态的留言和回复, 按提交时间从旧到新排列, 同时返回总数
+func GetGuestbookByStatus(status string, limit int64, page int64) ([]*GuestbookMessage, int64, error) {
+	var count int64
+
+	err := DB.QueryRow(qGetGuestbookStatusCnt, status).Scan(&count)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+			"status":   status,
+		}).Info("Query guestbook count failed")
+
+		return nil, 0, err
+	}
+
+	messages, err := queryGuestbook(qGetGuestbookByStatus, status, limit*(page-1), limit)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return messages, count, nil
+}
+
+// SetGuestbookStatus 修改留言的审核状态, 一条留言都不存在时返回 sql.ErrNoRows
+func SetGuestbookStatus(ids []uint, status string) (int64, error) {
+	var found int64
+
+	ids = uniqueIDs(ids)
+	if len(ids) == 0 {
+		return 0, sql.ErrNoRows
+	}
+
+	err := DB.QueryRow(qGetGuestbookIDCount+inPlaceholders(len(ids)), uintArgs(ids)...).Scan(&found)
+	if err == nil && found == 0 {
+		err = sql.ErrNoRows
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	args := append([]interface{}{status}, uintArgs(ids)...)
+	res, err := DB.Exec(qUpdateGuestbookStatus+inPlaceholders(len(ids)), args...)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+			"status":   status,
+		}).Info("Update guestbook status failed")
+
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// GetGuestbookStatuses 获取留言的审核状态, key 为留言 id, 不存在的留言不包括在内
+func GetGuestbookStatuses(ids []uint) (map[uint]string, error) {
+	return queryStatuses(qGetGuestbookStatuses, ids)
+}
+
+// PinGuestbookMessage 置顶或取消置顶留言, 回复不能置顶
+func PinGuestbookMessage(id uint, pinned bool) error {
+	_, err := DB.Exec(qPinGuestbook, pinned, id)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+			"id":       id,
+		}).Info("Pin guestbook message failed")
+
+		return err
+	}
+
+	return nil
+}
+
+func queryGuestbook(query string, args ...interface{}) ([]*GuestbookMessage, error) {
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+		}).Info("DB query guestbook failed")
+
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []*GuestbookMessage{}
+	for rows.Next() {
+		message := &GuestbookMessage{Replies: []*GuestbookMessage{}}
+		err = scanGuestbook(rows, message)
+		if err != nil {
+			break
+		}
+
+		messages = append(messages, message)
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+		}).Info("Rows scan failed")
+
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+func scanGuestbook(row rowScanner, message *GuestbookMessage) error {
+	return row.Scan(
+		&message.ID,
+		&message.ParentID,
+		&message.UserID,
+		&message.AuthorName,
+		&message.AuthorEmail,
+		&message.Content,
+		&message.ContentHTML,
+		&message.IP,
+		&message.IsOwner,
+		&message.IsPinned,
+		&message.Status,
+		&message.FilterScore,
+		&message.FilterReason,
+		&message.CreateAt)
+}
@@ -0,0 +1,165 @@
+/*
+* 登录防爆破
+*
+* 按账号和 IP 分别记录登录失败次数, 超过阈值后锁定, 之后每失败一次锁定时间翻倍。
+* 失败次数在 Window 内没有新的失败时清零, 登录成功时清除账号的失败次数
+ */
+
+package models
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// 锁定的种类
+const (
+	LoginGuardAdmin = "admin"
+	LoginGuardUser  = "user"
+	LoginGuardIP    = "ip"
+)
+
+const (
+	loginFailPrefix = "login_fail_"
+	loginLockPrefix = "login_lock_"
+)
+
+// LoginPolicy 锁定策略, 失败 Threshold 次后锁定 Base, 之后每次翻倍, 最长 Max
+type LoginPolicy struct {
+	Threshold int64
+	Window    time.Duration
+	Base      time.Duration
+	Max       time.Duration
+}
+
+// LoginLock 当前的锁定
+type LoginLock struct {
+	Kind       string `json:"kind"`
+	Key        string `json:"key"`
+	Failures   int64  `json:"failures"`
+	RetryAfter int64  `json:"retry_after"`
+	ExpireAt   string `json:"expire_at"`
+}
+
+// Lockout 失败 failures 次之后的锁定时间, 没有达到阈值时返回 0
+func (p *LoginPolicy) Lockout(failures int64) time.Duration {
+	if failures < p.Threshold {
+		return 0
+	}
+
+	lock := p.Base
+	for i := p.Threshold; i < failures && lock < p.Max; i++ {
+		lock *= 2
+	}
+	if lock > p.Max {
+		lock = p.Max
+	}
+
+	return lock
+}
+
+// GetLoginLock 查询锁定剩下的时间, 没有锁定时返回 0
+func GetLoginLock(kind string, key string) (time.Duration, error) {
+	ttl, err := RedisClient.TTL(loginLockPrefix + kind + "_" + key).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	// 不存在时 ttl 为负数
+	if ttl < 0 {
+		return 0, nil
+	}
+
+	return ttl, nil
+}
+
+// RecordLoginFailure 记录一次登录失败, 返回失败次数和这次的锁定时间
+func RecordLoginFailure(kind string, key string, policy *LoginPolicy) (int64, time.Duration, error) {
+	var count *redis.IntCmd
+
+	failKey := loginFailPrefix + kind + "_" + key
+	_, err := RedisClient.TxPipelined(func(pipe redis.Pipeliner) error {
+		count = pipe.Incr(failKey)
+		pipe.Expire(failKey, policy.Window)
+
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	lock := policy.Lockout(count.Val())
+	if lock > 0 {
+		err = RedisClient.Set(loginLockPrefix+kind+"_"+key, count.Val(), lock).Err()
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	return count.Val(), lock, nil
+}
+
+// ClearLoginFailures 清除失败次数和锁定
+func ClearLoginFailures(kind string, key string) error {
+	return RedisClient.Del(loginFailPrefix+kind+"_"+key, loginLockPrefix+kind+"_"+key).Err()
+}
+
+// GetLoginLocks 获取当前所有的锁定
+func GetLoginLocks() ([]*LoginLock, error) {
+	var keys []string
+	var cursor uint64
+
+	for {
+		page, next, err := RedisClient.Scan(cursor, loginLockPrefix+"*", 100).Result()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, page...)
+
+		cursor = next
+		if cursor == 0 {
+			break
+		}
+	}
+
+	locks := make([]*LoginLock, 0, len(keys))
+	for _, k := range keys {
+		// 可能在 scan 之后过期了
+		value, err := RedisClient.Get(k).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		ttl, err := RedisClient.TTL(k).Result()
+		if err != nil {
+			return nil, err
+		}
+		if ttl < 0 {
+			continue
+		}
+
+		// key 为 login_lock_<kind>_<key>, kind 里没有下划线
+		name := strings.TrimPrefix(k, loginLockPrefix)
+		i := strings.Index(name, "_")
+		if i == -1 {
+			continue
+		}
+
+		failures, _ := strconv.ParseInt(value, 10, 64)
+		locks = append(locks, &LoginLock{
+			Kind:       name[:i],
+			Key:        name[i+1:],
+			Failures:   failures,
+			RetryAfter: int64(ttl.Seconds() + 0.5),
+			ExpireAt:   time.Now().Add(ttl).Format(time.RFC3339),
+		})
+	}
+
+	return locks, nil
+}
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoginPolicyLockout(t *testing.T) {
+	policy := &LoginPolicy{
+		Threshold: 5,
+		Window:    24 * time.Hour,
+		Base:      time.Minute,
+		Max:       time.Hour,
+	}
+
+	tests := []struct {
+		failures int64
+		want     time.Duration
+	}{
+		{0, 0},
+		{4, 0},
+		{5, time.Minute},
+		{6, 2 * time.Minute},
+		{7, 4 * time.Minute},
+		{10, 32 * time.Minute},
+		// 超过 Max 后不再增加
+		{11, time.Hour},
+		{1000, time.Hour},
+	}
+
+	for _, test := range tests {
+		got := policy.Lockout(test.failures)
+		if got != test.want {
+			t.Errorf("Lockout(%d) = %v, want %v", test.failures, got, test.want)
+		}
+	}
+}
+
+func TestLoginPolicyLockoutBaseOverMax(t *testing.T) {
+	policy := &LoginPolicy{Threshold: 1, Base: 2 * time.Hour, Max: time.Hour}
+
+	got := policy.Lockout(1)
+	if got != time.Hour {
+		t.Errorf("Lockout(1) = %v, want %v", got, time.Hour)
+	}
+}
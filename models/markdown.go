@@ -0,0 +1,224 @@
+/*
+* 博文 markdown 渲染
+*
+* 保存博文时把 markdown 渲染成 html，支持 GFM 表格、脚注、带语言 class 的代码块和标题锚点，
+* 渲染结果经过 bluemonday 过滤，同时提取标题生成目录
+ */
+
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"strconv"
+
+	"github.com/microcosm-cc/bluemonday"
+	log "github.com/sirupsen/logrus"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+)
+
+const (
+	qGetArticleWithoutHTML = "SELECT id, article_content FROM article WHERE article_html = '' AND article_content != ''"
+	qUpdateArticleHTML     = "UPDATE article SET article_html = ?, article_toc = ? WHERE id = ?"
+)
+
+// TOCItem 目录的一个标题, Children 为下一级标题
+type TOCItem struct {
+	Level    int        `json:"level"`
+	Title    string     `json:"title"`
+	Anchor   string     `json:"anchor"`
+	Children []*TOCItem `json:"children,omitempty"`
+}
+
+// markdown 解析器, 标题自动生成 id 作为锚点
+var markdown = goldmark.New(
+	goldmark.WithExtensions(extension.GFM, extension.Footnote),
+	goldmark.WithParserOptions(parser.WithAutoHeadingID()),
+)
+
+// html 过滤规则, 在 UGCPolicy 的基础上允许代码块的语言 class 和脚注的 class
+var htmlPolicy = func() *bluemonday.Policy {
+	p := bluemonday.UGCPolicy()
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^language-[\w+#.-]+$`)).OnElements("code")
+	p.AllowAttrs("class").Matching(regexp.MustCompile(`^footnote(s|-ref|-backref)$`)).OnElements("a", "div")
+	p.AllowAttrs("role").Matching(regexp.MustCompile(`^doc-(endnotes|noteref|backlink)$`)).OnElements("a", "div")
+
+	return p
+}()
+
+// RenderMarkdown 把 markdown 渲染成过滤后的 html, 并返回序列化后的目录
+func RenderMarkdown(content string) (string, string, error) {
+	source := []byte(content)
+
+	// 每次渲染使用新的 ids, 中文标题的锚点转换成拼音
+	ctx := parser.NewContext(parser.WithIDs(newHeadingIDs()))
+	doc := markdown.Parser().Parse(text.NewReader(source), parser.WithContext(ctx))
+
+	var buf bytes.Buffer
+	err := markdown.Renderer().Render(&buf, source, doc)
+	if err != nil {
+		return "", "", err
+	}
+
+	toc, err := json.Marshal(extractTOC(doc, source))
+	if err != nil {
+		return "", "", err
+	}
+
+	return htmlPolicy.Sanitize(buf.String()), string(toc), nil
+}
+
+// ParseTOC 反序列化数据库里保存的目录
+func ParseTOC(toc string) []*TOCItem {
+	items := []*TOCItem{}
+	if toc == "" {
+		return items
+	}
+
+	err := json.Unmarshal([]byte(toc), &items)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+		}).Info("Unmarshal article toc failed")
+	}
+
+	return items
+}
+
+// InitialArticleHTML 给还没有渲染过的旧博文生成 html 和目录
+func InitialArticleHTML() {
+	rows, err := DB.Query(qGetArticleWithoutHTML)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+		}).Fatal("Query article without html failed")
+	}
+
+	contents := make(map[uint]string)
+	for rows.Next() {
+		var id uint
+		var content string
+		err = rows.Scan(&id, &content)
+		contents[id] = content
+	}
+	rows.Close()
+	if err == nil {
+		err = rows.Err()
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+		}).Fatal("Rows scan failed")
+	}
+
+	for id, content := range contents {
+		html, toc, err := RenderMarkdown(content)
+		if err == nil {
+			_, err = DB.Exec(qUpdateArticleHTML, html, toc, id)
+		}
+		if err != nil {
+			log.WithFields(log.Fields{
+				"errorMsg": err,
+				"id":       id,
+			}).Info("Render article markdown failed")
+		}
+	}
+
+	if len(contents) != 0 {
+		log.WithFields(log.Fields{
+			"count": len(contents),
+		}).Info("Render article markdown success")
+	}
+}
+
+// 从文档的标题生成目录树
+func extractTOC(doc ast.Node, source []byte) []*TOCItem {
+	toc := []*TOCItem{}
+
+	// 当前每一级的最后一个标题
+	var stack []*TOCItem
+
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		heading, ok := n.(*ast.Heading)
+		if !ok || !entering {
+			return ast.WalkContinue, nil
+		}
+
+		item := &TOCItem{
+			Level: heading.Level,
+			Title: nodeText(heading, source),
+		}
+		if id, ok := heading.AttributeString("id"); ok {
+			if b, ok := id.([]byte); ok {
+				item.Anchor = string(b)
+			}
+		}
+
+		for len(stack) != 0 && stack[len(stack)-1].Level >= item.Level {
+			stack = stack[:len(stack)-1]
+		}
+
+		if len(stack) == 0 {
+			toc = append(toc, item)
+		} else {
+			parent := stack[len(stack)-1]
+			parent.Children = append(parent.Children, item)
+		}
+		stack = append(stack, item)
+
+		return ast.WalkSkipChildren, nil
+	})
+
+	return toc
+}
+
+// 取出节点里的纯文本
+func nodeText(n ast.Node, source []byte) string {
+	var buf bytes.Buffer
+
+	for child := n.FirstChild(); child != nil; child = child.NextSibling() {
+		switch t := child.(type) {
+		case *ast.Text:
+			buf.Write(t.Segment.Value(source))
+		case *ast.String:
+			buf.Write(t.Value)
+		default:
+			buf.WriteString(nodeText(child, source))
+		}
+	}
+
+	return buf.String()
+}
+
+// headingIDs 生成标题锚点, 和博文 slug 一样把中文转换成拼音, 重复时加序号
+type headingIDs struct {
+	values map[string]bool
+}
+
+func newHeadingIDs() *headingIDs {
+	return &headingIDs{values: make(map[string]bool)}
+}
+
+func (s *headingIDs) Generate(value []byte, kind ast.NodeKind) []byte {
+	base := slugWords(string(value))
+	if base == "" {
+		base = "heading"
+	}
+
+	id := base
+	for i := 1; s.values[id]; i++ {
+		id = base + "-" + strconv.Itoa(i)
+	}
+	s.values[id] = true
+
+	return []byte(id)
+}
+
+func (s *headingIDs) Put(value []byte) {
+	s.values[string(value)] = true
+}
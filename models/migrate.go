@@ -0,0 +1,410 @@
+/*
+* 数据库迁移
+*
+* mysql_init.sql 只在建库时执行, 里面的 CREATE TABLE IF NOT EXISTS 不会修改已经存在的表,
+* 旧的数据库在启动时由 InitialMigration 补上新增的表、字段和索引。
+* 每一项迁移先检查是否已经执行过, 可以重复启动, 新的迁移加在 migrations 的最后
+ */
+
+package models
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+// RevokeAdminSessions 删除管理员的所有会话, 由 token 包设置, 迁移删除重复的管理员时使用
+var RevokeAdminSessions func(id uint) error
+
+const (
+	qHasTable = `SELECT COUNT(*) FROM information_schema.tables
+						WHERE table_schema = DATABASE() AND table_name = ?`
+	qHasIndex = `SELECT COUNT(*) FROM information_schema.statistics
+						WHERE table_schema = DATABASE() AND table_name = ? AND index_name = ?`
+
+	// 旧版本每次启动都会插入一个初始管理员, 同一个 admin_id 只保留 id 最小的一行
+	qDuplicateAdmins         = "SELECT DISTINCT a.id FROM admin a JOIN admin k ON k.admin_id = a.admin_id AND k.id < a.id"
+	qDeleteDuplicateAdmins   = "DELETE FROM admin WHERE id IN "
+	qDeleteDuplicateRecovery = "DELETE FROM admin_recovery_code WHERE admin_id IN "
+)
+
+// 一项迁移, column 和 index 都为空时检查表 table 是否存在, 不存在时先执行 prepare 再按顺序执行 queries
+type migration struct {
+	table   string
+	column  string
+	index   string
+	prepare func() error
+	queries []string
+}
+
+var migrations = []migration{
+	// 博文回收站
+	{table: "article", column: "is_trash", queries: []string{
+		"ALTER TABLE article ADD COLUMN is_trash TINYINT(1) NOT NULL DEFAULT 0",
+	}},
+	{table: "article", column: "trash_at", queries: []string{
+		"ALTER TABLE article ADD COLUMN trash_at varchar(255) NOT NULL DEFAULT ''",
+	}},
+
+	// 博文发布状态, 旧的博文都是已发布的, 发布时间用发表时间
+	{table: "article", column: "status", queries: []string{
+		"ALTER TABLE article ADD COLUMN status varchar(20) NOT NULL DEFAULT 'published'",
+	}},
+	{table: "article", column: "publish_at", queries: []string{
+		"ALTER TABLE article ADD COLUMN publish_at datetime NOT NULL DEFAULT CURRENT_TIMESTAMP",
+		"UPDATE article SET publish_at = create_at",
+	}},
+
+	// 博文 slug, 旧博文由 InitialArticleSlug 生成 slug 后再改成唯一索引
+	{table: "article", column: "slug", queries: []string{
+		"ALTER TABLE article ADD COLUMN slug varchar(255) NOT NULL DEFAULT '', ADD KEY slug (slug)",
+	}},
+	{table: "article_slug", queries: []string{
+		`CREATE TABLE article_slug (
+			id         INT(11) UNSIGNED NOT NULL AUTO_INCREMENT,
+			article_id INT(11) UNSIGNED NOT NULL DEFAULT 0,
+			slug       varchar(255)     NOT NULL DEFAULT '',
+			create_at  datetime         NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			primary key (id),
+			unique key (slug)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8`,
+	}},
+
+	// 渲染后的 html 和目录, 旧博文由 InitialArticleHTML 渲染
+	{table: "article", column: "article_html", queries: []string{
+		"ALTER TABLE article ADD COLUMN article_html mediumtext NOT NULL",
+	}},
+	{table: "article", column: "article_toc", queries: []string{
+		"ALTER TABLE article ADD COLUMN article_toc text NOT NULL",
+	}},
+
+	// 博文的历史版本
+	{table: "article_revision", queries: []string{
+		`CREATE TABLE article_revision (
+			id                  INT(11) UNSIGNED NOT NULL AUTO_INCREMENT,
+			article_id          INT(11) UNSIGNED NOT NULL DEFAULT 0,
+			article_title       varchar(255)     NOT NULL DEFAULT '',
+			article_previewtext text             NOT NULL,
+			article_content     text             NOT NULL,
+			category            INT(11) UNSIGNED NOT NULL DEFAULT 0,
+			tags                varchar(255)     NOT NULL DEFAULT '',
+			create_at           datetime         NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			primary key (id),
+			key (article_id)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8`,
+	}},
+
+	// 博文和标签的关系, 旧博文的 tag_list 由 InitialArticleTag 迁移
+	{table: "article_tag", queries: []string{
+		`CREATE TABLE article_tag (
+			article_id INT(11) UNSIGNED NOT NULL,
+			tag_id     INT(11) UNSIGNED NOT NULL,
+			primary key (article_id, tag_id),
+			key (tag_id),
+			foreign key (article_id) references article (id) on delete cascade,
+			foreign key (tag_id) references tags (id)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8`,
+	}},
+
+	// 站点设置
+	{table: "site_option", queries: []string{
+		`CREATE TABLE site_option (
+			option_name  varchar(64) NOT NULL,
+			option_value text        NOT NULL,
+			primary key (option_name)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8`,
+	}},
+
+	// 博文评论
+	{table: "comment", queries: []string{
+		`CREATE TABLE comment (
+			id            INT(11) UNSIGNED NOT NULL AUTO_INCREMENT,
+			article_id    INT(11) UNSIGNED NOT NULL,
+			parent_id     INT(11) UNSIGNED NOT NULL DEFAULT 0,
+			user_id       varchar(255)     NOT NULL DEFAULT '',
+			author_name   varchar(255)     NOT NULL DEFAULT '',
+			author_email  varchar(255)     NOT NULL DEFAULT '',
+			content       text             NOT NULL,
+			content_html  text             NOT NULL,
+			ip            varchar(255)     NOT NULL DEFAULT '',
+			status        varchar(20)      NOT NULL DEFAULT 'pending',
+			filter_score  INT(11)          NOT NULL DEFAULT 0,
+			filter_reason text             NOT NULL,
+			create_at     datetime         NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			primary key (id),
+			key (article_id),
+			key (status),
+			foreign key (article_id) references article (id) on delete cascade
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8`,
+	}},
+
+	// 评论审核状态, 旧的评论都是已经显示的, 新评论默认待审核
+	{table: "comment", column: "status", queries: []string{
+		"ALTER TABLE comment ADD COLUMN status varchar(20) NOT NULL DEFAULT 'approved', ADD KEY status (status)",
+		"ALTER TABLE comment ALTER COLUMN status SET DEFAULT 'pending'",
+	}},
+
+	// 评论内容过滤的分数和原因
+	{table: "comment", column: "filter_score", queries: []string{
+		"ALTER TABLE comment ADD COLUMN filter_score INT(11) NOT NULL DEFAULT 0",
+	}},
+	{table: "comment", column: "filter_reason", queries: []string{
+		"ALTER TABLE comment ADD COLUMN filter_reason text NOT NULL",
+	}},
+
+	// 留言板
+	{table: "guestbook", queries: []string{
+		`CREATE TABLE guestbook (
+			id            INT(11) UNSIGNED NOT NULL AUTO_INCREMENT,
+			parent_id     INT(11) UNSIGNED NOT NULL DEFAULT 0,
+			user_id       varchar(255)     NOT NULL DEFAULT '',
+			author_name   varchar(255)     NOT NULL DEFAULT '',
+			author_email  varchar(255)     NOT NULL DEFAULT '',
+			content       text             NOT NULL,
+			content_html  text             NOT NULL,
+			ip            varchar(255)     NOT NULL DEFAULT '',
+			is_owner      TINYINT(1)       NOT NULL DEFAULT 0,
+			is_pinned     TINYINT(1)       NOT NULL DEFAULT 0,
+			status        varchar(20)      NOT NULL DEFAULT 'pending',
+			filter_score  INT(11)          NOT NULL DEFAULT 0,
+			filter_reason text             NOT NULL,
+			create_at     datetime         NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			primary key (id),
+			key (parent_id),
+			key (status)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8`,
+	}},
+
+	// 用户封禁原因和到期时间
+	{table: "user", column: "ban_reason", queries: []string{
+		"ALTER TABLE user ADD COLUMN ban_reason varchar(255) NOT NULL DEFAULT ''",
+	}},
+	{table: "user", column: "ban_expire_at", queries: []string{
+		"ALTER TABLE user ADD COLUMN ban_expire_at datetime NULL DEFAULT NULL",
+	}},
+
+	// 用户邮箱和邮箱验证状态
+	{table: "user", column: "email", queries: []string{
+		"ALTER TABLE user ADD COLUMN email varchar(255) NOT NULL DEFAULT '', ADD KEY email (email)",
+	}},
+	{table: "user", column: "email_verified", queries: []string{
+		"ALTER TABLE user ADD COLUMN email_verified TINYINT(1) NOT NULL DEFAULT 0",
+	}},
+
+	// 管理员两步验证和恢复码
+	{table: "admin", column: "totp_secret", queries: []string{
+		"ALTER TABLE admin ADD COLUMN totp_secret varchar(64) NOT NULL DEFAULT ''",
+	}},
+	{table: "admin", column: "totp_enabled", queries: []string{
+		"ALTER TABLE admin ADD COLUMN totp_enabled tinyint(1) NOT NULL DEFAULT 0",
+	}},
+	{table: "admin_recovery_code", queries: []string{
+		`CREATE TABLE admin_recovery_code (
+			id        INT(11) UNSIGNED NOT NULL AUTO_INCREMENT,
+			admin_id  INT(11) UNSIGNED NOT NULL,
+			code_hash char(64)         NOT NULL,
+			create_at datetime         NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			used_at   datetime         NULL DEFAULT NULL,
+			primary key (id),
+			key (admin_id)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8`,
+	}},
+
+	// 博文作者和用户角色
+	{table: "article", column: "author_id", queries: []string{
+		"ALTER TABLE article ADD COLUMN author_id varchar(255) NOT NULL DEFAULT '', ADD KEY author_id (author_id)",
+	}},
+	{table: "user", column: "role", queries: []string{
+		"ALTER TABLE user ADD COLUMN role varchar(20) NOT NULL DEFAULT ''",
+	}},
+
+	// 多个管理员, 登录用的 admin_id 不能重复, 先删除旧版本重复插入的初始管理员
+	{table: "admin", index: "admin_id", prepare: dedupeAdmins, queries: []string{
+		"ALTER TABLE admin ADD UNIQUE KEY admin_id (admin_id)",
+	}},
+
+	// 审计日志
+	{table: "audit_log", queries: []string{
+		`CREATE TABLE audit_log (
+			id            INT(11) UNSIGNED NOT NULL AUTO_INCREMENT,
+			create_at     datetime         NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			actor_type    varchar(20)      NOT NULL DEFAULT '',
+			actor_id      varchar(255)     NOT NULL DEFAULT '',
+			action        varchar(255)     NOT NULL DEFAULT '',
+			target_type   varchar(64)      NOT NULL DEFAULT '',
+			target_id     varchar(255)     NOT NULL DEFAULT '',
+			before_json   mediumtext       NOT NULL,
+			after_json    mediumtext       NOT NULL,
+			status_code   INT(11)          NOT NULL DEFAULT 0,
+			ip            varchar(64)      NOT NULL DEFAULT '',
+			user_agent    varchar(512)     NOT NULL DEFAULT '',
+			request_id    varchar(64)      NOT NULL DEFAULT '',
+			primary key (id),
+			key (create_at),
+			key (actor_type, actor_id),
+			key (target_type, target_id),
+			key (request_id)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8`,
+	}},
+
+	// 用户ID不能重复, 旧版本注册时没有检查, 重复的用户登录时只会查到 id 最小的一行,
+	// 其他行改成 "用户ID_id", 注册的用户ID只有字母和数字, 不会和已有的用户ID相同
+	{table: "user", index: "user_id", queries: []string{
+		"UPDATE user u JOIN user k ON k.user_id = u.user_id AND k.id < u.id SET u.user_id = CONCAT(u.user_id, '_', u.id)",
+		"ALTER TABLE user ADD UNIQUE KEY user_id (user_id)",
+	}},
+}
+
+// InitialMigration 给旧的数据库补上新增的表、字段和索引, 需要在读写数据之前执行
+func InitialMigration() {
+	for _, m := range migrations {
+		applied, err := m.applied()
+		if err != nil {
+			log.WithFields(log.Fields{
+				"errorMsg": err,
+				"table":    m.table,
+				"column":   m.column,
+				"index":    m.index,
+			}).Fatal("Query database schema failed")
+		}
+
+		if applied {
+			continue
+		}
+
+		query, err := m.apply()
+		if err != nil {
+			log.WithFields(log.Fields{
+				"errorMsg": err,
+				"table":    m.table,
+				"query":    query,
+			}).Fatal("Migrate database failed")
+		}
+
+		log.WithFields(log.Fields{
+			"table":  m.table,
+			"column": m.column,
+			"index":  m.index,
+		}).Info("Migrate database success")
+	}
+}
+
+// 执行迁移, 失败时返回出错的语句, prepare 出错时语句为空
+func (m *migration) apply() (string, error) {
+	if m.prepare != nil {
+		err := m.prepare()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	// ALTER TABLE 会隐式提交, 不能放在事务里
+	for _, query := range m.queries {
+		_, err := DB.Exec(query)
+		if err != nil {
+			return query, err
+		}
+	}
+
+	return "", nil
+}
+
+// 删除 admin_id 重复的管理员和他们的恢复码、会话, 每个 admin_id 保留 id 最小的一行
+func dedupeAdmins() error {
+	rows, err := DB.Query(qDuplicateAdmins)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	ids := []uint{}
+	for rows.Next() {
+		var id uint
+		err = rows.Scan(&id)
+		if err != nil {
+			return err
+		}
+
+		ids = append(ids, id)
+	}
+	err = rows.Err()
+	if err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(qDeleteDuplicateRecovery+inPlaceholders(len(ids)), uintArgs(ids)...)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(qDeleteDuplicateAdmins+inPlaceholders(len(ids)), uintArgs(ids)...)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"ids": ids,
+	}).Info("Delete duplicate admin success")
+
+	// 管理员已经删除, 留下的 token 验证时也找不到管理员, 删除会话失败不影响启动
+	if RevokeAdminSessions != nil {
+		for _, id := range ids {
+			err = RevokeAdminSessions(id)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"errorMsg": err,
+					"id":       id,
+				}).Info("Revoke duplicate admin token failed")
+			}
+		}
+	}
+
+	return nil
+}
+
+// 迁移是否已经执行过
+func (m *migration) applied() (bool, error) {
+	switch {
+	case m.column != "":
+		return hasColumn(m.table, m.column)
+	case m.index != "":
+		return hasIndex(m.table, m.index)
+	default:
+		return hasTable(m.table)
+	}
+}
+
+func hasTable(table string) (bool, error) {
+	var count int
+
+	err := DB.QueryRow(qHasTable, table).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+
+	return count != 0, nil
+}
+
+func hasIndex(table string, index string) (bool, error) {
+	var count int
+
+	err := DB.QueryRow(qHasIndex, table, index).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+
+	return count != 0, nil
+}
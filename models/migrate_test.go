@@ -0,0 +1,193 @@
+package models
+
+import (
+	"database/sql"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// 连接测试用的 mysql 数据库, 默认为本地的 blog_test, 可以用 BLOG_TEST_MYSQL_DSN 修改,
+// 测试会删除并重建里面的表, 连接不上时跳过测试, 返回恢复原连接的函数
+func setupTestDB(t *testing.T) func() {
+	dsn := os.Getenv("BLOG_TEST_MYSQL_DSN")
+	if dsn == "" {
+		dsn = "root:123456@/blog_test?charset=utf8&parseTime=True&loc=Local"
+	}
+
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		t.Skipf("mysql not available: %v", err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		t.Skipf("mysql not available: %v", err)
+	}
+
+	old := DB
+	DB = db
+
+	return func() {
+		db.Close()
+		DB = old
+	}
+}
+
+func mustExec(t *testing.T, query string, args ...interface{}) {
+	_, err := DB.Exec(query, args...)
+	if err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+}
+
+func queryIDs(t *testing.T, query string) []uint {
+	rows, err := DB.Query(query)
+	if err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	defer rows.Close()
+
+	ids := []uint{}
+	for rows.Next() {
+		var id uint
+		if err := rows.Scan(&id); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids
+}
+
+// 执行表 table 上添加索引 index 的迁移, 检查执行前后的状态
+func applyTestMigration(t *testing.T, table string, index string) {
+	var m *migration
+	for i := range migrations {
+		if migrations[i].table == table && migrations[i].index == index {
+			m = &migrations[i]
+		}
+	}
+	if m == nil {
+		t.Fatalf("migration %s.%s not found", table, index)
+	}
+
+	applied, err := m.applied()
+	if err != nil || applied {
+		t.Fatalf("applied() = %v, %v, want false, nil", applied, err)
+	}
+
+	query, err := m.apply()
+	if err != nil {
+		t.Fatalf("apply() error at %q: %v", query, err)
+	}
+
+	applied, err = m.applied()
+	if err != nil || !applied {
+		t.Fatalf("applied() after apply = %v, %v, want true, nil", applied, err)
+	}
+}
+
+func TestMigrateAdminUniqueKey(t *testing.T) {
+	defer setupTestDB(t)()
+
+	mustExec(t, "DROP TABLE IF EXISTS admin, admin_recovery_code")
+	defer mustExec(t, "DROP TABLE IF EXISTS admin, admin_recovery_code")
+
+	// 旧版本的 admin 表, admin_id 没有唯一索引
+	mustExec(t, `CREATE TABLE admin (
+		id         INT(11) UNSIGNED NOT NULL AUTO_INCREMENT,
+		admin_id   varchar(255)     NOT NULL,
+		password   varchar(255)     NOT NULL,
+		admin_name varchar(255)     NOT NULL,
+		primary key (id)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8`)
+	mustExec(t, `CREATE TABLE admin_recovery_code (
+		id        INT(11) UNSIGNED NOT NULL AUTO_INCREMENT,
+		admin_id  INT(11) UNSIGNED NOT NULL,
+		code_hash char(64)         NOT NULL,
+		primary key (id),
+		key (admin_id)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8`)
+
+	for _, adminID := range []string{"admin", "admin", "editor", "admin", "editor"} {
+		mustExec(t, "INSERT INTO admin (admin_id, password, admin_name) VALUES (?, '', '')", adminID)
+	}
+	for _, id := range []uint{1, 2, 4} {
+		mustExec(t, "INSERT INTO admin_recovery_code (admin_id, code_hash) VALUES (?, '')", id)
+	}
+
+	revoked := []uint{}
+	oldRevoke := RevokeAdminSessions
+	defer func() { RevokeAdminSessions = oldRevoke }()
+	RevokeAdminSessions = func(id uint) error {
+		revoked = append(revoked, id)
+
+		return nil
+	}
+
+	applyTestMigration(t, "admin", "admin_id")
+
+	if got := queryIDs(t, "SELECT id FROM admin ORDER BY id"); !reflect.DeepEqual(got, []uint{1, 3}) {
+		t.Errorf("admin ids = %v, want [1 3]", got)
+	}
+	if got := queryIDs(t, "SELECT admin_id FROM admin_recovery_code ORDER BY admin_id"); !reflect.DeepEqual(got, []uint{1}) {
+		t.Errorf("recovery code admin ids = %v, want [1]", got)
+	}
+
+	sort.Slice(revoked, func(i, j int) bool { return revoked[i] < revoked[j] })
+	if !reflect.DeepEqual(revoked, []uint{2, 4, 5}) {
+		t.Errorf("revoked admins = %v, want [2 4 5]", revoked)
+	}
+
+	// 有唯一索引后不能再插入重复的 admin_id
+	_, err := DB.Exec("INSERT INTO admin (admin_id, password, admin_name) VALUES ('admin', '', '')")
+	if !isDuplicateKey(err) {
+		t.Errorf("insert duplicate admin_id error = %v, want duplicate key", err)
+	}
+}
+
+func TestMigrateUserUniqueKey(t *testing.T) {
+	defer setupTestDB(t)()
+
+	mustExec(t, "DROP TABLE IF EXISTS user")
+	defer mustExec(t, "DROP TABLE IF EXISTS user")
+
+	// 旧版本的 user 表, user_id 没有唯一索引
+	mustExec(t, `CREATE TABLE user (
+		id      INT(11) UNSIGNED NOT NULL AUTO_INCREMENT,
+		user_id varchar(255)     NOT NULL DEFAULT '',
+		primary key (id)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8`)
+
+	for _, userID := range []string{"alice", "alice", "bob", "alice"} {
+		mustExec(t, "INSERT INTO user (user_id) VALUES (?)", userID)
+	}
+
+	applyTestMigration(t, "user", "user_id")
+
+	rows, err := DB.Query("SELECT user_id FROM user ORDER BY id")
+	if err != nil {
+		t.Fatalf("query user error: %v", err)
+	}
+	defer rows.Close()
+
+	got := []string{}
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			t.Fatalf("scan user error: %v", err)
+		}
+		got = append(got, userID)
+	}
+
+	want := []string{"alice", "alice_2", "bob", "alice_4"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("user ids = %v, want %v", got, want)
+	}
+
+	_, err = DB.Exec("INSERT INTO user (user_id) VALUES ('bob')")
+	if !isDuplicateKey(err) {
+		t.Errorf("insert duplicate user_id error = %v, want duplicate key", err)
+	}
+}
@@ -5,7 +5,7 @@ CREATE DATABASE IF NOT EXISTS blog CHARACTER SET utf8 COLLATE utf8_general_ci;
 
 
 
-# 如果表 admin 不存在就建立一个叫 admin 的表
+# 如果表 admin 不存在就建立一个叫 admin 的表, 可以有多个管理员, 第一个管理员在启动时创建
 CREATE TABLE IF NOT EXISTS `admin` (
   `id`         INT(11) UNSIGNED NOT NULL AUTO_INCREMENT,
   `admin_id`   varchar(255)     NOT NULL DEFAULT '',
@@ -14,10 +14,24 @@ CREATE TABLE IF NOT EXISTS `admin` (
   `image` varchar(255) NOT NULL DEFAULT '',
   `last_login_at` varchar(255) NOT NULL DEFAULT '',
   `ip` varchar(255) NOT NULL DEFAULT '',
-  primary key (id)
+  `totp_secret` varchar(64) NOT NULL DEFAULT '',
+  `totp_enabled` tinyint(1) NOT NULL DEFAULT 0,
+  primary key (id),
+  unique key (admin_id)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8;
 
-# 如果表 article 不存在就建立一个叫 article 的表
+# 管理员两步验证的恢复码，只保存 sha256，使用后记录使用时间
+CREATE TABLE IF NOT EXISTS `admin_recovery_code` (
+  `id`        INT(11) UNSIGNED NOT NULL AUTO_INCREMENT,
+  `admin_id`  INT(11) UNSIGNED NOT NULL,
+  `code_hash` char(64)         NOT NULL,
+  `create_at` datetime         NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `used_at`   datetime         NULL DEFAULT NULL,
+  primary key (id),
+  key (admin_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+# 如果表 article 不存在就建立一个叫 article 的表, author_id 为作者的用户ID, 管理员写的博文为空
 CREATE TABLE IF NOT EXISTS `article` (
   `id`                  INT(11) UNSIGNED        NOT NULL AUTO_INCREMENT,
   `create_at`           datetime                NOT NULL DEFAULT CURRENT_TIMESTAMP,
@@ -25,12 +39,90 @@ CREATE TABLE IF NOT EXISTS `article` (
   `visit_count`         INT(11) UNSIGNED        NOT NULL DEFAULT 0,
   `reply_count`         INT(11) UNSIGNED        NOT NULL DEFAULT 0,
   `article_title`       varchar(255)            NOT NULL DEFAULT '',
+  `slug`                varchar(255)            NOT NULL DEFAULT '',
   `article_previewtext` text                    NOT NULL,
   `article_content`     text                    NOT NULL,
+  `article_html`        mediumtext              NOT NULL,
+  `article_toc`         text                    NOT NULL,
   `top`                 TINYINT(1)              NOT NULL DEFAULT 0,
   `category`            INT(11)  UNSIGNED       NOT NULL DEFAULT 0,
-  `tag_list`            varchar(255)            NOT NULL DEFAULT '',
-  primary key (id)
+  `status`              varchar(20)             NOT NULL DEFAULT 'published',
+  `publish_at`          datetime                NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `is_trash`            TINYINT(1)              NOT NULL DEFAULT 0,
+  `trash_at`            varchar(255)            NOT NULL DEFAULT '',
+  `author_id`           varchar(255)            NOT NULL DEFAULT '',
+  primary key (id),
+  unique key (slug),
+  key (author_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+# 如果表 article_slug 不存在就建立一个叫 article_slug 的表, 保存博文用过的旧 slug
+CREATE TABLE IF NOT EXISTS `article_slug` (
+  `id`                  INT(11) UNSIGNED        NOT NULL AUTO_INCREMENT,
+  `article_id`          INT(11) UNSIGNED        NOT NULL DEFAULT 0,
+  `slug`                varchar(255)            NOT NULL DEFAULT '',
+  `create_at`           datetime                NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  primary key (id),
+  unique key (slug)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+# 如果表 article_revision 不存在就建立一个叫 article_revision 的表, 每次修改博文前保存原来的版本
+CREATE TABLE IF NOT EXISTS `article_revision` (
+  `id`                  INT(11) UNSIGNED        NOT NULL AUTO_INCREMENT,
+  `article_id`          INT(11) UNSIGNED        NOT NULL DEFAULT 0,
+  `article_title`       varchar(255)            NOT NULL DEFAULT '',
+  `article_previewtext` text                    NOT NULL,
+  `article_content`     text                    NOT NULL,
+  `category`            INT(11)  UNSIGNED       NOT NULL DEFAULT 0,
+  `tags`                varchar(255)            NOT NULL DEFAULT '',
+  `create_at`           datetime                NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  primary key (id),
+  key (article_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+# 如果表 comment 不存在就建立一个叫 comment 的表, parent_id 为 0 的是顶层评论
+# status 为审核状态 pending(待审核) approved(已通过) spam(垃圾评论) deleted(已删除)
+# filter_score 和 filter_reason 为内容过滤的分数和原因
+CREATE TABLE IF NOT EXISTS `comment` (
+  `id`                  INT(11) UNSIGNED        NOT NULL AUTO_INCREMENT,
+  `article_id`          INT(11) UNSIGNED        NOT NULL,
+  `parent_id`           INT(11) UNSIGNED        NOT NULL DEFAULT 0,
+  `user_id`             varchar(255)            NOT NULL DEFAULT '',
+  `author_name`         varchar(255)            NOT NULL DEFAULT '',
+  `author_email`        varchar(255)            NOT NULL DEFAULT '',
+  `content`             text                    NOT NULL,
+  `content_html`        text                    NOT NULL,
+  `ip`                  varchar(255)            NOT NULL DEFAULT '',
+  `status`              varchar(20)             NOT NULL DEFAULT 'pending',
+  `filter_score`        INT(11)                 NOT NULL DEFAULT 0,
+  `filter_reason`       text                    NOT NULL,
+  `create_at`           datetime                NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  primary key (id),
+  key (article_id),
+  key (status),
+  foreign key (article_id) references article (id) on delete cascade
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+# 如果表 guestbook 不存在就建立一个叫 guestbook 的表, parent_id 为 0 的是留言, 其他为管理员的回复
+# is_owner 为 1 的是管理员的回复, status 和 comment 表一样
+CREATE TABLE IF NOT EXISTS `guestbook` (
+  `id`                  INT(11) UNSIGNED        NOT NULL AUTO_INCREMENT,
+  `parent_id`           INT(11) UNSIGNED        NOT NULL DEFAULT 0,
+  `user_id`             varchar(255)            NOT NULL DEFAULT '',
+  `author_name`         varchar(255)            NOT NULL DEFAULT '',
+  `author_email`        varchar(255)            NOT NULL DEFAULT '',
+  `content`             text                    NOT NULL,
+  `content_html`        text                    NOT NULL,
+  `ip`                  varchar(255)            NOT NULL DEFAULT '',
+  `is_owner`            TINYINT(1)              NOT NULL DEFAULT 0,
+  `is_pinned`           TINYINT(1)              NOT NULL DEFAULT 0,
+  `status`              varchar(20)             NOT NULL DEFAULT 'pending',
+  `filter_score`        INT(11)                 NOT NULL DEFAULT 0,
+  `filter_reason`       text                    NOT NULL,
+  `create_at`           datetime                NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  primary key (id),
+  key (parent_id),
+  key (status)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8;
 
 # 如果表 category_list 不存在就奖励一个叫 category_list 的表
@@ -49,6 +141,16 @@ CREATE TABLE IF NOT EXISTS `tags` (
   primary key (id)        
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8;
 
+# 如果表 article_tag 不存在就建立一个叫 article_tag 的表, 保存博文和标签的关系
+CREATE TABLE IF NOT EXISTS `article_tag` (
+  `article_id`      INT(11) UNSIGNED    NOT NULL,
+  `tag_id`          INT(11) UNSIGNED    NOT NULL,
+  primary key (article_id, tag_id),
+  key (tag_id),
+  foreign key (article_id) references article (id) on delete cascade,
+  foreign key (tag_id) references tags (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
 # 如果表 visitor_count 不存在就奖励一个叫 visit_count 的表
 CREATE TABLE IF NOT EXISTS `visitor_count` (
   `id`              INT(11) UNSIGNED    NOT NULL AUTO_INCREMENT,
@@ -58,18 +160,55 @@ CREATE TABLE IF NOT EXISTS `visitor_count` (
   primary key (id)        
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8;
 
-# 如果表 user 不存在就建立一个叫 user 的表
+# 如果表 site_option 不存在就建立一个叫 site_option 的表, 保存站点设置
+CREATE TABLE IF NOT EXISTS `site_option` (
+  `option_name`     varchar(64)         NOT NULL,
+  `option_value`    text                NOT NULL,
+  primary key (option_name)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+# 如果表 user 不存在就建立一个叫 user 的表, ban_expire_at 为 NULL 时永久封禁, role 为空时是普通用户
 CREATE TABLE IF NOT EXISTS `user` (
   `id`                  INT(11) UNSIGNED  NOT NULL AUTO_INCREMENT,
   `user_id`             varchar(255)      NOT NULL DEFAULT '',
   `password`            varchar(255)      NOT NULL DEFAULT '',
   `user_name`           varchar(255)      NOT NULL DEFAULT '',
+  `email`               varchar(255)      NOT NULL DEFAULT '',
+  `email_verified`      TINYINT(1)        NOT NULL DEFAULT 0,
   `image`               varchar(255)      NOT NULL DEFAULT '',
   `create_at`           datetime          NOT NULL DEFAULT CURRENT_TIMESTAMP,
   `last_login_at`       datetime          NOT NULL DEFAULT CURRENT_TIMESTAMP,
   `login_count`         INT(11) UNSIGNED  NOT NULL,
   `is_blacklist`        TINYINT(1)        NOT NULL DEFAULT 0,
-  primary key (id)
+  `ban_reason`          varchar(255)      NOT NULL DEFAULT '',
+  `ban_expire_at`       datetime          NULL DEFAULT NULL,
+  `role`                varchar(20)       NOT NULL DEFAULT '',
+  primary key (id),
+  unique key (user_id),
+  key (email)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+# 如果表 audit_log 不存在就建立一个叫 audit_log 的表, 记录后台和用户的每次修改操作, 只追加不修改
+# actor_type 为 admin(管理员) user(用户) anonymous(未登录), before_json 和 after_json 为修改前后的 JSON
+CREATE TABLE IF NOT EXISTS `audit_log` (
+  `id`                  INT(11) UNSIGNED  NOT NULL AUTO_INCREMENT,
+  `create_at`           datetime          NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `actor_type`          varchar(20)       NOT NULL DEFAULT '',
+  `actor_id`            varchar(255)      NOT NULL DEFAULT '',
+  `action`              varchar(255)      NOT NULL DEFAULT '',
+  `target_type`         varchar(64)       NOT NULL DEFAULT '',
+  `target_id`           varchar(255)      NOT NULL DEFAULT '',
+  `before_json`         mediumtext        NOT NULL,
+  `after_json`          mediumtext        NOT NULL,
+  `status_code`         INT(11)           NOT NULL DEFAULT 0,
+  `ip`                  varchar(64)       NOT NULL DEFAULT '',
+  `user_agent`          varchar(512)      NOT NULL DEFAULT '',
+  `request_id`          varchar(64)       NOT NULL DEFAULT '',
+  primary key (id),
+  key (create_at),
+  key (actor_type, actor_id),
+  key (target_type, target_id),
+  key (request_id)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8;
 
 
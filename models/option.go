@@ -0,0 +1,56 @@
+package models
+
+import (
+	"database/sql"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// 站点设置的名字
+const (
+	// OptionRobots robots.txt 的规则, 不包括 Sitemap 行
+	OptionRobots = "robots"
+
+	// OptionSensitiveWords 敏感词, 每行一个
+	OptionSensitiveWords = "sensitive_words"
+)
+
+const (
+	qGetOption = "SELECT option_value FROM site_option WHERE option_name = ?"
+	qSetOption = "INSERT INTO site_option (option_name, option_value) VALUES (?, ?) ON DUPLICATE KEY UPDATE option_value = VALUES(option_value)"
+)
+
+// GetOption 查询站点设置, 没有设置过时返回 defaultValue
+func GetOption(name string, defaultValue string) (string, error) {
+	var value string
+
+	err := DB.QueryRow(qGetOption, name).Scan(&value)
+	if err == sql.ErrNoRows {
+		return defaultValue, nil
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+			"name":     name,
+		}).Info("Query option failed")
+
+		return "", err
+	}
+
+	return value, nil
+}
+
+// SetOption 保存站点设置
+func SetOption(name string, value string) error {
+	_, err := DB.Exec(qSetOption, name, value)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+			"name":     name,
+		}).Info("Save option failed")
+
+		return err
+	}
+
+	return nil
+}
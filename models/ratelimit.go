@@ -0,0 +1,36 @@
+package models
+
+import (
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// IncreaseRateCount 给 redis 里的计数加一, 第一次计数时设置 window 的过期时间
+// 返回当前次数和离计数清零剩下的时间
+func IncreaseRateCount(key string, window time.Duration) (int64, time.Duration, error) {
+	var count *redis.IntCmd
+	var ttl *redis.DurationCmd
+
+	_, err := RedisClient.TxPipelined(func(pipe redis.Pipeliner) error {
+		count = pipe.Incr(key)
+		ttl = pipe.TTL(key)
+
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// 没有过期时间说明是第一次计数
+	remain := ttl.Val()
+	if remain < 0 {
+		remain = window
+		err = RedisClient.Expire(key, window).Err()
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	return count.Val(), remain, nil
+}
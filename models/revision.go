@@ -0,0 +1,100 @@
+package models
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	revisionColumns = "id, article_id, article_title, article_previewtext, article_content, category, tags, create_at"
+
+	// 从 article 表复制当前版本, 标签 id 用逗号连接保存, 在 UpdateArticle 的事务里执行
+	qAddRevision = `INSERT INTO article_revision (article_id, article_title, article_previewtext, article_content, category, tags, create_at)
+						SELECT id, article_title, article_previewtext, article_content, category,
+							(SELECT IFNULL(GROUP_CONCAT(tag_id ORDER BY tag_id), '') FROM article_tag WHERE article_id = article.id), ?
+							FROM article WHERE id = ? AND is_trash = 0`
+	qGetRevisionsByArticle = "SELECT " + revisionColumns + " FROM article_revision WHERE article_id = ? ORDER BY id DESC"
+	qGetRevisionByID       = "SELECT " + revisionColumns + " FROM article_revision WHERE id = ? AND article_id = ?"
+)
+
+// ArticleRevision 博文的历史版本
+type ArticleRevision struct {
+	ID                 uint   `db:"id" json:"id"`
+	ArticleID          uint   `db:"article_id" json:"article_id"`
+	ArticleTitle       string `db:"article_title" json:"article_title"`
+	ArticlePreviewText string `db:"article_previewtext" json:"article_previewtext"`
+	ArticleContent     string `db:"article_content" json:"article_content"`
+	Category           uint   `db:"category" json:"category"`
+	Tags               []uint `db:"tags" json:"tags"`
+	CreateAt           string `db:"create_at" json:"create_at"`
+}
+
+func scanRevision(row rowScanner, revision *ArticleRevision) error {
+	var tags string
+
+	err := row.Scan(
+		&revision.ID,
+		&revision.ArticleID,
+		&revision.ArticleTitle,
+		&revision.ArticlePreviewText,
+		&revision.ArticleContent,
+		&revision.Category,
+		&tags,
+		&revision.CreateAt)
+	if err != nil {
+		return err
+	}
+
+	revision.Tags = parseTagIDs(tags)
+
+	return nil
+}
+
+// GetRevisionsByArticle 获取博文的所有历史版本, 最新的在前面
+func GetRevisionsByArticle(articleID uint64) (*[]ArticleRevision, error) {
+	var revision ArticleRevision
+	revisions := []ArticleRevision{}
+
+	rows, err := DB.Query(qGetRevisionsByArticle, articleID)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg":  err,
+			"articleID": articleID,
+		}).Info("DB query article revisions failed")
+
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		err = scanRevision(rows, &revision)
+		revisions = append(revisions, revision)
+	}
+	err = rows.Err()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+		}).Info("Rows scan failed")
+
+		return nil, err
+	}
+
+	return &revisions, nil
+}
+
+// GetRevisionByID 获取博文的某个历史版本, 不存在时返回 sql.ErrNoRows
+func GetRevisionByID(articleID uint64, revisionID uint64) (*ArticleRevision, error) {
+	var revision ArticleRevision
+
+	err := scanRevision(DB.QueryRow(qGetRevisionByID, revisionID, articleID), &revision)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg":   err,
+			"articleID":  articleID,
+			"revisionID": revisionID,
+		}).Info("Query article revision failed")
+
+		return nil, err
+	}
+
+	return &revision, nil
+}
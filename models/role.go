@@ -0,0 +1,87 @@
+package models
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+// 用户的角色, 普通用户没有角色。管理员拥有所有权限, 不需要角色
+const (
+	// UserRoleEditor 编辑, 管理所有博文、分类和标签, 审核评论
+	UserRoleEditor = "editor"
+
+	// UserRoleAuthor 作者, 只能管理自己写的博文
+	UserRoleAuthor = "author"
+
+	// UserRoleModerator 版主, 审核评论和留言, 管理用户
+	UserRoleModerator = "moderator"
+)
+
+// 后台操作的权限
+const (
+	// PermManageArticles 管理所有博文和回收站
+	PermManageArticles = "manage_articles"
+
+	// PermOwnArticles 发表博文, 只能修改和删除自己的博文
+	PermOwnArticles = "own_articles"
+
+	// PermModerateComments 审核评论和留言
+	PermModerateComments = "moderate_comments"
+
+	// PermManageTaxonomy 管理分类和标签
+	PermManageTaxonomy = "manage_taxonomy"
+
+	// PermManageUsers 查看、封禁用户和强制用户退出
+	PermManageUsers = "manage_users"
+
+	// PermViewStats 查看站点统计
+	PermViewStats = "view_stats"
+)
+
+// RolePermissions 每个角色拥有的权限
+var RolePermissions = map[string][]string{
+	UserRoleEditor:    {PermManageArticles, PermOwnArticles, PermModerateComments, PermManageTaxonomy, PermViewStats},
+	UserRoleAuthor:    {PermOwnArticles, PermViewStats},
+	UserRoleModerator: {PermModerateComments, PermManageUsers, PermViewStats},
+}
+
+const (
+	qUpdateUserRole = "UPDATE user SET role = ? WHERE user_id = ?"
+)
+
+// ValidUserRole 检查是否是存在的角色, 空字符串表示普通用户
+func ValidUserRole(role string) bool {
+	if role == "" {
+		return true
+	}
+
+	_, ok := RolePermissions[role]
+
+	return ok
+}
+
+// HasPermission 角色是否拥有某个权限
+func HasPermission(role string, permission string) bool {
+	for _, p := range RolePermissions[role] {
+		if p == permission {
+			return true
+		}
+	}
+
+	return false
+}
+
+// UpdateUserRole 修改用户的角色
+func UpdateUserRole(userID string, role string) error {
+	_, err := DB.Exec(qUpdateUserRole, role, userID)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+			"userID":   userID,
+			"role":     role,
+		}).Info("Update user role failed")
+
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,466 @@
+/*
+* 博文全文搜索
+*
+* 搜索索引保存在内存里，启动时和博文改变后从 mysql 重建。
+* 索引包括标题、预览、内容、分类名和标签名，中文用 gse 分词
+ */
+
+package models
+
+import (
+	"html"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/go-ego/gse"
+	"github.com/microcosm-cc/bluemonday"
+	log "github.com/sirupsen/logrus"
+)
+
+// 各个字段的权重, 标题最重要
+const (
+	searchWeightTitle    = 5.0
+	searchWeightTag      = 3.0
+	searchWeightCategory = 3.0
+	searchWeightPreview  = 2.0
+	searchWeightContent  = 1.0
+
+	// 摘要在第一个匹配位置前后保留的字数
+	searchSnippetBefore = 40
+	searchSnippetAfter  = 120
+)
+
+// SearchQuery 搜索条件, Category 和 Tag 为 0 时不过滤
+type SearchQuery struct {
+	Query    string
+	Category uint
+	Tag      uint
+	Limit    int64
+	Page     int64
+}
+
+// SearchHit 一条搜索结果, 高亮的部分用 <em> 包起来, 其余内容已经转义
+type SearchHit struct {
+	ID             uint    `json:"id"`
+	Slug           string  `json:"slug"`
+	ArticleTitle   string  `json:"article_title"`
+	TitleHighlight string  `json:"title_highlight"`
+	Snippet        string  `json:"snippet"`
+	CreateAt       string  `json:"create_at"`
+	Category       uint    `json:"category"`
+	Tags           []uint  `json:"tags"`
+	Score          float64 `json:"score"`
+}
+
+// SearchFacet 分类或标签的结果数
+type SearchFacet struct {
+	ID    uint   `json:"id"`
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// SearchResult 搜索结果, Total 为过滤后的总数, 分面统计不受分类和标签过滤影响
+type SearchResult struct {
+	Total      int           `json:"total"`
+	Hits       []SearchHit   `json:"hits"`
+	Categories []SearchFacet `json:"categories"`
+	Tags       []SearchFacet `json:"tags"`
+}
+
+// 索引里的一篇博文
+type searchDoc struct {
+	article      Article
+	text         string
+	categoryName string
+	tags         []Tag
+}
+
+// 倒排索引, postings[词][博文id] 为加权后的词频
+type searchIndex struct {
+	docs     map[uint]*searchDoc
+	postings map[string]map[uint]float64
+}
+
+var (
+	segmenter gse.Segmenter
+
+	searchMu     sync.RWMutex
+	articleIndex = &searchIndex{docs: map[uint]*searchDoc{}, postings: map[string]map[uint]float64{}}
+
+	// 去掉 html 标签得到纯文本
+	stripPolicy = bluemonday.StrictPolicy()
+)
+
+// InitialSearchIndex 加载分词词典并建立搜索索引
+func InitialSearchIndex() {
+	var err error
+
+	segmenter, err = gse.New()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+		}).Fatal("Load segmenter dictionary failed")
+	}
+
+	err = RebuildSearchIndex()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+		}).Fatal("Build search index failed")
+	}
+}
+
+// RebuildSearchIndex 从 mysql 重建搜索索引, 只索引已发布的博文
+func RebuildSearchIndex() error {
+	articles, err := GetAllArticle()
+	if err != nil {
+		return err
+	}
+
+	categories, err := GetAllCategory()
+	if err != nil {
+		return err
+	}
+
+	tags, err := GetAllTag()
+	if err != nil {
+		return err
+	}
+
+	categoryNames := make(map[uint]string)
+	for _, category := range categories {
+		categoryNames[category.ID] = category.Category
+	}
+
+	tagsByID := make(map[uint]Tag)
+	for _, tag := range *tags {
+		tagsByID[tag.ID] = tag
+	}
+
+	idx := &searchIndex{docs: map[uint]*searchDoc{}, postings: map[string]map[uint]float64{}}
+	for _, article := range *articles {
+		doc := &searchDoc{
+			article:      article,
+			text:         articlePlainText(&article),
+			categoryName: categoryNames[article.Category],
+		}
+
+		for _, id := range article.Tags {
+			if tag, ok := tagsByID[id]; ok {
+				doc.tags = append(doc.tags, tag)
+			}
+		}
+
+		idx.add(doc)
+	}
+
+	searchMu.Lock()
+	articleIndex = idx
+	searchMu.Unlock()
+
+	log.WithFields(log.Fields{
+		"count": len(idx.docs),
+	}).Info("Build search index success")
+
+	return nil
+}
+
+// SearchArticles 搜索博文, 按相关度排序
+func SearchArticles(q SearchQuery) *SearchResult {
+	terms := uniqueTerms(tokenize(q.Query))
+
+	searchMu.RLock()
+	idx := articleIndex
+	searchMu.RUnlock()
+
+	// 计算每篇博文的得分, 出现越少的词权重越高
+	scores := make(map[uint]float64)
+	for _, term := range terms {
+		postings := idx.postings[term]
+		if len(postings) == 0 {
+			continue
+		}
+
+		idf := math.Log(1 + float64(len(idx.docs))/float64(len(postings)))
+		for id, tf := range postings {
+			scores[id] += tf * idf
+		}
+	}
+
+	result := &SearchResult{
+		Hits:       []SearchHit{},
+		Categories: []SearchFacet{},
+		Tags:       []SearchFacet{},
+	}
+
+	categoryFacets := make(map[uint]*SearchFacet)
+	tagFacets := make(map[uint]*SearchFacet)
+	var matched []*searchDoc
+
+	for id := range scores {
+		doc := idx.docs[id]
+
+		// 分面统计
+		if _, ok := categoryFacets[doc.article.Category]; !ok {
+			categoryFacets[doc.article.Category] = &SearchFacet{ID: doc.article.Category, Name: doc.categoryName}
+		}
+		categoryFacets[doc.article.Category].Count++
+
+		hasTag := false
+		for _, tag := range doc.tags {
+			if _, ok := tagFacets[tag.ID]; !ok {
+				tagFacets[tag.ID] = &SearchFacet{ID: tag.ID, Name: tag.TagTitle}
+			}
+			tagFacets[tag.ID].Count++
+
+			if tag.ID == q.Tag {
+				hasTag = true
+			}
+		}
+
+		if q.Category != 0 && doc.article.Category != q.Category {
+			continue
+		}
+		if q.Tag != 0 && !hasTag {
+			continue
+		}
+
+		matched = append(matched, doc)
+	}
+
+	sort.Slice(matched, func(i, j int) bool {
+		si, sj := scores[matched[i].article.ID], scores[matched[j].article.ID]
+		if si != sj {
+			return si > sj
+		}
+
+		return matched[i].article.ID > matched[j].article.ID
+	})
+
+	for _, facet := range categoryFacets {
+		result.Categories = append(result.Categories, *facet)
+	}
+	for _, facet := range tagFacets {
+		result.Tags = append(result.Tags, *facet)
+	}
+	sortFacets(result.Categories)
+	sortFacets(result.Tags)
+
+	result.Total = len(matched)
+
+	// 分页
+	start := q.Limit * (q.Page - 1)
+	end := start + q.Limit
+	if start > int64(len(matched)) {
+		start = int64(len(matched))
+	}
+	if end > int64(len(matched)) {
+		end = int64(len(matched))
+	}
+
+	// 高亮时先匹配长的词
+	sort.Slice(terms, func(i, j int) bool {
+		return len([]rune(terms[i])) > len([]rune(terms[j]))
+	})
+
+	for _, doc := range matched[start:end] {
+		hit := SearchHit{
+			ID:             doc.article.ID,
+			Slug:           doc.article.Slug,
+			ArticleTitle:   doc.article.ArticleTitle,
+			TitleHighlight: highlight([]rune(doc.article.ArticleTitle), terms, 0, -1),
+			Snippet:        snippet(doc.text, terms),
+			CreateAt:       doc.article.CreateAt,
+			Category:       doc.article.Category,
+			Tags:           []uint{},
+			Score:          scores[doc.article.ID],
+		}
+		for _, tag := range doc.tags {
+			hit.Tags = append(hit.Tags, tag.ID)
+		}
+
+		result.Hits = append(result.Hits, hit)
+	}
+
+	return result
+}
+
+// 把博文加入索引
+func (idx *searchIndex) add(doc *searchDoc) {
+	id := doc.article.ID
+	idx.docs[id] = doc
+
+	fields := []struct {
+		text   string
+		weight float64
+	}{
+		{doc.article.ArticleTitle, searchWeightTitle},
+		{doc.categoryName, searchWeightCategory},
+		{doc.article.ArticlePreviewText, searchWeightPreview},
+		{doc.text, searchWeightContent},
+	}
+	for _, tag := range doc.tags {
+		fields = append(fields, struct {
+			text   string
+			weight float64
+		}{tag.TagTitle, searchWeightTag})
+	}
+
+	for _, field := range fields {
+		for _, term := range tokenize(field.text) {
+			if idx.postings[term] == nil {
+				idx.postings[term] = make(map[uint]float64)
+			}
+
+			idx.postings[term][id] += field.weight
+		}
+	}
+}
+
+// 分词, 转成小写并去掉标点和空白
+func tokenize(text string) []string {
+	var tokens []string
+
+	for _, token := range segmenter.CutSearch(strings.ToLower(text), true) {
+		token = strings.TrimSpace(token)
+		if token == "" || strings.IndexFunc(token, isWordRune) < 0 {
+			continue
+		}
+
+		tokens = append(tokens, token)
+	}
+
+	return tokens
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func uniqueTerms(tokens []string) []string {
+	seen := make(map[string]bool)
+	terms := []string{}
+
+	for _, token := range tokens {
+		if !seen[token] {
+			seen[token] = true
+			terms = append(terms, token)
+		}
+	}
+
+	return terms
+}
+
+// 博文的纯文本内容, 优先使用渲染后的 html
+func articlePlainText(article *Article) string {
+	if article.ArticleHTML == "" {
+		return article.ArticleContent
+	}
+
+	return html.UnescapeString(stripPolicy.Sanitize(article.ArticleHTML))
+}
+
+// 截取第一个匹配位置附近的内容作为摘要
+func snippet(text string, terms []string) string {
+	runes := []rune(text)
+	lower := []rune(strings.ToLower(text))
+	if len(lower) != len(runes) {
+		lower = runes
+	}
+
+	pos := -1
+	for i := range lower {
+		if matchTerm(lower, i, terms) > 0 {
+			pos = i
+
+			break
+		}
+	}
+
+	if pos < 0 {
+		pos = 0
+	}
+
+	start := pos - searchSnippetBefore
+	if start < 0 {
+		start = 0
+	}
+
+	end := pos + searchSnippetAfter
+	if end > len(runes) {
+		end = len(runes)
+	}
+
+	s := highlight(runes, terms, start, end)
+	if start > 0 {
+		s = "..." + s
+	}
+	if end < len(runes) {
+		s += "..."
+	}
+
+	return s
+}
+
+// 转义 runes[start:end] 并用 <em> 标出匹配的词, end 为 -1 时到结尾
+func highlight(runes []rune, terms []string, start int, end int) string {
+	if end < 0 {
+		end = len(runes)
+	}
+
+	lower := []rune(strings.ToLower(string(runes)))
+	if len(lower) != len(runes) {
+		lower = runes
+	}
+
+	var buf strings.Builder
+	last := start
+	for i := start; i < end; {
+		n := matchTerm(lower[:end], i, terms)
+		if n == 0 {
+			i++
+
+			continue
+		}
+
+		buf.WriteString(html.EscapeString(string(runes[last:i])))
+		buf.WriteString("<em>")
+		buf.WriteString(html.EscapeString(string(runes[i : i+n])))
+		buf.WriteString("</em>")
+		i += n
+		last = i
+	}
+	buf.WriteString(html.EscapeString(string(runes[last:end])))
+
+	return buf.String()
+}
+
+// 返回从 i 开始匹配到的词的长度, 没有匹配返回 0, terms 需要按长度从长到短排列
+func matchTerm(text []rune, i int, terms []string) int {
+	for _, term := range terms {
+		t := []rune(term)
+		if i+len(t) > len(text) {
+			continue
+		}
+
+		if string(text[i:i+len(t)]) == term {
+			return len(t)
+		}
+	}
+
+	return 0
+}
+
+// 分面按结果数从多到少排列
+func sortFacets(facets []SearchFacet) {
+	sort.Slice(facets, func(i, j int) bool {
+		if facets[i].Count != facets[j].Count {
+			return facets[i].Count > facets[j].Count
+		}
+
+		return facets[i].ID < facets[j].ID
+	})
+}
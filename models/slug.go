@@ -0,0 +1,251 @@
+/*
+* 博文 slug
+*
+* slug 由博文标题生成，中文标题转换成拼音，保证 url 只包含 ascii 字符。
+* 修改标题后旧的 slug 保存在 article_slug 表里，用来把旧链接重定向到新链接
+ */
+
+package models
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/mozillazg/go-pinyin"
+	log "github.com/sirupsen/logrus"
+)
+
+// SlugLengthMax slug 最大长度
+const SlugLengthMax = 80
+
+// 保存博文时 slug 和别的博文重复, 最多重新生成几次
+const slugRetryMax = 3
+
+const (
+	qGetArticleBySlug      = "SELECT " + articleColumns + " FROM article WHERE slug = ? AND " + publicArticle
+	qGetArticleIDBySlug    = "SELECT id FROM article WHERE slug = ?"
+	qGetSlugHistory        = "SELECT article_id FROM article_slug WHERE slug = ?"
+	qAddSlugHistory        = "INSERT INTO article_slug (article_id, slug, create_at) VALUES (?, ?, ?)"
+	qDeleteSlugHistory     = "DELETE FROM article_slug WHERE article_id = ? AND slug = ?"
+	qUpdateArticleSlug     = "UPDATE article SET slug = ? WHERE id = ?"
+	qGetArticleWithoutSlug = "SELECT id, article_title FROM article WHERE slug = ''"
+	qClearDuplicateSlug    = "UPDATE article a JOIN article b ON a.slug = b.slug AND a.id > b.id SET a.slug = '' WHERE a.slug <> ''"
+	qUniqueSlugIndex       = `SELECT COUNT(*) FROM information_schema.statistics
+						WHERE table_schema = DATABASE() AND table_name = 'article' AND index_name = 'slug' AND non_unique = 0`
+	qAddUniqueSlugIndex = "ALTER TABLE article DROP INDEX slug, ADD UNIQUE KEY slug (slug)"
+)
+
+// 拼音转换参数, 不带声调
+var pinyinArgs = pinyin.NewArgs()
+
+// Slugify 把标题转换成 slug, 中文转换成拼音, 其他非字母数字的字符作为分隔符
+func Slugify(title string) string {
+	slug := slugWords(title)
+	if slug == "" {
+		slug = "article"
+	}
+
+	return slug
+}
+
+// 把字符串转换成用 - 连接的小写单词, 没有可用的字符时返回空字符串
+func slugWords(title string) string {
+	var words []string
+	var word []rune
+
+	// 结束当前的英文单词或数字
+	flush := func() {
+		if len(word) != 0 {
+			words = append(words, string(word))
+			word = word[:0]
+		}
+	}
+
+	for _, r := range strings.ToLower(title) {
+		switch {
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			word = append(word, r)
+		case unicode.Is(unicode.Han, r):
+			flush()
+			words = append(words, pinyin.LazyPinyin(string(r), pinyinArgs)...)
+		default:
+			flush()
+		}
+	}
+	flush()
+
+	// 按单词截断, 避免 slug 过长
+	slug := ""
+	for _, w := range words {
+		if slug == "" {
+			slug = w
+		} else if len(slug)+1+len(w) <= SlugLengthMax {
+			slug += "-" + w
+		} else {
+			break
+		}
+	}
+
+	if len(slug) > SlugLengthMax {
+		slug = slug[:SlugLengthMax]
+	}
+
+	return slug
+}
+
+// UniqueSlug 根据标题生成不重复的 slug, 重复时在后面加上序号
+// 别的博文正在使用或者曾经使用过的 slug 都算重复, articleID 为当前博文的 id, 新博文为 0
+func UniqueSlug(title string, articleID uint) (string, error) {
+	base := Slugify(title)
+	slug := base
+
+	for i := 2; ; i++ {
+		owner, err := slugOwner(slug)
+		if err != nil {
+			return "", err
+		}
+
+		if owner == 0 || owner == articleID {
+			return slug, nil
+		}
+
+		slug = base + "-" + strconv.Itoa(i)
+	}
+}
+
+// GetArticleBySlug 根据 slug 查询已发布的博文
+func GetArticleBySlug(slug string) (*Article, error) {
+	var article Article
+
+	err := scanArticle(DB.QueryRow(qGetArticleBySlug, slug), &article)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+			"slug":     slug,
+		}).Info("Query article by slug failed")
+
+		return nil, err
+	}
+
+	return loadOneArticleTags(&article)
+}
+
+// GetArticleIDBySlugHistory 根据旧的 slug 查询博文 id, 不存在时返回 sql.ErrNoRows
+func GetArticleIDBySlugHistory(slug string) (uint, error) {
+	var id uint
+
+	err := DB.QueryRow(qGetSlugHistory, slug).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// 博文 slug 改变后在事务 tx 里记录旧的 slug
+// 如果新的 slug 以前用过, 把它从历史记录里删除, 避免重定向到自己
+func setSlugHistory(tx *sql.Tx, articleID uint, oldSlug string, newSlug string) error {
+	if oldSlug == newSlug {
+		return nil
+	}
+
+	_, err := tx.Exec(qDeleteSlugHistory, articleID, newSlug)
+	if err != nil {
+		return err
+	}
+
+	if oldSlug != "" {
+		_, err = tx.Exec(qAddSlugHistory, articleID, oldSlug, time.Now().Format("2006-01-02 15:04:05"))
+	}
+
+	return err
+}
+
+// InitialArticleSlug 给还没有 slug 的旧博文和 slug 重复的博文生成 slug, 然后给 slug 加上唯一索引
+func InitialArticleSlug() {
+	// 以前没有唯一索引时可能有重复的 slug, 保留 id 最小的博文的 slug, 其他的重新生成
+	_, err := DB.Exec(qClearDuplicateSlug)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+		}).Fatal("Clear duplicate article slug failed")
+	}
+
+	rows, err := DB.Query(qGetArticleWithoutSlug)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+		}).Fatal("Query article without slug failed")
+	}
+
+	type titleRow struct {
+		id    uint
+		title string
+	}
+
+	var articles []titleRow
+	for rows.Next() {
+		var a titleRow
+		err = rows.Scan(&a.id, &a.title)
+		articles = append(articles, a)
+	}
+	rows.Close()
+	if err == nil {
+		err = rows.Err()
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+		}).Fatal("Rows scan failed")
+	}
+
+	for _, a := range articles {
+		slug, err := UniqueSlug(a.title, a.id)
+		if err == nil {
+			_, err = DB.Exec(qUpdateArticleSlug, slug, a.id)
+		}
+		if err != nil {
+			log.WithFields(log.Fields{
+				"errorMsg": err,
+				"id":       a.id,
+			}).Info("Generate article slug failed")
+		}
+	}
+
+	if len(articles) != 0 {
+		log.WithFields(log.Fields{
+			"count": len(articles),
+		}).Info("Generate article slug success")
+	}
+
+	var unique int
+	err = DB.QueryRow(qUniqueSlugIndex).Scan(&unique)
+	if err == nil && unique == 0 {
+		_, err = DB.Exec(qAddUniqueSlugIndex)
+	}
+	if err != nil {
+		// 还有博文没有生成 slug 时不能加唯一索引, 下次启动再试
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+		}).Info("Add article slug unique index failed")
+	}
+}
+
+// 查询 slug 属于哪个博文, 没有博文使用时返回 0
+func slugOwner(slug string) (uint, error) {
+	var id uint
+
+	err := DB.QueryRow(qGetArticleIDBySlug, slug).Scan(&id)
+	if err == sql.ErrNoRows {
+		id, err = GetArticleIDBySlugHistory(slug)
+	}
+
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+
+	return id, err
+}
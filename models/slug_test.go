@@ -0,0 +1,51 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSlugify(t *testing.T) {
+	tests := []struct {
+		title string
+		want  string
+	}{
+		{"Hello World", "hello-world"},
+		{"  Go 1.9 Released!  ", "go-1-9-released"},
+		{"C++/Rust & Go", "c-rust-go"},
+		{"---", "article"},
+		{"", "article"},
+		{"Ünïcödé", "n-c-d"},
+		{"中文标题", "zhong-wen-biao-ti"},
+		{"Go 语言", "go-yu-yan"},
+	}
+
+	for _, test := range tests {
+		got := Slugify(test.title)
+		if got != test.want {
+			t.Errorf("Slugify(%q) = %q, want %q", test.title, got, test.want)
+		}
+	}
+}
+
+func TestSlugifyLength(t *testing.T) {
+	tests := []struct {
+		title string
+		want  string
+	}{
+		// 按单词截断, 不会截断在单词中间
+		{strings.Repeat("word ", 30), strings.TrimSuffix(strings.Repeat("word-", 16), "-")},
+		// 单个单词超过最大长度时直接截断
+		{strings.Repeat("a", SlugLengthMax+10), strings.Repeat("a", SlugLengthMax)},
+	}
+
+	for _, test := range tests {
+		got := Slugify(test.title)
+		if got != test.want {
+			t.Errorf("Slugify(%q) = %q, want %q", test.title, got, test.want)
+		}
+		if len(got) > SlugLengthMax {
+			t.Errorf("Slugify(%q) length %d, want <= %d", test.title, len(got), SlugLengthMax)
+		}
+	}
+}
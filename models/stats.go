@@ -0,0 +1,74 @@
+package models
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	qGetArticleStatusCount    = "SELECT status, COUNT(*) FROM article WHERE is_trash = 0 GROUP BY status"
+	qGetTrashArticleCount     = "SELECT COUNT(*) FROM article WHERE is_trash = 1"
+	qGetAllUserCount          = "SELECT COUNT(*) FROM user"
+	qGetPendingCommentCount   = "SELECT COUNT(*) FROM comment WHERE status = '" + CommentStatusPending + "'"
+	qGetPendingGuestbookCount = "SELECT COUNT(*) FROM guestbook WHERE status = '" + CommentStatusPending + "'"
+)
+
+// SiteStats 站点统计
+type SiteStats struct {
+	Articles         map[string]int64 `json:"articles"`
+	TrashArticles    int64            `json:"trash_articles"`
+	Users            int64            `json:"users"`
+	PendingComments  int64            `json:"pending_comments"`
+	PendingGuestbook int64            `json:"pending_guestbook"`
+}
+
+// GetSiteStats 统计各个状态的博文数、用户数和待审核的评论留言数
+func GetSiteStats() (*SiteStats, error) {
+	stats := &SiteStats{Articles: map[string]int64{}}
+
+	rows, err := DB.Query(qGetArticleStatusCount)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+		}).Info("Query article status count failed")
+
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var status string
+		var count int64
+		err = rows.Scan(&status, &count)
+		if err != nil {
+			return nil, err
+		}
+
+		stats.Articles[status] = count
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, count := range []struct {
+		query string
+		dest  *int64
+	}{
+		{qGetTrashArticleCount, &stats.TrashArticles},
+		{qGetAllUserCount, &stats.Users},
+		{qGetPendingCommentCount, &stats.PendingComments},
+		{qGetPendingGuestbookCount, &stats.PendingGuestbook},
+	} {
+		err = DB.QueryRow(count.query).Scan(count.dest)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"errorMsg": err,
+				"query":    count.query,
+			}).Info("Query site stats failed")
+
+			return nil, err
+		}
+	}
+
+	return stats, nil
+}
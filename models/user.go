@@ -1,21 +1,51 @@
 package models
 
 import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// ErrUserIDExist 用户ID已经被其他用户使用
+var ErrUserIDExist = errors.New("User ID already exist")
+
 // User  定义User结构体
 type User struct {
-	ID          uint   `db:"id" json:"id"`
-	UserID      string `db:"user_id" json:"user_id"`
-	Password    string `db:"password" json:"password"`
-	UserName    string `db:"user_name" json:"user_name"`
-	Image       string `db:"image" json:"image"`
-	CreateAt    string `db:"create_at" json:"create_at"`
-	LastLoginAt string `db:"last_login_at" json:"last_login_at"`
-	LoginCount  uint   `db:"login_count" json:"login_count"`
-	IsBlacklist bool   `db:"is_blacklist" json:"is_blacklist"`
+	ID            uint   `db:"id" json:"id"`
+	UserID        string `db:"user_id" json:"user_id"`
+	Password      string `db:"password" json:"-"`
+	Email         string `db:"email" json:"email"`
+	EmailVerified bool   `db:"email_verified" json:"email_verified"`
+	UserName      string `db:"user_name" json:"user_name"`
+	Image         string `db:"image" json:"image"`
+	CreateAt      string `db:"create_at" json:"create_at"`
+	LastLoginAt   string `db:"last_login_at" json:"last_login_at"`
+	LoginCount    uint   `db:"login_count" json:"login_count"`
+	IsBlacklist   bool   `db:"is_blacklist" json:"is_blacklist"`
+	BanReason     string `db:"ban_reason" json:"ban_reason"`
+	BanExpireAt   string `db:"ban_expire_at" json:"ban_expire_at"`
+	Role          string `db:"role" json:"role"`
+}
+
+// IsBanned 用户是否在黑名单里, BanExpireAt 为空时永久封禁, 到期后不再算在黑名单里
+func (u *User) IsBanned() bool {
+	if !u.IsBlacklist {
+		return false
+	}
+	if u.BanExpireAt == "" {
+		return true
+	}
+
+	expire, err := time.Parse(time.RFC3339, u.BanExpireAt)
+	if err != nil {
+		return true
+	}
+
+	return time.Now().Before(expire)
 }
 
 const (
@@ -39,65 +69,109 @@ const (
 )
 
 const (
-	qGetAllUser = "SELECT id, user_id, user_name, image, last_login_at, login_count, is_blacklist FROM user"
-	qInsertUser = `INSERT INTO user 
-						(user_id, user_name, password, image, create_at, last_login_at, login_count, is_blacklist)
+	userColumns = "id, user_id, user_name, password, email, email_verified, image, create_at, last_login_at, login_count, is_blacklist, ban_reason, ban_expire_at, role"
+
+	qGetUserCount  = "SELECT COUNT(*) FROM user WHERE "
+	qGetUserByPage = "SELECT " + userColumns + " FROM user WHERE "
+	qBanUser       = "UPDATE user SET is_blacklist = 1, ban_reason = ?, ban_expire_at = ? WHERE user_id = ?"
+	qUnbanUser     = "UPDATE user SET is_blacklist = 0, ban_reason = '', ban_expire_at = NULL WHERE user_id = ?"
+	qInsertUser    = `INSERT INTO user 
+						(user_id, user_name, password, email, image, create_at, last_login_at, login_count, is_blacklist)
 						VALUES
-						(?, ?, ?, ?, ?, ?, ?, ?)`
-	qGetUserByUserID     = "SELECT id, user_id, user_name, password, image, create_at, last_login_at, login_count, is_blacklist FROM user WHERE user_id = ?"
+						(?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	qGetUserByUserID     = "SELECT " + userColumns + " FROM user WHERE user_id = ?"
+	qGetUserByEmail      = "SELECT " + userColumns + " FROM user WHERE email = ? AND email_verified = 1 LIMIT 1"
+	qGetEmailOwner       = "SELECT user_id FROM user WHERE email = ? AND email_verified = 1 AND user_id <> ? LIMIT 1"
+	qUpdateUserEmail     = "UPDATE user SET email = ?, email_verified = 0 WHERE user_id = ?"
+	qVerifyUserEmail     = "UPDATE user SET email_verified = 1 WHERE user_id = ? AND email = ?"
 	qUpdateUserID        = "UPDATE user SET user_id = ? WHERE user_id = ?"
 	qUpdateUserName      = "UPDATE user SET user_name = ? WHERE user_name = ?"
 	qUpdateUserPassword  = "UPDATE user SET password = ? WHERE user_id = ?"
 	qGetPasswordByUserID = "SELECT password FROM user WHERE user_id = ?"
 )
 
-// GetAllUser 获取所有注册用户信息
-func GetAllUser() (*[]User, error) {
-	var u User
-	var users []User
+// UserFilter 用户列表的筛选条件, Keyword 匹配用户 ID 和用户名, Banned 为 nil 时不筛选
+type UserFilter struct {
+	Keyword string
+	Banned  *bool
+}
+
+func (f *UserFilter) where() (string, []interface{}) {
+	conds := []string{"1 = 1"}
+	args := []interface{}{}
 
-	// 根据管理员ID查询管理员信息
-	rows, err := DB.Query(qGetAllUser)
+	if f.Keyword != "" {
+		like := "%" + strings.NewReplacer("\\", "\\\\", "%", "\\%", "_", "\\_").Replace(f.Keyword) + "%"
+		conds = append(conds, "(user_id LIKE ? OR user_name LIKE ?)")
+		args = append(args, like, like)
+	}
+
+	if f.Banned != nil {
+		if *f.Banned {
+			conds = append(conds, "is_blacklist = 1 AND (ban_expire_at IS NULL OR ban_expire_at > ?)")
+		} else {
+			conds = append(conds, "(is_blacklist = 0 OR ban_expire_at <= ?)")
+		}
+		args = append(args, time.Now().Format("2006-01-02 15:04:05"))
+	}
+
+	return strings.Join(conds, " AND "), args
+}
+
+// GetUsers 分页获取注册用户信息, 按注册先后排列, 同时返回总数
+func GetUsers(filter *UserFilter, limit int64, page int64) (*[]User, int64, error) {
+	var count int64
+
+	where, args := filter.where()
+
+	err := DB.QueryRow(qGetUserCount+where, args...).Scan(&count)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"errorMsg": err,
-		}).Info("Query all user failed")
+		}).Info("Query user count failed")
 
-		return nil, err
+		return nil, 0, err
+	}
+
+	rows, err := DB.Query(qGetUserByPage+where+" ORDER BY id ASC LIMIT ?, ?", append(args, limit*(page-1), limit)...)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+		}).Info("Query users failed")
+
+		return nil, 0, err
 	}
 	defer rows.Close()
 
+	users := []User{}
 	for rows.Next() {
-		// 这里以后优化性能
-		err = rows.Scan(
-			&u.ID,
-			&u.UserID,
-			&u.UserName,
-			&u.Image,
-			&u.CreateAt,
-			&u.LastLoginAt,
-			&u.LoginCount,
-			&u.IsBlacklist)
-		users = append(users, u)
+		var user User
+		err = scanUser(rows, &user)
+		if err != nil {
+			break
+		}
+
+		users = append(users, user)
+	}
+	if err == nil {
+		err = rows.Err()
 	}
-	err = rows.Err()
 	if err != nil {
 		log.WithFields(log.Fields{
 			"errorMsg": err,
 		}).Info("Rows scan failed")
 
-		return nil, err
+		return nil, 0, err
 	}
 
-	return &users, nil
+	return &users, count, nil
 }
 
 // GetUserByUserID 根据 UserID 从数据库获取数据
 func GetUserByUserID(userID string) (*User, error) {
 	var user User
 
-	row := DB.QueryRow(qGetUserByUserID, userID)
-	err := row.Scan(&user.ID, &user.UserID, &user.UserName, &user.Password, &user.Image, &user.CreateAt, &user.LastLoginAt, &user.LoginCount, &user.IsBlacklist)
+	err := scanUser(DB.QueryRow(qGetUserByUserID, userID), &user)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"errorMsg": err,
@@ -110,19 +184,22 @@ func GetUserByUserID(userID string) (*User, error) {
 	return &user, nil
 }
 
-// UpdateUserID 更改用户ID
+// UpdateUserID 更改用户ID, 用户写的博文的作者也一起修改, 新的用户ID已经存在时返回 ErrUserIDExist
 func UpdateUserID(oldUserID string, newUserID string) error {
-	stmt, err := DB.Prepare(qUpdateUserID)
+	tx, err := DB.Begin()
 	if err != nil {
 		log.WithFields(log.Fields{
 			"errorMsg": err,
-		}).Info("Sql prepare failed")
+		}).Info("Begin transaction failed")
 
 		return err
 	}
-	defer stmt.Close()
+	defer tx.Rollback()
 
-	_, err = stmt.Exec(newUserID, oldUserID)
+	_, err = tx.Exec(qUpdateUserID, newUserID, oldUserID)
+	if isDuplicateKey(err) {
+		return ErrUserIDExist
+	}
 	if err != nil {
 		log.WithFields(log.Fields{
 			"errorMsg": err,
@@ -131,7 +208,16 @@ func UpdateUserID(oldUserID string, newUserID string) error {
 		return err
 	}
 
-	return nil
+	_, err = tx.Exec(qUpdateArticleAuthor, newUserID, oldUserID)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+		}).Info("Update article author failed")
+
+		return err
+	}
+
+	return tx.Commit()
 }
 
 // UpdateUserName 更改用户名字
@@ -192,7 +278,7 @@ func UpdateUserPassword(userID string, password string) error {
 	return nil
 }
 
-// UserRegister 用户信息存储进数据库
+// UserRegister 用户信息存储进数据库, 用户ID已经存在时返回 ErrUserIDExist
 func UserRegister(user *User) error {
 	// 加密密码
 	hp, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
@@ -214,7 +300,10 @@ func UserRegister(user *User) error {
 	}
 	defer stmt.Close()
 
-	_, err = stmt.Exec(user.UserID, user.UserName, string(hp), user.Image, user.CreateAt, user.LastLoginAt, user.LoginCount, user.IsBlacklist)
+	_, err = stmt.Exec(user.UserID, user.UserName, string(hp), user.Email, user.Image, user.CreateAt, user.LastLoginAt, user.LoginCount, user.IsBlacklist)
+	if isDuplicateKey(err) {
+		return ErrUserIDExist
+	}
 	if err != nil {
 		log.WithFields(log.Fields{
 			"errorMsg": err,
@@ -243,3 +332,130 @@ func GetPasswordByUserID(userID string) (string, error) {
 
 	return password, nil
 }
+
+// GetUserByEmail 根据已验证的邮箱获取用户, 不存在时返回 sql.ErrNoRows
+func GetUserByEmail(email string) (*User, error) {
+	var user User
+
+	err := scanUser(DB.QueryRow(qGetUserByEmail, email), &user)
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// CheckEmailUsed 检查邮箱是否已经被别的用户验证
+func CheckEmailUsed(userID string, email string) (bool, error) {
+	var owner string
+
+	err := DB.QueryRow(qGetEmailOwner, email, userID).Scan(&owner)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+		}).Info("Query email owner failed")
+
+		return false, err
+	}
+
+	return true, nil
+}
+
+// UpdateUserEmail 修改用户邮箱, 新邮箱需要重新验证
+func UpdateUserEmail(userID string, email string) error {
+	_, err := DB.Exec(qUpdateUserEmail, email, userID)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+			"userID":   userID,
+		}).Info("Update user email failed")
+
+		return err
+	}
+
+	return nil
+}
+
+// VerifyUserEmail 验证用户邮箱, 邮箱已经修改时返回 sql.ErrNoRows
+func VerifyUserEmail(userID string, email string) error {
+	user, err := GetUserByUserID(userID)
+	if err != nil {
+		return err
+	}
+	if user.Email != email {
+		return sql.ErrNoRows
+	}
+
+	_, err = DB.Exec(qVerifyUserEmail, userID, email)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+			"userID":   userID,
+		}).Info("Verify user email failed")
+
+		return err
+	}
+
+	return nil
+}
+
+// BanUser 把用户加入黑名单, expireAt 为空时永久封禁
+func BanUser(userID string, reason string, expireAt string) error {
+	var expire interface{}
+	if expireAt != "" {
+		expire = expireAt
+	}
+
+	_, err := DB.Exec(qBanUser, reason, expire, userID)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+			"userID":   userID,
+		}).Info("Ban user failed")
+
+		return err
+	}
+
+	return nil
+}
+
+// UnbanUser 把用户移出黑名单
+func UnbanUser(userID string) error {
+	_, err := DB.Exec(qUnbanUser, userID)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+			"userID":   userID,
+		}).Info("Unban user failed")
+
+		return err
+	}
+
+	return nil
+}
+
+func scanUser(row rowScanner, user *User) error {
+	var expire sql.NullString
+
+	err := row.Scan(
+		&user.ID,
+		&user.UserID,
+		&user.UserName,
+		&user.Password,
+		&user.Email,
+		&user.EmailVerified,
+		&user.Image,
+		&user.CreateAt,
+		&user.LastLoginAt,
+		&user.LoginCount,
+		&user.IsBlacklist,
+		&user.BanReason,
+		&expire,
+		&user.Role)
+	user.BanExpireAt = expire.String
+
+	return err
+}
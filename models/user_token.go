@@ -0,0 +1,109 @@
+/*
+* 用户的一次性 token
+*
+* 用于重置密码和验证邮箱。token 只发给用户，redis 里以 token 的 sha256 作为 key，
+* 使用一次后删除。同一个用户再次申请时，之前的同类 token 失效
+ */
+
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+// 一次性 token 的种类
+const (
+	// UserTokenPasswordReset 重置密码
+	UserTokenPasswordReset = "password_reset"
+
+	// UserTokenEmailVerify 验证邮箱, Value 为要验证的邮箱
+	UserTokenEmailVerify = "email_verify"
+)
+
+// ErrUserTokenInvalid token 不存在、已使用或者已过期
+var ErrUserTokenInvalid = errors.New("Token is invalid or expired")
+
+// UserToken 一次性 token 保存的内容
+type UserToken struct {
+	UserID string `json:"user_id"`
+	Value  string `json:"value"`
+}
+
+// CreateUserToken 生成一次性 token, ttl 后过期
+func CreateUserToken(kind string, userID string, value string, ttl time.Duration) (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+
+	data, err := json.Marshal(&UserToken{UserID: userID, Value: value})
+	if err != nil {
+		return "", err
+	}
+
+	// 删除这个用户之前的同类 token
+	userKey := kind + "_user_" + userID
+	old, err := RedisClient.Get(userKey).Result()
+	if err != nil && err != redis.Nil {
+		return "", err
+	}
+
+	_, err = RedisClient.TxPipelined(func(pipe redis.Pipeliner) error {
+		if old != "" {
+			pipe.Del(kind + "_" + old)
+		}
+		pipe.Set(kind+"_"+hashUserToken(token), data, ttl)
+		pipe.Set(userKey, hashUserToken(token), ttl)
+
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// ConsumeUserToken 使用一次性 token, 使用后删除
+func ConsumeUserToken(kind string, token string) (*UserToken, error) {
+	var get *redis.StringCmd
+
+	key := kind + "_" + hashUserToken(token)
+	_, err := RedisClient.TxPipelined(func(pipe redis.Pipeliner) error {
+		get = pipe.Get(key)
+		pipe.Del(key)
+
+		return nil
+	})
+	if err == redis.Nil {
+		return nil, ErrUserTokenInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var userToken UserToken
+	err = json.Unmarshal([]byte(get.Val()), &userToken)
+	if err != nil {
+		return nil, err
+	}
+
+	RedisClient.Del(kind + "_user_" + userToken.UserID)
+
+	return &userToken, nil
+}
+
+func hashUserToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+
+	return hex.EncodeToString(sum[:])
+}
@@ -13,6 +13,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/ztplz/blog-server/controllers"
 	"github.com/ztplz/blog-server/middlewares"
+	"github.com/ztplz/blog-server/models"
 )
 
 // InitialRouter  初始化路由
@@ -22,20 +23,26 @@ func InitialRouter() {
 	// 设置单次上传文件最大限制, 允许超过 8M
 	r.MaxMultipartMemory = 8 << 20
 
+	// 给每个请求分配 id，响应头 X-Request-ID
+	r.Use(middlewares.RequestIDMiddleware())
+
 	r.Use(middlewares.CORSMiddleware())
 
 	// 统计访问人数
 	r.Use(middlewares.CountVisitorMiddleware())
 
-	// 管理员权限
-	admin := r.Group("/api/v1/admin")
+	// 管理员登录
+	r.POST("/api/v1/admin", middlewares.AuditMiddleware("admin"), controllers.AdminLoginHandler)
+
+	// 用 refresh token 换新的 token，管理员和用户通用，refresh_token，审计日志里记录换到 token 的会话
+	r.POST("/api/v1/token/refresh", middlewares.AuditMiddleware("session"), controllers.RefreshTokenHandler)
+
+	// 管理员权限，需鉴定token，修改操作记录审计日志
+	admin := r.Group("/api/v1/admin", middlewares.AdminAuthMiddleware(), middlewares.AuditMiddleware("admin"))
 	{
-		// 获取管理员信息，需鉴定token
+		// 获取管理员信息
 		admin.GET("", controllers.GetAdminInfo)
 
-		// 后台登录
-		admin.POST("", controllers.AdminLoginHandler)
-
 		// 更改管理员密码
 		admin.PUT("/password", controllers.AdminUpdatePasswordhandler)
 
@@ -45,21 +52,183 @@ func InitialRouter() {
 		// 上传管理员头像
 		admin.PUT("/image", controllers.AdminUploadImageHandler)
 
-		// 管理员退出后台
+		// 管理员退出后台，只退出当前设备
 		admin.DELETE("", controllers.AdminLogout)
+
+		// 查看所有登录的设备
+		admin.GET("/sessions", controllers.GetAdminSessionsHandler)
+
+		// 注销某个设备的登录
+		admin.DELETE("/sessions/:sid", controllers.RevokeAdminSessionHandler)
+
+		// 注销所有设备的登录，except_current=true 时保留当前设备
+		admin.DELETE("/sessions", controllers.RevokeAdminSessionsHandler)
+
+		// 获取所有管理员
+		admin.GET("/accounts", controllers.GetAdminsHandler)
+
+		// 新增管理员，admin_id password admin_name
+		admin.POST("/accounts", controllers.AddAdminHandler)
+
+		// 修改某个管理员，只修改提交的字段，提交 password 时重置密码
+		admin.PUT("/accounts/:id", controllers.UpdateAdminHandler)
+
+		// 删除某个管理员，不能删除自己和最后一个管理员
+		admin.DELETE("/accounts/:id", controllers.DeleteAdminHandler)
+
+		// 两步验证状态
+		admin.GET("/totp", controllers.GetAdminTOTPHandler)
+
+		// 生成两步验证密钥和 otpauth 链接
+		admin.POST("/totp", controllers.EnrollAdminTOTPHandler)
+
+		// 提交验证码确认启用两步验证，返回恢复码
+		admin.POST("/totp/confirm", controllers.ConfirmAdminTOTPHandler)
+
+		// 关闭两步验证，password(管理员密码)
+		admin.DELETE("/totp", controllers.DisableAdminTOTPHandler)
+
+		// 重新生成恢复码
+		admin.POST("/totp/recovery-codes", controllers.RegenerateRecoveryCodesHandler)
+
+		// 敏感操作前提交验证码，code(验证码或恢复码)
+		admin.POST("/totp/verify", controllers.AdminStepUpHandler)
+
+		// 查看登录失败被锁定的账号和 ip
+		admin.GET("/lockouts", controllers.GetLoginLocksHandler)
+
+		// 解除锁定，kind(admin|user|ip) key(账号或 ip)
+		admin.DELETE("/lockouts/:kind/:key", controllers.ClearLoginLockHandler)
+
+		// 获取和修改 robots.txt 规则
+		admin.GET("/robots", controllers.GetRobotsHandler)
+		admin.PUT("/robots", controllers.UpdateRobotsHandler)
+
+		// 获取和修改敏感词
+		admin.GET("/sensitive-words", controllers.GetSensitiveWordsHandler)
+		admin.PUT("/sensitive-words", controllers.UpdateSensitiveWordsHandler)
+
+		// 审计日志，actor_type(admin|user|anonymous) actor_id action(匹配操作) target_type target_id request_id
+		// from/to(日期 2006-01-02) limit(每次返回数) page(页数) format(json|csv，csv 时导出，最多 10000 条)
+		admin.GET("/audit", controllers.GetAuditLogsHandler)
 	}
 
+	// 评论审核，需要 moderate_comments 权限
+	moderation := r.Group("/api/v1/admin", middlewares.PermissionMiddleware(models.PermModerateComments), middlewares.AuditMiddleware("comment"))
+	{
+		// 评论审核队列，status(pending|approved|spam|deleted) limit(每次返回数) page(页数)
+		moderation.GET("/comments", controllers.GetCommentQueueHandler)
+
+		// 批量修改评论的审核状态
+		moderation.PUT("/comments", controllers.BulkUpdateCommentStatusHandler)
+
+		// 修改某条评论的审核状态
+		moderation.PUT("/comments/:id", controllers.UpdateCommentStatusHandler)
+
+		// 留言审核队列，参数和评论审核队列相同
+		moderation.GET("/guestbook", controllers.GetGuestbookQueueHandler)
+
+		// 批量修改留言的审核状态
+		moderation.PUT("/guestbook", controllers.BulkUpdateGuestbookStatusHandler)
+
+		// 修改某条留言的审核状态
+		moderation.PUT("/guestbook/:id", controllers.UpdateGuestbookStatusHandler)
+	}
+
+	// 站点统计，需要 view_stats 权限
+	r.GET("/api/v1/stats", middlewares.PermissionMiddleware(models.PermViewStats), controllers.GetStatsHandler)
+
 	// 博文操作
 	article := r.Group("/api/v1/articles")
 	{
-		//获取所有文章列表， limit(每次返回列表数) page(页数)
+		//获取所有文章列表， limit(每次返回列表数) page(页数) category(分类id) tag(标签id)
+		// from/to(发表日期 2006-01-02) top(true 只返回置顶博文) sort(created|updated|visits)
 		article.GET("", controllers.GetAllArticlesHandler)
 
 		// 根据 id 获取博文
 		article.GET("/:id", controllers.GetArticleByID)
 
+		// 根据 slug 获取博文，旧的 slug 重定向到现在的 slug
+		article.GET("/slug/:slug", controllers.GetArticleBySlugHandler)
+
+		// 获取博文的评论，limit(每次返回顶层评论数) page(页数)
+		article.GET("/:id/comments", controllers.GetCommentsHandler)
+
+		// 评论博文，parent_id 不为 0 时回复评论，游客的评论也记录审计日志
+		article.POST("/:id/comments", middlewares.AuditMiddleware("comment"), controllers.AddCommentHandler)
+	}
+
+	// 后台博文操作，只有 own_articles 权限时只能修改和删除自己的博文
+	adminArticle := r.Group("/api/v1/articles", middlewares.PermissionMiddleware(models.PermManageArticles, models.PermOwnArticles), middlewares.AuditMiddleware("article"))
+	{
 		// 增加博文
-		article.POST("", controllers.AddArticleHandler)
+		adminArticle.POST("", controllers.AddArticleHandler)
+
+		// 修改博文，只修改提交的字段
+		adminArticle.PUT("/:id", controllers.UpdateArticleHandler)
+
+		// 删除博文，博文移入回收站
+		adminArticle.DELETE("/:id", controllers.DeleteArticleHandler)
+
+		// 获取博文的历史版本
+		adminArticle.GET("/:id/revisions", controllers.GetArticleRevisionsHandler)
+
+		// 比较两个历史版本 from(版本id) to(版本id，不填为当前版本)
+		adminArticle.GET("/:id/revisions/diff", controllers.DiffArticleRevisionsHandler)
+
+		// 恢复到某个历史版本
+		adminArticle.PUT("/:id/revisions/:revisionID", controllers.RestoreArticleRevisionHandler)
+	}
+
+	// 后台博文列表，包括草稿、定时和私密博文，status(draft|published|scheduled|private，不填为全部)
+	// 其他参数和博文列表相同，只有 own_articles 权限时只列出自己的博文
+	r.GET("/api/v1/admin/articles", middlewares.PermissionMiddleware(models.PermManageArticles, models.PermOwnArticles), controllers.GetAdminArticlesHandler)
+
+	// 博文回收站
+	trash := r.Group("/api/v1/trash", middlewares.PermissionMiddleware(models.PermManageArticles), middlewares.AuditMiddleware("article"))
+	{
+		// 获取回收站里的博文
+		trash.GET("", controllers.GetTrashArticlesHandler)
+
+		// 从回收站恢复博文
+		trash.PUT("/:id", controllers.RestoreArticleHandler)
+
+		// 从回收站彻底删除博文
+		trash.DELETE("/:id", controllers.PurgeArticleHandler)
+
+		// 清空回收站
+		trash.DELETE("", controllers.PurgeAllArticlesHandler)
+	}
+
+	// 留言板
+	guestbook := r.Group("/api/v1/guestbook")
+	{
+		// 获取留言，置顶的留言在前，limit(每次返回数) page(页数)
+		guestbook.GET("", controllers.GetGuestbookHandler)
+
+		// 留言，游客的留言也记录审计日志
+		guestbook.POST("", middlewares.AuditMiddleware("guestbook"), controllers.AddGuestbookHandler)
+	}
+
+	// 管理员留言板操作
+	adminGuestbook := r.Group("/api/v1/guestbook", middlewares.AdminAuthMiddleware(), middlewares.AuditMiddleware("guestbook"))
+	{
+		// 管理员回复留言
+		adminGuestbook.POST("/:id/replies", controllers.ReplyGuestbookHandler)
+	}
+
+	// 留言板审核操作，需要 moderate_comments 权限
+	moderateGuestbook := r.Group("/api/v1/guestbook", middlewares.PermissionMiddleware(models.PermModerateComments), middlewares.AuditMiddleware("guestbook"))
+	{
+		// 置顶或取消置顶留言
+		moderateGuestbook.PUT("/:id/pin", controllers.PinGuestbookHandler)
+	}
+
+	// 搜索博文
+	search := r.Group("/api/v1/search")
+	{
+		// q(关键词) limit(每次返回数) page(页数) category(分类id) tag(标签id)
+		search.GET("", controllers.SearchHandler)
 	}
 
 	// 分类名操作
@@ -68,11 +237,18 @@ func InitialRouter() {
 		// 获取全部分类名 （article）true 查询每个分类的文章数
 		category.GET("", controllers.GetAllCategoryHandler)
 
+		// 获取某个分类的博文列表，参数和博文列表相同
+		category.GET("/:id/articles", controllers.GetArticleByCategory)
+	}
+
+	// 后台分类名操作，需要 manage_taxonomy 权限
+	adminCategory := r.Group("/api/v1/categories", middlewares.PermissionMiddleware(models.PermManageTaxonomy), middlewares.AuditMiddleware("category"))
+	{
 		// 增加分类名
-		category.POST("", controllers.AddCategoryHandler)
+		adminCategory.POST("", controllers.AddCategoryHandler)
 
 		// category.DELETE("", controllers.DeleteCategoryHandler)
-		category.PUT("/:name", controllers.UpdateCategoryHandler)
+		adminCategory.PUT("/:name", controllers.UpdateCategoryHandler)
 	}
 
 	// 标签操作
@@ -80,39 +256,84 @@ func InitialRouter() {
 	{
 		// 获取所有标签
 		tag.GET("", controllers.GetAllTagHandler)
+	}
 
+	// 后台标签操作，需要 manage_taxonomy 权限
+	adminTag := r.Group("/api/v1/tags", middlewares.PermissionMiddleware(models.PermManageTaxonomy), middlewares.AuditMiddleware("tag"))
+	{
 		// 增加标签
-		tag.POST("", controllers.AddTagHandler)
+		adminTag.POST("", controllers.AddTagHandler)
 
 		// 修改某个标签
-		tag.PUT("/:id", controllers.UpdateTagHandler)
+		adminTag.PUT("/:id", controllers.UpdateTagHandler)
 	}
 
-	// 用户操作
-	user := r.Group("api/v1/user")
+	// 用户操作，注册、登录和重置密码记录审计日志
+	user := r.Group("api/v1/user", middlewares.AuditMiddleware("user"))
 	{
-		// 获取所有用户信息
-		user.GET("", controllers.GetAllUser)
-
-		// 获取某个用户的信息
-		user.GET("/:userID", controllers.GetUserByUserID)
-
 		// 用户注册
 		user.POST("", controllers.RegisterUser)
 
 		// 用户登录
 		user.POST("/login", controllers.UserLoginHandler)
 
+		// 用户验证邮箱，token(邮件里的token)
+		user.POST("/email/verify", controllers.VerifyEmailHandler)
+
+		// 找回密码，user_id 或 email，会向已验证的邮箱发送重置密码的邮件
+		user.POST("/password/forgot", controllers.ForgotPasswordHandler)
+
+		// 重置密码，token(邮件里的token) password(新密码)
+		user.POST("/password/reset", controllers.ResetPasswordHandler)
+	}
+
+	// 用户自己的操作，需鉴定token，只能操作自己的账号
+	userAuth := r.Group("api/v1/user", middlewares.UserAuthMiddleware(), middlewares.AuditMiddleware("user"))
+	{
+		// 获取某个用户的信息
+		userAuth.GET("/:userID", controllers.GetUserByUserID)
+
 		// 用户修改 用户ID
-		user.PUT("/:userID/userID", controllers.UpdateUserID)
+		userAuth.PUT("/:userID/userID", controllers.UpdateUserID)
 
 		// 用户修改用户名字
-		user.PUT("/:userID/userName", controllers.UpdateUserName)
+		userAuth.PUT("/:userID/userName", controllers.UpdateUserName)
 
 		// 用户修改密码
-		user.PUT("/:userID/password", controllers.UpdateUserPassword)
+		userAuth.PUT("/:userID/password", controllers.UpdateUserPassword)
+
+		// 用户修改邮箱，会发送验证邮件
+		userAuth.PUT("/:userID/email", controllers.UpdateUserEmailHandler)
+
+		// 用户查看所有登录的设备
+		userAuth.GET("/:userID/sessions", controllers.GetUserSessionsHandler)
+
+		// 用户注销某个设备的登录
+		userAuth.DELETE("/:userID/sessions/:sid", controllers.RevokeUserSessionHandler)
+
+		// 用户注销所有设备的登录，except_current=true 时保留当前设备
+		userAuth.DELETE("/:userID/sessions", controllers.RevokeUserSessionsHandler)
+	}
+
+	// 后台用户操作，需要 manage_users 权限
+	adminUser := r.Group("api/v1/user", middlewares.PermissionMiddleware(models.PermManageUsers), middlewares.AuditMiddleware("user"))
+	{
+		// 管理员获取用户列表，q(匹配用户ID和用户名) banned(true|false) limit(每次返回数) page(页数)
+		adminUser.GET("", controllers.GetAllUser)
+
+		// 管理员封禁用户，reason(原因) expire_at(到期时间，不填为永久封禁)
+		adminUser.PUT("/:userID/ban", controllers.BanUserHandler)
+
+		// 管理员解封用户
+		adminUser.DELETE("/:userID/ban", controllers.UnbanUserHandler)
+
+		// 管理员强制用户退出登录
+		adminUser.DELETE("/:userID/token", controllers.ForceLogoutUserHandler)
 	}
 
+	// 管理员修改用户角色，role(editor|author|moderator，为空时取消角色)
+	r.PUT("api/v1/user/:userID/role", middlewares.AdminAuthMiddleware(), middlewares.AuditMiddleware("user"), controllers.UpdateUserRoleHandler)
+
 	// 访客操作
 	visitor := r.Group("api/v1/visitor")
 	{
@@ -125,6 +346,38 @@ func InitialRouter() {
 		upload.GET("/accesskey", controllers.GetAccesskey)
 	}
 
+	// 博文订阅，content=full 时包含博文全文
+	r.GET("/feed.xml", controllers.RSSFeedHandler)
+	r.GET("/atom.xml", controllers.AtomFeedHandler)
+	r.GET("/feed.json", controllers.JSONFeedHandler)
+
+	// 某个分类的博文订阅
+	categoryFeed := r.Group("/categories/:category")
+	{
+		categoryFeed.GET("/feed.xml", controllers.RSSFeedHandler)
+		categoryFeed.GET("/atom.xml", controllers.AtomFeedHandler)
+		categoryFeed.GET("/feed.json", controllers.JSONFeedHandler)
+	}
+
+	// 某个标签的博文订阅
+	tagFeed := r.Group("/tags/:tag")
+	{
+		tagFeed.GET("/feed.xml", controllers.RSSFeedHandler)
+		tagFeed.GET("/atom.xml", controllers.AtomFeedHandler)
+		tagFeed.GET("/feed.json", controllers.JSONFeedHandler)
+	}
+
+	// sitemap，超过 50000 个地址时 /sitemap.xml 返回索引，/sitemap/1.xml 为第一个 sitemap
+	r.GET("/sitemap.xml", controllers.SitemapHandler)
+	r.GET("/sitemap/:page", controllers.SitemapPageHandler)
+	r.GET("/robots.txt", controllers.RobotsHandler)
+
+	// 验证 token 的公钥，密钥轮换期间包含旧密钥
+	r.GET("/.well-known/jwks.json", controllers.JWKSHandler)
+
+	// 审计日志用路由表把请求路径换回路由
+	middlewares.SetAuditRoutes(r.Routes())
+
 	// 监听8080端口
 	r.Run(":8080")
 }
@@ -0,0 +1,164 @@
+package token
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// RSA 密钥最小长度
+const rsaKeyBitsMin = 2048
+
+// Key 签名或者验证用的密钥, ID 写在 token 头部的 kid 里
+type Key struct {
+	ID     string
+	Method jwt.SigningMethod
+
+	// 私钥, 只用来验证的旧密钥为 nil
+	Private interface{}
+	Public  interface{}
+}
+
+// JWK 公钥的 JSON Web Key 格式 (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+
+	// Ed25519
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// LoadKeys 读取目录里所有的 .pem 文件, 文件名 (不含扩展名) 作为 kid。
+// 支持 RSA 和 Ed25519 的私钥 (PKCS#1, PKCS#8) 和公钥 (PKIX), 只有公钥的文件用于验证轮换前签发的 token
+func LoadKeys(dir string) (map[string]*Key, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]*Key)
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		kid := strings.TrimSuffix(filepath.Base(file), ".pem")
+		key, err := parseKey(kid, data)
+		if err != nil {
+			return nil, errors.New(file + ": " + err.Error())
+		}
+
+		keys[kid] = key
+	}
+
+	return keys, nil
+}
+
+// GenerateKey 生成临时的 Ed25519 密钥, 只在开发时没有配置密钥目录的情况下使用
+func GenerateKey() (*Key, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	kid, err := randomHex(8)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Key{ID: "dev-" + kid, Method: SigningMethodEdDSA, Private: private, Public: public}, nil
+}
+
+// JWKS 所有验证密钥的公钥, 按 kid 排序
+func JWKS() []*JWK {
+	jwks := make([]*JWK, 0, len(Default.Keys))
+	for _, key := range Default.Keys {
+		jwks = append(jwks, key.JWK())
+	}
+
+	sort.Slice(jwks, func(i, j int) bool {
+		return jwks[i].Kid < jwks[j].Kid
+	})
+
+	return jwks
+}
+
+// JWK 转换为 JSON Web Key
+func (k *Key) JWK() *JWK {
+	jwk := &JWK{Kid: k.ID, Use: "sig", Alg: k.Method.Alg()}
+
+	switch public := k.Public.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(public)
+	}
+
+	return jwk
+}
+
+func parseKey(kid string, data []byte) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("No PEM data found")
+	}
+
+	var parsed interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, errors.New("Unsupported PEM type " + block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key := &Key{ID: kid}
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.Method, key.Private, key.Public = jwt.SigningMethodRS256, k, &k.PublicKey
+	case *rsa.PublicKey:
+		key.Method, key.Public = jwt.SigningMethodRS256, k
+	case ed25519.PrivateKey:
+		key.Method, key.Private, key.Public = SigningMethodEdDSA, k, k.Public()
+	case ed25519.PublicKey:
+		key.Method, key.Public = SigningMethodEdDSA, k
+	default:
+		return nil, errors.New("Unsupported key type, only RSA and Ed25519")
+	}
+
+	if public, ok := key.Public.(*rsa.PublicKey); ok && public.N.BitLen() < rsaKeyBitsMin {
+		return nil, errors.New("RSA key is too short")
+	}
+
+	return key, nil
+}
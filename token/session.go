@@ -0,0 +1,307 @@
+package token
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/ztplz/blog-server/models"
+)
+
+// refresh token 的错误
+var (
+	ErrRefreshInvalid = errors.New("Invalid refresh token")
+	ErrRefreshReused  = errors.New("Refresh token reused, session revoked")
+	ErrSessionMissing = errors.New("Session not found")
+)
+
+// 数据库迁移删除重复的管理员时删除他们的会话
+func init() {
+	models.RevokeAdminSessions = func(id uint) error {
+		return Revoke(RoleAdmin, strconv.FormatUint(uint64(id), 10))
+	}
+}
+
+// Client 登录设备的信息
+type Client struct {
+	Device    string
+	IP        string
+	UserAgent string
+}
+
+// Session 一次登录, redis 里保存为 hash
+type Session struct {
+	ID         string `json:"id"`
+	Role       string `json:"-"`
+	Subject    string `json:"-"`
+	Device     string `json:"device"`
+	IP         string `json:"ip"`
+	UserAgent  string `json:"user_agent"`
+	CreateAt   string `json:"create_at"`
+	LastSeenAt string `json:"last_seen_at"`
+	Current    bool   `json:"current"`
+}
+
+// Pair 登录或者刷新后返回给客户端的 token
+type Pair struct {
+	AccessToken     string
+	RefreshToken    string
+	Claims          *Claims
+	RefreshExpireAt time.Time
+}
+
+// IssueSession 创建新的会话并签发 token, 不影响这个账号的其他会话
+func IssueSession(role string, subject string, client *Client) (*Pair, error) {
+	refreshLifetime, ok := Default.RefreshLifetimes[role]
+	if !ok {
+		return nil, errors.New("Unknown token role")
+	}
+
+	sid, err := randomHex(16)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := randomHex(32)
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, claims, err := sign(role, subject, sid)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().Format("2006-01-02 15:04:05")
+	_, err = models.RedisClient.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.HMSet(sessionKey(sid), map[string]interface{}{
+			"role":         role,
+			"subject":      subject,
+			"device":       client.Device,
+			"ip":           client.IP,
+			"user_agent":   client.UserAgent,
+			"create_at":    now,
+			"last_seen_at": now,
+			"refresh_hash": hashSecret(secret),
+		})
+		pipe.Expire(sessionKey(sid), refreshLifetime)
+		pipe.SAdd(accountKey(role, subject), sid)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Pair{
+		AccessToken:     accessToken,
+		RefreshToken:    sid + "." + secret,
+		Claims:          claims,
+		RefreshExpireAt: time.Now().Add(refreshLifetime),
+	}, nil
+}
+
+// Refresh 用 refresh token 换新的 token, 旧的 refresh token 失效。
+// 用过的 refresh token 再次使用说明可能被盗, 删除整个会话
+func Refresh(refreshToken string, client *Client) (*Pair, error) {
+	parts := strings.SplitN(refreshToken, ".", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, ErrRefreshInvalid
+	}
+	sid, hash := parts[0], hashSecret(parts[1])
+
+	var pair *Pair
+	key := sessionKey(sid)
+	err := models.RedisClient.Watch(func(tx *redis.Tx) error {
+		values, err := tx.HGetAll(key).Result()
+		if err != nil {
+			return err
+		}
+		if len(values) == 0 {
+			return ErrRefreshInvalid
+		}
+
+		role, subject := values["role"], values["subject"]
+		if values["refresh_hash"] != hash {
+			used, err := tx.SIsMember(usedKey(sid), hash).Result()
+			if err != nil {
+				return err
+			}
+			if used {
+				deleteSession(role, subject, sid)
+
+				return ErrRefreshReused
+			}
+
+			return ErrRefreshInvalid
+		}
+
+		refreshLifetime := Default.RefreshLifetimes[role]
+		secret, err := randomHex(32)
+		if err != nil {
+			return err
+		}
+
+		accessToken, claims, err := sign(role, subject, sid)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Pipelined(func(pipe redis.Pipeliner) error {
+			pipe.HMSet(key, map[string]interface{}{
+				"ip":           client.IP,
+				"user_agent":   client.UserAgent,
+				"last_seen_at": time.Now().Format("2006-01-02 15:04:05"),
+				"refresh_hash": hashSecret(secret),
+			})
+			pipe.Expire(key, refreshLifetime)
+			pipe.SAdd(usedKey(sid), hash)
+			pipe.Expire(usedKey(sid), refreshLifetime)
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		pair = &Pair{
+			AccessToken:     accessToken,
+			RefreshToken:    sid + "." + secret,
+			Claims:          claims,
+			RefreshExpireAt: time.Now().Add(refreshLifetime),
+		}
+
+		return nil
+	}, key)
+
+	// 同时有别的请求刷新了这个会话
+	if err == redis.TxFailedErr {
+		return nil, ErrRefreshInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return pair, nil
+}
+
+// Sessions 获取账号所有有效的会话, 按最近使用时间排序
+func Sessions(role string, subject string) ([]*Session, error) {
+	sids, err := models.RedisClient.SMembers(accountKey(role, subject)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]*Session, 0, len(sids))
+	for _, sid := range sids {
+		values, err := models.RedisClient.HGetAll(sessionKey(sid)).Result()
+		if err != nil {
+			return nil, err
+		}
+
+		// 会话已经过期
+		if len(values) == 0 {
+			models.RedisClient.SRem(accountKey(role, subject), sid)
+
+			continue
+		}
+
+		sessions = append(sessions, &Session{
+			ID:         sid,
+			Role:       values["role"],
+			Subject:    values["subject"],
+			Device:     values["device"],
+			IP:         values["ip"],
+			UserAgent:  values["user_agent"],
+			CreateAt:   values["create_at"],
+			LastSeenAt: values["last_seen_at"],
+		})
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeenAt > sessions[j].LastSeenAt
+	})
+
+	return sessions, nil
+}
+
+// RevokeSession 删除账号的某个会话, 会话不属于这个账号时返回 ErrSessionMissing
+func RevokeSession(role string, subject string, sid string) error {
+	ok, err := models.RedisClient.SIsMember(accountKey(role, subject), sid).Result()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrSessionMissing
+	}
+
+	return deleteSession(role, subject, sid)
+}
+
+// Revoke 删除账号的所有会话, 之前签发的 token 全部失效
+func Revoke(role string, subject string) error {
+	sids, err := models.RedisClient.SMembers(accountKey(role, subject)).Result()
+	if err != nil {
+		return err
+	}
+
+	_, err = models.RedisClient.TxPipelined(func(pipe redis.Pipeliner) error {
+		for _, sid := range sids {
+			pipe.Del(sessionKey(sid), usedKey(sid))
+		}
+		pipe.Del(accountKey(role, subject))
+
+		return nil
+	})
+
+	return err
+}
+
+// 检查 access token 的会话还存在, 并更新最近使用时间
+func touchSession(claims *Claims) error {
+	key := sessionKey(claims.SessionID)
+	values, err := models.RedisClient.HMGet(key, "role", "subject").Result()
+	if err != nil || values[0] != claims.Role || values[1] != claims.Subject {
+		return ErrRevoked
+	}
+
+	models.RedisClient.HSet(key, "last_seen_at", time.Now().Format("2006-01-02 15:04:05"))
+
+	return nil
+}
+
+func deleteSession(role string, subject string, sid string) error {
+	_, err := models.RedisClient.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.Del(sessionKey(sid), usedKey(sid))
+		pipe.SRem(accountKey(role, subject), sid)
+
+		return nil
+	})
+
+	return err
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+
+	return hex.EncodeToString(sum[:])
+}
+
+func sessionKey(sid string) string {
+	return "session_" + sid
+}
+
+// 用过的 refresh token
+func usedKey(sid string) string {
+	return "session_used_" + sid
+}
+
+// 账号的所有会话 ID
+func accountKey(role string, subject string) string {
+	return "sessions_" + role + "_" + subject
+}
@@ -0,0 +1,125 @@
+package token
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/ztplz/blog-server/models"
+)
+
+// 使用临时密钥的配置, 返回恢复原配置的函数
+func setupTestConfig(t *testing.T) func() {
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatalf("GenerateKey error: %v", err)
+	}
+
+	old := Default
+	Default = &Config{
+		Issuer:           "blog-server-test",
+		Keys:             map[string]*Key{key.ID: key},
+		SigningKey:       key,
+		Lifetimes:        map[string]time.Duration{RoleAdmin: time.Minute, RoleUser: time.Minute},
+		RefreshLifetimes: map[string]time.Duration{RoleAdmin: time.Hour, RoleUser: time.Hour},
+	}
+
+	return func() { Default = old }
+}
+
+// 连接本地的 redis, 连接不上时跳过测试, 返回恢复原连接的函数
+func setupTestRedis(t *testing.T) func() {
+	client := redis.NewClient(&redis.Options{Addr: "localhost:6379"})
+	if err := client.Ping().Err(); err != nil {
+		client.Close()
+		t.Skipf("redis not available: %v", err)
+	}
+
+	old := models.RedisClient
+	models.RedisClient = client
+
+	return func() {
+		client.Close()
+		models.RedisClient = old
+	}
+}
+
+func TestRefreshRotation(t *testing.T) {
+	defer setupTestConfig(t)()
+	defer setupTestRedis(t)()
+
+	subject, _ := randomHex(8)
+	defer Revoke(RoleUser, subject)
+
+	client := &Client{Device: "test", IP: "127.0.0.1"}
+	first, err := IssueSession(RoleUser, subject, client)
+	if err != nil {
+		t.Fatalf("IssueSession error: %v", err)
+	}
+
+	second, err := Refresh(first.RefreshToken, client)
+	if err != nil {
+		t.Fatalf("Refresh error: %v", err)
+	}
+	if second.RefreshToken == first.RefreshToken {
+		t.Fatal("Refresh returned the same refresh token")
+	}
+	if second.Claims.SessionID != first.Claims.SessionID {
+		t.Errorf("Refresh session = %q, want %q", second.Claims.SessionID, first.Claims.SessionID)
+	}
+
+	_, err = Verify(second.AccessToken, RoleUser)
+	if err != nil {
+		t.Fatalf("Verify refreshed access token error: %v", err)
+	}
+
+	third, err := Refresh(second.RefreshToken, client)
+	if err != nil {
+		t.Fatalf("Refresh rotated token error: %v", err)
+	}
+
+	// 旧的 refresh token 再次使用时删除整个会话, 新的 token 也一起失效
+	_, err = Refresh(first.RefreshToken, client)
+	if err != ErrRefreshReused {
+		t.Fatalf("Refresh reused token error = %v, want %v", err, ErrRefreshReused)
+	}
+
+	_, err = Refresh(third.RefreshToken, client)
+	if err != ErrRefreshInvalid {
+		t.Errorf("Refresh after reuse error = %v, want %v", err, ErrRefreshInvalid)
+	}
+
+	_, err = Verify(third.AccessToken, RoleUser)
+	if err != ErrRevoked {
+		t.Errorf("Verify after reuse error = %v, want %v", err, ErrRevoked)
+	}
+
+	sessions, err := Sessions(RoleUser, subject)
+	if err != nil {
+		t.Fatalf("Sessions error: %v", err)
+	}
+	if len(sessions) != 0 {
+		t.Errorf("Sessions after reuse = %d, want 0", len(sessions))
+	}
+}
+
+func TestRefreshInvalid(t *testing.T) {
+	defer setupTestConfig(t)()
+	defer setupTestRedis(t)()
+
+	sid, _ := randomHex(16)
+	tests := []string{
+		"",
+		"noseparator",
+		".secret",
+		sid + ".",
+		sid + ".secret",
+	}
+
+	for _, refreshToken := range tests {
+		_, err := Refresh(refreshToken, &Client{})
+		if err != ErrRefreshInvalid {
+			t.Errorf("Refresh(%q) error = %v, want %v", refreshToken, err, ErrRefreshInvalid)
+		}
+	}
+}
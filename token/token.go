@@ -0,0 +1,316 @@
+/*
+* 登录 token
+*
+* 管理员和用户的 token 都在这里签发和验证。access token 是 JWT, claims 里有用户 ID (sub)、
+* 角色 (role)、会话 ID (sid) 和签发时间 (iat), 有效时间很短, 过期后用 refresh token 换新的。
+* 每次登录创建一个会话, 同一个账号可以在多个设备上同时登录, 会话删除后它的 token 立即失效。
+*
+* token 用 RS256 或 EdDSA 签名, 头部的 kid 指明使用的密钥。轮换密钥时新密钥用来签名,
+* 旧密钥的公钥继续用于验证, 直到旧 token 全部过期。其他服务可以从 /.well-known/jwks.json 获取公钥验证 token
+ */
+
+package token
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	log "github.com/sirupsen/logrus"
+)
+
+// token 的角色
+const (
+	RoleAdmin = "admin"
+	RoleUser  = "user"
+)
+
+// 验证失败的原因
+var (
+	ErrMissing = errors.New("Auth header empty")
+	ErrInvalid = errors.New("Invalid token")
+	ErrExpired = errors.New("Token is expired")
+	ErrRevoked = errors.New("You don't login in or token is revoked")
+)
+
+// Claims token 里保存的内容, Subject 为管理员 ID 或者用户 ID
+type Claims struct {
+	Role      string `json:"role"`
+	SessionID string `json:"sid"`
+	jwt.StandardClaims
+}
+
+// Config token 的配置
+type Config struct {
+	// 签发者, 验证时检查
+	Issuer string
+
+	// 所有验证密钥, key 为 kid
+	Keys map[string]*Key
+
+	// 当前签名用的密钥
+	SigningKey *Key
+
+	// 每个角色 access token 的有效时间
+	Lifetimes map[string]time.Duration
+
+	// 每个角色 refresh token 的有效时间, 每次刷新后重新计算
+	RefreshLifetimes map[string]time.Duration
+}
+
+// Default 当前使用的配置
+var Default *Config
+
+// InitialToken 初始化 token 配置, 密钥从环境变量 BLOG_TOKEN_KEY_DIR 指定的目录读取,
+// BLOG_TOKEN_SIGNING_KEY 指定签名用的 kid, 目录里只有一个私钥时可以不填。
+// 签发者和有效时间可以用下面的环境变量修改, 时间的格式和 time.ParseDuration 一样, 比如 15m、168h:
+//
+//	BLOG_TOKEN_ISSUER              签发者, 默认 blog-server
+//	BLOG_TOKEN_ADMIN_TTL           管理员 access token, 默认 15m
+//	BLOG_TOKEN_USER_TTL            用户 access token, 默认 15m
+//	BLOG_TOKEN_ADMIN_REFRESH_TTL   管理员 refresh token, 默认 7 天
+//	BLOG_TOKEN_USER_REFRESH_TTL    用户 refresh token, 默认 30 天
+func InitialToken() {
+	Default = &Config{
+		Issuer: "blog-server",
+		Lifetimes: map[string]time.Duration{
+			RoleAdmin: envDuration("BLOG_TOKEN_ADMIN_TTL", time.Minute*15),
+			RoleUser:  envDuration("BLOG_TOKEN_USER_TTL", time.Minute*15),
+		},
+		RefreshLifetimes: map[string]time.Duration{
+			RoleAdmin: envDuration("BLOG_TOKEN_ADMIN_REFRESH_TTL", time.Hour*24*7),
+			RoleUser:  envDuration("BLOG_TOKEN_USER_REFRESH_TTL", time.Hour*24*30),
+		},
+	}
+
+	if issuer := os.Getenv("BLOG_TOKEN_ISSUER"); issuer != "" {
+		Default.Issuer = issuer
+	}
+
+	// refresh token 比 access token 先过期时无法刷新
+	for role, lifetime := range Default.Lifetimes {
+		if Default.RefreshLifetimes[role] <= lifetime {
+			log.WithFields(log.Fields{
+				"role":     role,
+				"lifetime": lifetime.String(),
+				"refresh":  Default.RefreshLifetimes[role].String(),
+			}).Fatal("Refresh token lifetime must be longer than access token lifetime")
+		}
+	}
+
+	dir := os.Getenv("BLOG_TOKEN_KEY_DIR")
+
+	// 开发时使用临时生成的密钥, 重启后之前的 token 失效
+	if dir == "" {
+		key, err := GenerateKey()
+		if err != nil {
+			log.WithFields(log.Fields{
+				"errorMsg": err,
+			}).Fatal("Generate token key failed")
+		}
+
+		Default.Keys = map[string]*Key{key.ID: key}
+		Default.SigningKey = key
+		log.Info("BLOG_TOKEN_KEY_DIR is empty, use temporary development key")
+	} else {
+		keys, err := LoadKeys(dir)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"errorMsg": err,
+				"dir":      dir,
+			}).Fatal("Load token keys failed")
+		}
+
+		signingKey, err := signingKey(keys, os.Getenv("BLOG_TOKEN_SIGNING_KEY"))
+		if err != nil {
+			log.WithFields(log.Fields{
+				"errorMsg": err,
+				"dir":      dir,
+			}).Fatal("Load token keys failed")
+		}
+
+		Default.Keys = keys
+		Default.SigningKey = signingKey
+	}
+
+	kids := make([]string, 0, len(Default.Keys))
+	for kid := range Default.Keys {
+		kids = append(kids, kid)
+	}
+
+	log.WithFields(log.Fields{
+		"issuer":     Default.Issuer,
+		"lifetimes":  Default.Lifetimes,
+		"refresh":    Default.RefreshLifetimes,
+		"signingKey": Default.SigningKey.ID,
+		"keys":       kids,
+	}).Info("Token initial success")
+}
+
+// 读取环境变量里的时间, 没有设置时返回 defaultValue, 格式错误或者不是正数时退出
+func envDuration(name string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue
+	}
+
+	d, err := time.ParseDuration(value)
+	if err == nil && d <= 0 {
+		err = errors.New("Duration must be positive")
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"errorMsg": err,
+			"name":     name,
+			"value":    value,
+		}).Fatal("Parse token config failed")
+	}
+
+	return d
+}
+
+// 给会话签发 access token
+func sign(role string, subject string, sid string) (string, *Claims, error) {
+	lifetime, ok := Default.Lifetimes[role]
+	if !ok {
+		return "", nil, errors.New("Unknown token role")
+	}
+
+	now := time.Now()
+	claims := &Claims{
+		Role:      role,
+		SessionID: sid,
+		StandardClaims: jwt.StandardClaims{
+			Subject:   subject,
+			Issuer:    Default.Issuer,
+			IssuedAt:  now.Unix(),
+			NotBefore: now.Unix(),
+			ExpiresAt: now.Add(lifetime).Unix(),
+		},
+	}
+
+	t := jwt.NewWithClaims(Default.SigningKey.Method, claims)
+	t.Header["kid"] = Default.SigningKey.ID
+
+	signed, err := t.SignedString(Default.SigningKey.Private)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return signed, claims, nil
+}
+
+// Parse 验证签名、签发者、角色和过期时间, 不检查会话是否有效。role 为空时管理员和用户的 token 都可以
+func Parse(tokenString string, role string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		key, ok := Default.Keys[kid]
+		if !ok {
+			return nil, errors.New("Unknown key id")
+		}
+
+		// 算法必须和密钥一致, 防止用公钥当作 HMAC 密钥伪造 token
+		if t.Method.Alg() != key.Method.Alg() {
+			return nil, errors.New("Invalid signing algorithm")
+		}
+
+		return key.Public, nil
+	})
+	if err != nil {
+		if e, ok := err.(*jwt.ValidationError); ok && e.Errors&jwt.ValidationErrorExpired != 0 {
+			return nil, ErrExpired
+		}
+
+		return nil, ErrInvalid
+	}
+
+	if _, ok := Default.Lifetimes[claims.Role]; !ok || (role != "" && claims.Role != role) {
+		return nil, ErrInvalid
+	}
+
+	if claims.Issuer != Default.Issuer || claims.Subject == "" || claims.SessionID == "" {
+		return nil, ErrInvalid
+	}
+
+	return claims, nil
+}
+
+// Verify 验证 token, 并检查会话是否还有效
+func Verify(tokenString string, role string) (*Claims, error) {
+	claims, err := Parse(tokenString, role)
+	if err != nil {
+		return nil, err
+	}
+
+	err = touchSession(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// FromHeader 从请求头 Authorization: Bearer <token> 提取 token
+func FromHeader(r *http.Request) (string, error) {
+	authHeader := r.Header.Get("Authorization")
+	if authHeader == "" {
+		return "", ErrMissing
+	}
+
+	parts := strings.SplitN(authHeader, " ", 2)
+	if !(len(parts) == 2 && parts[0] == "Bearer") {
+		return "", errors.New("Invalid auth header")
+	}
+
+	return parts[1], nil
+}
+
+// ExpireAt token 的过期时间
+func (c *Claims) ExpireAt() time.Time {
+	return time.Unix(c.ExpiresAt, 0)
+}
+
+// 选出签名用的密钥, 没有指定 kid 时目录里必须只有一个私钥
+func signingKey(keys map[string]*Key, kid string) (*Key, error) {
+	if kid != "" {
+		key, ok := keys[kid]
+		if !ok || key.Private == nil {
+			return nil, errors.New("Signing key " + kid + " not found or has no private key")
+		}
+
+		return key, nil
+	}
+
+	var found *Key
+	for _, key := range keys {
+		if key.Private == nil {
+			continue
+		}
+		if found != nil {
+			return nil, errors.New("More than one private key, set BLOG_TOKEN_SIGNING_KEY")
+		}
+		found = key
+	}
+	if found == nil {
+		return nil, errors.New("No private key found")
+	}
+
+	return found, nil
+}
+
+// 随机字符串, 用作会话 ID 和 refresh token
+func randomHex(size int) (string, error) {
+	b := make([]byte, size)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,140 @@
+package token
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+func testClaims(role string) *Claims {
+	now := time.Now()
+
+	return &Claims{
+		Role:      role,
+		SessionID: "sid",
+		StandardClaims: jwt.StandardClaims{
+			Subject:   "subject",
+			Issuer:    Default.Issuer,
+			IssuedAt:  now.Unix(),
+			NotBefore: now.Unix(),
+			ExpiresAt: now.Add(time.Minute).Unix(),
+		},
+	}
+}
+
+func testSign(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, claims *Claims) string {
+	tk := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		tk.Header["kid"] = kid
+	}
+
+	signed, err := tk.SignedString(key)
+	if err != nil {
+		t.Fatalf("sign token error: %v", err)
+	}
+
+	return signed
+}
+
+func TestParse(t *testing.T) {
+	defer setupTestConfig(t)()
+
+	edKey := Default.SigningKey
+
+	rsaPrivate, err := rsa.GenerateKey(rand.Reader, rsaKeyBitsMin)
+	if err != nil {
+		t.Fatalf("generate rsa key error: %v", err)
+	}
+	Default.Keys["rsa"] = &Key{ID: "rsa", Method: jwt.SigningMethodRS256, Private: rsaPrivate, Public: &rsaPrivate.PublicKey}
+
+	// 轮换前的密钥, 只保留公钥
+	_, oldPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate ed25519 key error: %v", err)
+	}
+	Default.Keys["old"] = &Key{ID: "old", Method: SigningMethodEdDSA, Public: oldPrivate.Public()}
+
+	otherRSA, err := rsa.GenerateKey(rand.Reader, rsaKeyBitsMin)
+	if err != nil {
+		t.Fatalf("generate rsa key error: %v", err)
+	}
+
+	expired := testClaims(RoleUser)
+	expired.ExpiresAt = time.Now().Add(-time.Minute).Unix()
+
+	otherIssuer := testClaims(RoleUser)
+	otherIssuer.Issuer = "other"
+
+	noSession := testClaims(RoleUser)
+	noSession.SessionID = ""
+
+	unknownRole := testClaims("root")
+
+	noneToken := testSign(t, jwt.SigningMethodNone, edKey.ID, jwt.UnsafeAllowNoneSignatureType, testClaims(RoleUser))
+
+	tests := []struct {
+		name  string
+		token string
+		role  string
+		want  error
+	}{
+		{"eddsa", testSign(t, SigningMethodEdDSA, edKey.ID, edKey.Private, testClaims(RoleUser)), RoleUser, nil},
+		{"rsa", testSign(t, jwt.SigningMethodRS256, "rsa", rsaPrivate, testClaims(RoleAdmin)), RoleAdmin, nil},
+		{"any role", testSign(t, jwt.SigningMethodRS256, "rsa", rsaPrivate, testClaims(RoleAdmin)), "", nil},
+		{"rotated key", testSign(t, SigningMethodEdDSA, "old", oldPrivate, testClaims(RoleUser)), RoleUser, nil},
+
+		{"missing kid", testSign(t, SigningMethodEdDSA, "", edKey.Private, testClaims(RoleUser)), RoleUser, ErrInvalid},
+		{"unknown kid", testSign(t, SigningMethodEdDSA, "unknown", edKey.Private, testClaims(RoleUser)), RoleUser, ErrInvalid},
+		{"wrong key for kid", testSign(t, jwt.SigningMethodRS256, "rsa", otherRSA, testClaims(RoleUser)), RoleUser, ErrInvalid},
+		// kid 是 Ed25519 密钥, 头部却声明 RS256
+		{"alg mismatch", testSign(t, jwt.SigningMethodRS256, edKey.ID, rsaPrivate, testClaims(RoleUser)), RoleUser, ErrInvalid},
+		// 用公钥当作 HMAC 密钥伪造的 token
+		{"hmac with public key", testSign(t, jwt.SigningMethodHS256, edKey.ID, []byte(edKey.Public.(ed25519.PublicKey)), testClaims(RoleUser)), RoleUser, ErrInvalid},
+		{"alg none", noneToken, RoleUser, ErrInvalid},
+
+		{"expired", testSign(t, SigningMethodEdDSA, edKey.ID, edKey.Private, expired), RoleUser, ErrExpired},
+		{"role mismatch", testSign(t, SigningMethodEdDSA, edKey.ID, edKey.Private, testClaims(RoleUser)), RoleAdmin, ErrInvalid},
+		{"unknown role", testSign(t, SigningMethodEdDSA, edKey.ID, edKey.Private, unknownRole), "", ErrInvalid},
+		{"other issuer", testSign(t, SigningMethodEdDSA, edKey.ID, edKey.Private, otherIssuer), RoleUser, ErrInvalid},
+		{"missing session", testSign(t, SigningMethodEdDSA, edKey.ID, edKey.Private, noSession), RoleUser, ErrInvalid},
+		{"malformed", "not.a.token", RoleUser, ErrInvalid},
+	}
+
+	for _, test := range tests {
+		claims, err := Parse(test.token, test.role)
+		if err != test.want {
+			t.Errorf("%s: Parse error = %v, want %v", test.name, err, test.want)
+
+			continue
+		}
+		if err == nil && (claims.Subject != "subject" || claims.SessionID != "sid") {
+			t.Errorf("%s: Parse claims = %+v", test.name, claims)
+		}
+	}
+}
+
+func TestSignParse(t *testing.T) {
+	defer setupTestConfig(t)()
+
+	signed, claims, err := sign(RoleAdmin, "admin", "sid")
+	if err != nil {
+		t.Fatalf("sign error: %v", err)
+	}
+
+	parsed, err := Parse(signed, RoleAdmin)
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	if parsed.Subject != claims.Subject || parsed.SessionID != claims.SessionID || parsed.ExpiresAt != claims.ExpiresAt {
+		t.Errorf("Parse claims = %+v, want %+v", parsed, claims)
+	}
+
+	_, _, err = sign("root", "admin", "sid")
+	if err == nil {
+		t.Error("sign with unknown role succeeded")
+	}
+}
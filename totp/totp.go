@@ -0,0 +1,124 @@
+/*
+* TOTP 动态验证码 (RFC 6238)
+*
+* 使用 HMAC-SHA1, 6 位数字, 30 秒一个周期, 和 Google Authenticator 等应用兼容。
+* 密钥用 base32 编码保存和展示
+ */
+
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// Digits 验证码位数
+	Digits = 6
+
+	// Period 每个验证码的有效周期
+	Period = 30 * time.Second
+
+	// 密钥长度, RFC 4226 推荐 160 位
+	secretSize = 20
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret 生成随机密钥, 返回 base32 编码
+func GenerateSecret() (string, error) {
+	b := make([]byte, secretSize)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return encoding.EncodeToString(b), nil
+}
+
+// Code 计算 t 时刻的验证码
+func Code(secret string, t time.Time) (string, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return "", err
+	}
+
+	return hotp(key, step(t)), nil
+}
+
+// Validate 验证 code, 允许前后 skew 个周期的时间误差, 返回匹配的周期编号用于防止重放
+func Validate(secret string, code string, t time.Time, skew int) (int64, bool) {
+	code = strings.TrimSpace(code)
+	if len(code) != Digits {
+		return 0, false
+	}
+
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return 0, false
+	}
+
+	current := step(t)
+	for i := -skew; i <= skew; i++ {
+		s := current + int64(i)
+		if subtle.ConstantTimeCompare([]byte(hotp(key, s)), []byte(code)) == 1 {
+			return s, true
+		}
+	}
+
+	return 0, false
+}
+
+// URI 生成 otpauth:// 链接, 可以做成二维码给验证器应用扫描
+func URI(issuer string, account string, secret string) string {
+	label := url.PathEscape(issuer + ":" + account)
+
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(Digits))
+	query.Set("period", fmt.Sprint(int(Period/time.Second)))
+
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// 解析 base32 密钥, 忽略大小写、空格和填充
+func decodeSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.Replace(secret, " ", "", -1))
+	secret = strings.TrimRight(secret, "=")
+
+	return encoding.DecodeString(secret)
+}
+
+func step(t time.Time) int64 {
+	return t.Unix() / int64(Period/time.Second)
+}
+
+// RFC 4226 的 HOTP 算法
+func hotp(key []byte, counter int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < Digits; i++ {
+		mod *= 10
+	}
+
+	return fmt.Sprintf("%0*d", Digits, value%mod)
+}
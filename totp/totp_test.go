@@ -0,0 +1,95 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// RFC 6238 附录 B 的 SHA1 密钥 "12345678901234567890" 的 base32 编码
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// RFC 6238 附录 B 的测试向量, 验证码取 8 位结果的后 6 位
+var rfcVectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+	{20000000000, "353130"},
+}
+
+func TestCode(t *testing.T) {
+	for _, test := range rfcVectors {
+		got, err := Code(rfcSecret, time.Unix(test.unix, 0))
+		if err != nil {
+			t.Fatalf("Code(%d) error: %v", test.unix, err)
+		}
+		if got != test.code {
+			t.Errorf("Code(%d) = %q, want %q", test.unix, got, test.code)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, test := range rfcVectors {
+		at := time.Unix(test.unix, 0)
+		s, ok := Validate(rfcSecret, test.code, at, 0)
+		if !ok || s != test.unix/30 {
+			t.Errorf("Validate(%q, %d) = %d, %v, want %d, true", test.code, test.unix, s, ok, test.unix/30)
+		}
+	}
+
+	// 1111111109 和 1111111111 在相邻的两个周期
+	at := time.Unix(1111111111, 0)
+	tests := []struct {
+		code   string
+		skew   int
+		want   int64
+		wantOK bool
+	}{
+		{"050471", 0, 37037037, true},
+		{"081804", 0, 0, false},
+		{"081804", 1, 37037036, true},
+		{" 050471 ", 0, 37037037, true},
+		{"000000", 1, 0, false},
+		{"05047", 1, 0, false},
+		{"0504711", 1, 0, false},
+		{"", 1, 0, false},
+	}
+
+	for _, test := range tests {
+		s, ok := Validate(rfcSecret, test.code, at, test.skew)
+		if s != test.want || ok != test.wantOK {
+			t.Errorf("Validate(%q, skew %d) = %d, %v, want %d, %v", test.code, test.skew, s, ok, test.want, test.wantOK)
+		}
+	}
+}
+
+func TestValidateSecret(t *testing.T) {
+	at := time.Unix(59, 0)
+
+	// 忽略大小写和空格
+	if _, ok := Validate("gezd gnbv gy3t qojq gezd gnbv gy3t qojq", "287082", at, 0); !ok {
+		t.Error("Validate with lower case secret failed")
+	}
+
+	if _, ok := Validate("not base32!", "287082", at, 0); ok {
+		t.Error("Validate with invalid secret succeeded")
+	}
+}
+
+// RFC 4226 附录 D 的 HOTP 测试向量
+func TestHOTP(t *testing.T) {
+	want := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+
+	key := []byte("12345678901234567890")
+	for counter, code := range want {
+		got := hotp(key, int64(counter))
+		if got != code {
+			t.Errorf("hotp(%d) = %q, want %q", counter, got, code)
+		}
+	}
+}